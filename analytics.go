@@ -0,0 +1,598 @@
+package gitsapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voodooEntity/gitsapi/src/analytics"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// the analyses a job can run
+const (
+	AnalysisDegree       = "degree"
+	AnalysisTopConnected = "topConnected"
+	AnalysisComponents   = "components"
+	AnalysisPageRank     = "pageRank"
+	AnalysisBetweenness  = "betweenness"
+)
+
+// the states of an analytics job
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// AnalyticsRequest is the body starting an analytics job
+type AnalyticsRequest struct {
+	Analysis string
+	// the entity types to analyse, all readable ones if empty
+	Types []string
+	// only relations of this context connect the entities
+	Context string
+	// amount of listed entities or components, per type for topConnected
+	Limit int
+	// pageRank only
+	Damping    float64
+	Iterations int
+	// betweenness only, follow relations in their direction
+	Directed bool
+}
+
+// AnalyticsJob is an analysis running in the background. The Result is
+// set once the Status is done, the Error if it failed. Owner is the
+// identity which started the job, the result only contains what it may
+// read, so only it and admins can see the job.
+type AnalyticsJob struct {
+	ID       string
+	Storage  string
+	Owner    string
+	Request  AnalyticsRequest
+	Status   string
+	Created  time.Time
+	Started  *time.Time  `json:",omitempty"`
+	Finished *time.Time  `json:",omitempty"`
+	Error    *apiError   `json:",omitempty"`
+	Result   interface{} `json:",omitempty"`
+	cancel   context.CancelFunc
+}
+
+// EntityRef names an entity of an analytics result
+type EntityRef struct {
+	Type  string
+	ID    int
+	Value string
+}
+
+// DegreeScore counts the relations of an entity, a relation to itself
+// counts in both directions
+type DegreeScore struct {
+	EntityRef
+	In     int
+	Out    int
+	Degree int
+}
+
+// EntityScore is the pageRank or betweenness score of an entity
+type EntityScore struct {
+	EntityRef
+	Score float64
+}
+
+// Component is a set of entities connected by relations of any direction,
+// Entity is its first member to start traversals from
+type Component struct {
+	Size   int
+	Types  map[string]int
+	Entity EntityRef
+}
+
+// DegreeResult lists the entities by degree, Total is the amount before
+// Limit applied like in the other results
+type DegreeResult struct {
+	Entities []DegreeScore
+	Amount   int
+	Total    int
+}
+
+// TopConnectedResult lists the entities with the highest degree per type
+type TopConnectedResult struct {
+	Types map[string][]DegreeScore
+}
+
+// ComponentResult lists the components by size
+type ComponentResult struct {
+	Components []Component
+	Amount     int
+	Total      int
+}
+
+// ScoreResult lists the entities by score
+type ScoreResult struct {
+	Entities []EntityScore
+	Amount   int
+	Total    int
+	// pageRank only, the iterations it took to converge
+	Iterations int `json:",omitempty"`
+}
+
+// analyticsRunner keeps the jobs of a server. At most ANALYTICS_WORKERS
+// jobs run at once, finished ones are dropped after ANALYTICS_JOB_TTL.
+// Every identity may have ANALYTICS_MAX_QUEUED jobs queued or running.
+type analyticsRunner struct {
+	mutex     *sync.Mutex
+	jobs      map[string]*AnalyticsJob
+	slots     chan struct{}
+	ttl       time.Duration
+	maxQueued int
+	// cancels all jobs on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (s *Server) loadAnalytics() error {
+	workers, err := strconv.Atoi(s.config.GetOptionalValue("ANALYTICS_WORKERS", "2"))
+	if nil != err || 1 > workers {
+		return errors.New("Invalid ANALYTICS_WORKERS '" + s.config.GetOptionalValue("ANALYTICS_WORKERS", "") + "', expected a number of at least 1")
+	}
+	ttl, err := time.ParseDuration(s.config.GetOptionalValue("ANALYTICS_JOB_TTL", "1h"))
+	if nil != err || 0 >= ttl {
+		return errors.New("Invalid ANALYTICS_JOB_TTL '" + s.config.GetOptionalValue("ANALYTICS_JOB_TTL", "") + "', expected a duration like '1h'")
+	}
+	maxQueued, err := strconv.Atoi(s.config.GetOptionalValue("ANALYTICS_MAX_QUEUED", "10"))
+	if nil != err || 1 > maxQueued {
+		return errors.New("Invalid ANALYTICS_MAX_QUEUED '" + s.config.GetOptionalValue("ANALYTICS_MAX_QUEUED", "") + "', expected a number of at least 1")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.analytics = &analyticsRunner{
+		mutex:     &sync.Mutex{},
+		jobs:      make(map[string]*AnalyticsJob),
+		slots:     make(chan struct{}, workers),
+		ttl:       ttl,
+		maxQueued: maxQueued,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	return nil
+}
+
+func (s *Server) registerAnalyticsRoutes() {
+	// Route: /v1/analytics/jobs
+	s.handleFunc("/v1/analytics/jobs", s.requireScope(auth.ScopeRead, s.analytics.handleJobs))
+
+	// Route: /v1/analytics/job
+	s.handleFunc("/v1/analytics/job", s.requireScope(auth.ScopeRead, s.analytics.handleJob))
+}
+
+// handleJobs lists the jobs of the storage or starts a new one
+func (ar *analyticsRunner) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		respondJson(ar.list(storageFromRequest(r).Name, identityFromRequest(r)), 200, w)
+	case "POST":
+		var request AnalyticsRequest
+		if apiErr := decodeJsonBody(r, &request); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		job, apiErr := ar.start(storageFromRequest(r), identityFromRequest(r), grantFromRequest(r), request)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		w.Header().Set("Location", "/v1/analytics/job?id="+job.ID)
+		respondJson(job, http.StatusAccepted, w)
+	default:
+		respondError(methodNotAllowedError("GET, POST"), w)
+	}
+}
+
+// handleJob polls or removes a job, jobs of other storages and other
+// identities are unknown
+func (ar *analyticsRunner) handleJob(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "GET" != r.Method && "DELETE" != r.Method {
+		respondError(methodNotAllowedError("GET, DELETE"), w)
+		return
+	}
+
+	urlParams, apiErr := getRequiredUrlParams(map[string]string{"id": ""}, r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	storage := storageFromRequest(r).Name
+	identity := identityFromRequest(r)
+	if "DELETE" == r.Method {
+		if apiErr := ar.remove(storage, identity, urlParams["id"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		respond("", 200, w)
+		return
+	}
+	job, apiErr := ar.get(storage, identity, urlParams["id"])
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respondJson(job, 200, w)
+}
+
+// start checks the request and queues the job. The grant is kept, so the
+// job only sees what the caller may read.
+func (ar *analyticsRunner) start(g *instance, identity *auth.Identity, grant *auth.Grant, request AnalyticsRequest) (AnalyticsJob, *apiError) {
+	request, apiErr := checkAnalyticsRequest(g, grant, request)
+	if nil != apiErr {
+		return AnalyticsJob{}, apiErr
+	}
+	id, err := newJobID()
+	if nil != err {
+		return AnalyticsJob{}, internalError(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(ar.ctx)
+	job := &AnalyticsJob{
+		ID:      id,
+		Storage: g.Name,
+		Owner:   identity.Name,
+		Request: request,
+		Status:  JobQueued,
+		Created: time.Now(),
+		cancel:  cancel,
+	}
+	ar.mutex.Lock()
+	ar.prune()
+	pending := 0
+	for _, other := range ar.jobs {
+		if other.Owner == job.Owner && nil == other.Finished {
+			pending++
+		}
+	}
+	if ar.maxQueued <= pending {
+		ar.mutex.Unlock()
+		cancel()
+		return AnalyticsJob{}, newApiError(http.StatusTooManyRequests, CodeTooManyJobs, "Too many analytics jobs queued or running, wait for them to finish", map[string]string{"limit": strconv.Itoa(ar.maxQueued)})
+	}
+	ar.jobs[id] = job
+	view := *job
+	ar.mutex.Unlock()
+
+	go ar.run(ctx, g, grant, job)
+	return view, nil
+}
+
+func (ar *analyticsRunner) run(ctx context.Context, g *instance, grant *auth.Grant, job *AnalyticsJob) {
+	defer job.cancel()
+	select {
+	case ar.slots <- struct{}{}:
+		defer func() { <-ar.slots }()
+	case <-ctx.Done():
+		ar.finish(job, nil, internalError("The analysis has been cancelled"))
+		return
+	}
+
+	started := time.Now()
+	ar.mutex.Lock()
+	job.Status = JobRunning
+	job.Started = &started
+	ar.mutex.Unlock()
+
+	result, apiErr := runAnalysis(ctx, g, grant, job.Request)
+	ar.finish(job, result, apiErr)
+}
+
+func (ar *analyticsRunner) finish(job *AnalyticsJob, result interface{}, apiErr *apiError) {
+	finished := time.Now()
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	job.Finished = &finished
+	if nil != apiErr {
+		job.Status = JobFailed
+		job.Error = apiErr
+		return
+	}
+	job.Status = JobDone
+	job.Result = result
+}
+
+// list returns the visible jobs of a storage by creation, without their
+// results
+func (ar *analyticsRunner) list(storage string, identity *auth.Identity) []AnalyticsJob {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	ar.prune()
+	ret := []AnalyticsJob{}
+	for _, job := range ar.jobs {
+		if job.visible(storage, identity) {
+			view := *job
+			view.Result = nil
+			ret = append(ret, view)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret
+}
+
+func (ar *analyticsRunner) get(storage string, identity *auth.Identity, id string) (AnalyticsJob, *apiError) {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	ar.prune()
+	job, ok := ar.jobs[id]
+	if !ok || !job.visible(storage, identity) {
+		return AnalyticsJob{}, jobNotFoundError(id)
+	}
+	return *job, nil
+}
+
+// remove cancels the job if it didn't finish yet and drops it
+func (ar *analyticsRunner) remove(storage string, identity *auth.Identity, id string) *apiError {
+	ar.mutex.Lock()
+	defer ar.mutex.Unlock()
+	job, ok := ar.jobs[id]
+	if !ok || !job.visible(storage, identity) {
+		return jobNotFoundError(id)
+	}
+	job.cancel()
+	delete(ar.jobs, id)
+	return nil
+}
+
+// visible tells if the job belongs to the storage and may be seen by the
+// identity, admins see the jobs of everyone
+func (job *AnalyticsJob) visible(storage string, identity *auth.Identity) bool {
+	if storage != job.Storage {
+		return false
+	}
+	return job.Owner == identity.Name || identity.HasScope(auth.ScopeAdmin)
+}
+
+// prune drops the jobs finished longer than the ttl ago, the mutex has to
+// be held
+func (ar *analyticsRunner) prune() {
+	for id, job := range ar.jobs {
+		if nil != job.Finished && ar.ttl < time.Since(*job.Finished) {
+			delete(ar.jobs, id)
+		}
+	}
+}
+
+// close cancels all jobs, they finish as failed
+func (ar *analyticsRunner) close() {
+	ar.cancel()
+}
+
+func newJobID() (string, error) {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); nil != err {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+func jobNotFoundError(id string) *apiError {
+	return newApiError(http.StatusNotFound, CodeJobNotFound, "Analytics job does not exist", map[string]string{"id": id})
+}
+
+// checkAnalyticsRequest validates the request and fills in the defaults
+func checkAnalyticsRequest(g *instance, grant *auth.Grant, request AnalyticsRequest) (AnalyticsRequest, *apiError) {
+	switch request.Analysis {
+	case AnalysisDegree, AnalysisTopConnected, AnalysisComponents, AnalysisPageRank, AnalysisBetweenness:
+	case "":
+		return request, newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing analysis", map[string]string{"field": "Analysis"})
+	default:
+		return request, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown analysis '"+request.Analysis+"', expected degree, topConnected, components, pageRank or betweenness", map[string]string{"field": "Analysis"})
+	}
+
+	if 0 > request.Limit {
+		return request, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Limit can't be negative", map[string]string{"field": "Limit"})
+	}
+	if 0 == request.Limit {
+		request.Limit = 100
+		if AnalysisTopConnected == request.Analysis {
+			request.Limit = 10
+		}
+	}
+	if AnalysisPageRank == request.Analysis {
+		if 0 == request.Damping {
+			request.Damping = 0.85
+		}
+		if 0 >= request.Damping || 1 <= request.Damping {
+			return request, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Damping has to be between 0 and 1", map[string]string{"field": "Damping"})
+		}
+		if 0 == request.Iterations {
+			request.Iterations = 100
+		}
+		if 0 > request.Iterations || 1000 < request.Iterations {
+			return request, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Iterations has to be between 1 and 1000", map[string]string{"field": "Iterations"})
+		}
+	}
+
+	for _, typeStr := range request.Types {
+		if apiErr := authorizeGrant(grant, auth.ActionRead, typeStr); nil != apiErr {
+			return request, apiErr
+		}
+		if _, err := g.Storage().GetTypeIdByString(typeStr); nil != err {
+			return request, storageError(err)
+		}
+	}
+	return request, nil
+}
+
+// analyticsGraph is a copy of the analysed part of a storage, nodes are
+// ordered by type and id
+type analyticsGraph struct {
+	graph    *analytics.Graph
+	entities []EntityRef
+}
+
+// buildAnalyticsGraph copies the entities of the requested types and the
+// relations between them. Types the grant doesn't allow to read are left
+// out like in the results of other routes.
+func buildAnalyticsGraph(g *instance, grant *auth.Grant, request AnalyticsRequest) analyticsGraph {
+	entityTypes := g.Storage().GetEntityTypes()
+	typeIDs := []int{}
+	if 0 < len(request.Types) {
+		for _, typeStr := range request.Types {
+			// types dropped since the job got started are skipped
+			if typeID, err := g.Storage().GetTypeIdByString(typeStr); nil == err {
+				typeIDs = append(typeIDs, typeID)
+			}
+		}
+	} else {
+		for typeID, typeStr := range entityTypes {
+			if grant.Allows(auth.ActionRead, typeStr) {
+				typeIDs = append(typeIDs, typeID)
+			}
+		}
+	}
+	sort.Ints(typeIDs)
+
+	ret := analyticsGraph{entities: []EntityRef{}}
+	index := make(map[graphNode]int)
+	for _, typeID := range typeIDs {
+		for _, entity := range copyEntitiesOfType(g, typeID) {
+			index[graphNode{typeID: typeID, id: entity.ID}] = len(ret.entities)
+			ret.entities = append(ret.entities, EntityRef{Type: entityTypes[typeID], ID: entity.ID, Value: entity.Value})
+		}
+	}
+	ret.graph = analytics.NewGraph(len(ret.entities))
+	for _, typeID := range typeIDs {
+		for _, relation := range copyRelationsOfType(g, typeID) {
+			if "" != request.Context && request.Context != relation.Context {
+				continue
+			}
+			from, ok := index[graphNode{typeID: relation.SourceType, id: relation.SourceID}]
+			if !ok {
+				continue
+			}
+			if to, ok := index[graphNode{typeID: relation.TargetType, id: relation.TargetID}]; ok {
+				ret.graph.AddEdge(from, to)
+			}
+		}
+	}
+	return ret
+}
+
+func runAnalysis(ctx context.Context, g *instance, grant *auth.Grant, request AnalyticsRequest) (interface{}, *apiError) {
+	data := buildAnalyticsGraph(g, grant, request)
+	switch request.Analysis {
+	case AnalysisDegree:
+		scores := data.degrees()
+		sortDegrees(scores)
+		limited := limitDegrees(scores, request.Limit)
+		return DegreeResult{Entities: limited, Amount: len(limited), Total: len(scores)}, nil
+	case AnalysisTopConnected:
+		result := TopConnectedResult{Types: make(map[string][]DegreeScore)}
+		for _, score := range data.degrees() {
+			result.Types[score.Type] = append(result.Types[score.Type], score)
+		}
+		for typeStr, scores := range result.Types {
+			sortDegrees(scores)
+			result.Types[typeStr] = limitDegrees(scores, request.Limit)
+		}
+		return result, nil
+	case AnalysisComponents:
+		return data.components(request.Limit), nil
+	case AnalysisPageRank:
+		ranks, iterations, err := analytics.PageRank(ctx, data.graph, request.Damping, request.Iterations, 1e-9)
+		if nil != err {
+			return nil, internalError("The analysis has been cancelled")
+		}
+		result := data.scores(ranks, request.Limit)
+		result.Iterations = iterations
+		return result, nil
+	case AnalysisBetweenness:
+		scores, err := analytics.Betweenness(ctx, data.graph, request.Directed)
+		if nil != err {
+			return nil, internalError("The analysis has been cancelled")
+		}
+		return data.scores(scores, request.Limit), nil
+	}
+	return nil, internalError("Unknown analysis '" + request.Analysis + "'")
+}
+
+func (data analyticsGraph) degrees() []DegreeScore {
+	ret := []DegreeScore{}
+	for node, entity := range data.entities {
+		in, out := len(data.graph.In[node]), len(data.graph.Out[node])
+		ret = append(ret, DegreeScore{EntityRef: entity, In: in, Out: out, Degree: in + out})
+	}
+	return ret
+}
+
+// sortDegrees orders by degree, the nodes keep their order on equal ones
+func sortDegrees(scores []DegreeScore) {
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Degree > scores[j].Degree
+	})
+}
+
+func limitDegrees(scores []DegreeScore, limit int) []DegreeScore {
+	if limit < len(scores) {
+		return scores[:limit]
+	}
+	return scores
+}
+
+// scores lists the highest scored entities
+func (data analyticsGraph) scores(values []float64, limit int) ScoreResult {
+	scores := []EntityScore{}
+	for node, entity := range data.entities {
+		scores = append(scores, EntityScore{EntityRef: entity, Score: values[node]})
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+	result := ScoreResult{Total: len(scores)}
+	if limit < len(scores) {
+		scores = scores[:limit]
+	}
+	result.Entities = scores
+	result.Amount = len(scores)
+	return result
+}
+
+// components lists the largest components
+func (data analyticsGraph) components(limit int) ComponentResult {
+	membership, sizes := analytics.Components(data.graph)
+	components := make([]Component, len(sizes))
+	for node, component := range membership {
+		if 0 == components[component].Size {
+			components[component].Types = make(map[string]int)
+			components[component].Entity = data.entities[node]
+		}
+		components[component].Size++
+		components[component].Types[data.entities[node].Type]++
+	}
+	sort.SliceStable(components, func(i, j int) bool {
+		return components[i].Size > components[j].Size
+	})
+	result := ComponentResult{Total: len(components)}
+	if limit < len(components) {
+		components = components[:limit]
+	}
+	result.Components = components
+	result.Amount = len(components)
+	return result
+}
@@ -0,0 +1,276 @@
+package gitsapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+type contextKey int
+
+const (
+	identityContextKey contextKey = iota
+	grantContextKey
+	storageContextKey
+	serverContextKey
+)
+
+// loadAuth prepares the configured authentication methods, without
+// any configured method the api stays open as before
+func (s *Server) loadAuth() error {
+	keysFile := s.config.GetOptionalValue("AUTH_KEYS_FILE", "")
+	if "" != keysFile {
+		keyStore, err := auth.LoadKeyStore(keysFile)
+		if nil != err {
+			return errors.New("Could not load api keys file: " + err.Error())
+		}
+		s.keyStore = keyStore
+		archivist.Info("> Api key authentication enabled")
+	}
+
+	if err := s.loadJwt(); nil != err {
+		return err
+	}
+	return s.loadPolicy()
+}
+
+// loadJwt enables bearer token authentication if at least one
+// verification key is configured
+func (s *Server) loadJwt() error {
+	secret := s.config.GetOptionalValue("JWT_HS256_SECRET", "")
+	publicKeyFile := s.config.GetOptionalValue("JWT_PUBLIC_KEY_FILE", "")
+	jwksFile := s.config.GetOptionalValue("JWT_JWKS_FILE", "")
+	if "" == secret && "" == publicKeyFile && "" == jwksFile {
+		return nil
+	}
+
+	verifier, err := auth.NewJwtVerifier(s.config.GetOptionalValue("JWT_AUDIENCE", ""), s.config.GetOptionalValue("JWT_ISSUER", ""))
+	if nil != err {
+		return errors.New("Invalid JWT config, JWT_AUDIENCE is required: " + err.Error())
+	}
+	if "" != secret {
+		if err = verifier.AddHmacSecret(secret); nil != err {
+			return errors.New("Invalid JWT_HS256_SECRET: " + err.Error())
+		}
+	}
+	if "" != publicKeyFile {
+		if err = verifier.AddPublicKeyFile(publicKeyFile); nil != err {
+			return errors.New("Could not load JWT_PUBLIC_KEY_FILE: " + err.Error())
+		}
+	}
+	if "" != jwksFile {
+		if err = verifier.AddJwksFile(jwksFile); nil != err {
+			return errors.New("Could not load JWT_JWKS_FILE: " + err.Error())
+		}
+	}
+	if !verifier.HasKeys() {
+		return errors.New("JWT config contains no usable verification key")
+	}
+
+	s.jwt = verifier
+	archivist.Info("> JWT bearer authentication enabled")
+	return nil
+}
+
+func (s *Server) authEnabled() bool {
+	return nil != s.keyStore || nil != s.jwt
+}
+
+// requireScope wraps a handler so it is only reachable by identities
+// owning the given scope
+func (s *Server) requireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireScopeFunc(func(r *http.Request) string { return scope }, next)
+}
+
+// requireScopeFunc is like requireScope but decides the scope per request.
+// It also checks the access to the requested storage, resolves the policy
+// grant handlers use for per entity type decisions and finally resolves
+// the storage itself, handlers get it by storageFromRequest.
+func (s *Server) requireScopeFunc(scopeOf func(r *http.Request) string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// preflight requests never carry credentials
+		if "OPTIONS" == r.Method {
+			next(w, r)
+			return
+		}
+
+		identity, ok := s.identify(w, r, scopeOf(r))
+		if !ok {
+			return
+		}
+
+		storage := requestedStorageName(r)
+		grant, ok := s.storageGrant(identity, storage)
+		if !ok {
+			respondError(storageForbiddenError(storage), w)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), identityContextKey, identity)
+		if nil != grant {
+			ctx = context.WithValue(ctx, grantContextKey, grant)
+		}
+
+		g, apiErr := s.resolveStorage(storage)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		ctx = context.WithValue(ctx, storageContextKey, g)
+
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// requireAdmin guards the routes managing the storages themselves,
+// they only require the admin scope and are not bound to a storage
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if "OPTIONS" == r.Method || !s.authEnabled() {
+			next(w, r)
+			return
+		}
+		identity, ok := s.identify(w, r, auth.ScopeAdmin)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityContextKey, identity)))
+	}
+}
+
+// requireScopeAllStorages is requireScope for routes reporting on all
+// storages at once. The storages are not resolved, handlers check the
+// access to each of them by storageGrant.
+func (s *Server) requireScopeAllStorages(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if "OPTIONS" == r.Method {
+			next(w, r)
+			return
+		}
+		identity, ok := s.identify(w, r, scope)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityContextKey, identity)))
+	}
+}
+
+// identityFromRequest returns the identity set by the middleware
+func identityFromRequest(r *http.Request) *auth.Identity {
+	identity, _ := r.Context().Value(identityContextKey).(*auth.Identity)
+	return identity
+}
+
+// storageGrant checks if the identity may access the storage and returns
+// its policy grant, which is nil without a configured policy
+func (s *Server) storageGrant(identity *auth.Identity, storage string) (*auth.Grant, bool) {
+	if !identity.CanAccessStorage(storage) {
+		return nil, false
+	}
+	if nil == s.policy {
+		return nil, true
+	}
+	return s.policy.Grant(identity.Name, storage)
+}
+
+// identify authenticates the request and checks the scope, if this
+// fails the error response is already sent and ok is false
+func (s *Server) identify(w http.ResponseWriter, r *http.Request, scope string) (*auth.Identity, bool) {
+	if !s.authEnabled() {
+		return &auth.Identity{Name: auth.AnonymousIdentity}, true
+	}
+
+	identity, apiErr := s.authenticate(r)
+	if nil != apiErr {
+		s.addAuthenticateHeaders(w, apiErr)
+		respondError(apiErr, w)
+		return nil, false
+	}
+
+	if !identity.HasScope(scope) {
+		respondError(missingScopeError(scope), w)
+		return nil, false
+	}
+	return identity, true
+}
+
+// hasScope tells if the caller of the request owns the scope, without
+// authentication every caller does
+func (s *Server) hasScope(r *http.Request, scope string) bool {
+	if !s.authEnabled() {
+		return true
+	}
+	identity := identityFromRequest(r)
+	return nil != identity && identity.HasScope(scope)
+}
+
+func missingScopeError(scope string) *apiError {
+	return newApiError(http.StatusForbidden, CodeForbidden, "Missing scope '"+scope+"' for this route", map[string]string{"scope": scope})
+}
+
+func storageForbiddenError(storage string) *apiError {
+	return newApiError(http.StatusForbidden, CodeForbidden, "Access to storage '"+storage+"' is not granted", map[string]string{"storage": storage})
+}
+
+// scopeByMethod requires the read scope for reading
+// http methods and the write scope for all others
+func scopeByMethod(r *http.Request) string {
+	if "GET" == r.Method || "HEAD" == r.Method {
+		return auth.ScopeRead
+	}
+	return auth.ScopeWrite
+}
+
+func (s *Server) authenticate(r *http.Request) (*auth.Identity, *apiError) {
+	key := r.Header.Get("X-API-Key")
+	token := ""
+	if authorization := r.Header.Get("Authorization"); "" == key && "" != authorization {
+		scheme, credentials, _ := strings.Cut(authorization, " ")
+		if strings.EqualFold("ApiKey", scheme) {
+			key = strings.TrimSpace(credentials)
+		} else if strings.EqualFold("Bearer", scheme) {
+			token = strings.TrimSpace(credentials)
+		}
+	}
+
+	if "" != token && nil != s.jwt {
+		identity, err := s.jwt.Verify(token)
+		if nil != err {
+			reason := auth.ReasonMalformed
+			if tokenErr, ok := err.(*auth.TokenError); ok {
+				reason = tokenErr.Reason
+			}
+			return nil, newApiError(http.StatusUnauthorized, CodeUnauthorized, err.Error(), map[string]string{"reason": reason})
+		}
+		return identity, nil
+	}
+
+	if "" != key && nil != s.keyStore {
+		identity, err := s.keyStore.Verify(key)
+		if nil != err {
+			return nil, newApiError(http.StatusUnauthorized, CodeUnauthorized, err.Error(), nil)
+		}
+		return identity, nil
+	}
+
+	return nil, newApiError(http.StatusUnauthorized, CodeUnauthorized, "Missing credentials", nil)
+}
+
+// addAuthenticateHeaders announces the enabled authentication schemes,
+// rejected bearer tokens get the rfc 6750 error attributes
+func (s *Server) addAuthenticateHeaders(w http.ResponseWriter, apiErr *apiError) {
+	if nil != s.keyStore {
+		w.Header().Add("WWW-Authenticate", "ApiKey")
+	}
+	if nil != s.jwt {
+		challenge := `Bearer realm="gitsapi"`
+		if details, ok := apiErr.Details.(map[string]string); ok && "" != details["reason"] {
+			challenge += `, error="invalid_token", error_description="` + strings.ReplaceAll(apiErr.Message, `"`, `'`) + `"`
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+}
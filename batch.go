@@ -0,0 +1,449 @@
+package gitsapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/query"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// BatchRequest is the body of /v1/batch, the operations run in order.
+// Atomic batches run as one transaction, if an operation fails all
+// earlier ones are rolled back.
+type BatchRequest struct {
+	Operations      []BatchOperation
+	ContinueOnError bool
+	Atomic          bool
+}
+
+// BatchOperation is a single step of a batch. Data is the body the
+// matching v1 route would take, objects of the form {"$ref": "0.ID"}
+// are replaced by a value of the result of an earlier operation,
+// referenced by its index or Name.
+type BatchOperation struct {
+	Name string `json:",omitempty"`
+	Op   string
+	Data json.RawMessage
+}
+
+// BatchResult is the outcome of one operation. Result holds the
+// created, updated or queried data.
+type BatchResult struct {
+	Name   string `json:",omitempty"`
+	Op     string
+	Status int
+	Result interface{} `json:",omitempty"`
+	Error  *apiError   `json:",omitempty"`
+}
+
+// batchOperation runs one operation within a write of the storage. The
+// grant is nil when a journaled transaction gets replayed.
+type batchOperation func(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError)
+
+var batchOperations = map[string]batchOperation{
+	"createEntity":   batchCreateEntity,
+	"updateEntity":   batchUpdateEntity,
+	"deleteEntity":   batchDeleteEntity,
+	"upsertEntity":   batchUpsertEntity,
+	"createRelation": batchCreateRelation,
+	"updateRelation": batchUpdateRelation,
+	"deleteRelation": batchDeleteRelation,
+	"query":          batchQuery,
+}
+
+func (s *Server) registerBatchRoutes() {
+	// Route: /v1/batch
+	s.handleFunc("/v1/batch", s.requireScope(auth.ScopeWrite, s.handleBatch))
+}
+
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "POST" != r.Method {
+		respondError(methodNotAllowedError("POST"), w)
+		return
+	}
+
+	var batch BatchRequest
+	if apiErr := decodeJsonBody(r, &batch); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if apiErr := validateBatch(batch); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	// all operations run against the storage resolved once here
+	g := storageFromRequest(r)
+	grant := grantFromRequest(r)
+	// the route only requires the write scope, queries also need the query scope
+	canQuery := s.hasScope(r, auth.ScopeQuery)
+	if batch.Atomic {
+		results, status, apiErr := runBatchTransaction(grant, canQuery, g, batch.Operations)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		archivist.Info("> Executed atomic batch, operations:", len(batch.Operations))
+		respondJson(results, status, w)
+		return
+	}
+
+	results := make([]BatchResult, len(batch.Operations))
+	failed := false
+	for i, operation := range batch.Operations {
+		results[i] = BatchResult{Name: operation.Name, Op: operation.Op}
+		if failed && !batch.ContinueOnError {
+			results[i].Status, results[i].Error = http.StatusFailedDependency, newApiError(http.StatusFailedDependency, CodeFailedDependency, "Skipped after an earlier operation failed", nil)
+			continue
+		}
+
+		// every operation is a write of its own
+		var result interface{}
+		var status int
+		write, apiErr := beginWrite(g)
+		if nil == apiErr {
+			_, result, status, apiErr = runBatchOperation(grant, canQuery, write, batch.Operations, results, i)
+			write.end()
+		}
+		if nil != apiErr {
+			failed = true
+			results[i].Status, results[i].Error = apiErr.Status, apiErr
+			continue
+		}
+		results[i].Status, results[i].Result = status, result
+	}
+
+	archivist.Info("> Executed batch, operations:", len(batch.Operations))
+	respondJson(results, 200, w)
+}
+
+// runBatchTransaction runs all operations within one transaction. If
+// one fails everything is rolled back, the failed operation keeps its
+// error and the status of the response is its status.
+func runBatchTransaction(grant *auth.Grant, canQuery bool, g *instance, operations []BatchOperation) ([]BatchResult, int, *apiError) {
+	write, apiErr := beginTransaction(g)
+	if nil != apiErr {
+		return nil, 0, apiErr
+	}
+	defer write.end()
+
+	results := make([]BatchResult, len(operations))
+	applied := []BatchOperation{}
+	for i, operation := range operations {
+		results[i] = BatchResult{Name: operation.Name, Op: operation.Op}
+		data, result, status, apiErr := runBatchOperation(grant, canQuery, write, operations, results, i)
+		if nil != apiErr {
+			write.rollback()
+			rollbackBatchResults(results, operations, i, apiErr)
+			return results, apiErr.Status, nil
+		}
+		results[i].Status, results[i].Result = status, result
+		// queries are read only inside transactions, nothing to replay
+		if "query" != operation.Op {
+			applied = append(applied, BatchOperation{Op: operation.Op, Data: data})
+		}
+	}
+
+	if apiErr := write.commit(applied); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	return results, 200, nil
+}
+
+// rollbackBatchResults reports the operations around the failed one
+func rollbackBatchResults(results []BatchResult, operations []BatchOperation, failed int, apiErr *apiError) {
+	details := map[string]string{"failed": strconv.Itoa(failed)}
+	for i := range operations {
+		results[i] = BatchResult{Name: operations[i].Name, Op: operations[i].Op}
+		switch {
+		case i < failed:
+			results[i].Error = newApiError(http.StatusFailedDependency, CodeFailedDependency, "Rolled back since operation "+strconv.Itoa(failed)+" failed", details)
+		case i == failed:
+			results[i].Error = apiErr
+		default:
+			results[i].Error = newApiError(http.StatusFailedDependency, CodeFailedDependency, "Skipped since operation "+strconv.Itoa(failed)+" failed", details)
+		}
+		results[i].Status = results[i].Error.Status
+	}
+}
+
+// replayTransaction applies the operations of a journaled transaction,
+// they were authorized and resolved when the batch ran
+func replayTransaction(g *instance, operations []BatchOperation) *apiError {
+	write, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer write.end()
+	for _, operation := range operations {
+		run, ok := batchOperations[operation.Op]
+		if !ok {
+			return internalError("Unknown transaction operation '" + operation.Op + "'")
+		}
+		if _, _, apiErr := run(nil, write, operation.Data); nil != apiErr {
+			return apiErr
+		}
+	}
+	return nil
+}
+
+// validateBatch rejects unknown operations and duplicate names before
+// anything gets applied
+func validateBatch(batch BatchRequest) *apiError {
+	if 0 == len(batch.Operations) {
+		return newApiError(http.StatusBadRequest, CodeMissingParameter, "The batch contains no operations", map[string]string{"field": "Operations"})
+	}
+	if batch.Atomic && batch.ContinueOnError {
+		return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Atomic batches can't continue on error", map[string]string{"field": "ContinueOnError"})
+	}
+	names := make(map[string]bool)
+	for i, operation := range batch.Operations {
+		field := "Operations[" + strconv.Itoa(i) + "]"
+		if _, ok := batchOperations[operation.Op]; !ok {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown operation '"+operation.Op+"'", map[string]string{"field": field + ".Op"})
+		}
+		if "" == operation.Name {
+			continue
+		}
+		if _, err := strconv.Atoi(operation.Name); nil == err || strings.Contains(operation.Name, ".") {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Names can't be numbers or contain '.'", map[string]string{"field": field + ".Name"})
+		}
+		if names[operation.Name] {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "The name '"+operation.Name+"' is used twice", map[string]string{"field": field + ".Name"})
+		}
+		names[operation.Name] = true
+	}
+	return nil
+}
+
+// runBatchOperation resolves the references of an operation and runs
+// it, the resolved data is returned along with the result
+func runBatchOperation(grant *auth.Grant, canQuery bool, w *storageWrite, operations []BatchOperation, results []BatchResult, index int) ([]byte, interface{}, int, *apiError) {
+	if "query" == operations[index].Op && !canQuery {
+		return nil, nil, 0, newApiError(http.StatusForbidden, CodeForbidden, "Missing scope 'query' for query operations", map[string]string{"scope": auth.ScopeQuery})
+	}
+	if 0 == len(operations[index].Data) {
+		return nil, nil, 0, newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing operation data", map[string]string{"field": "Data"})
+	}
+	data, apiErr := resolveBatchRefs(operations, results, index)
+	if nil != apiErr {
+		return nil, nil, 0, apiErr
+	}
+	result, status, apiErr := batchOperations[operations[index].Op](grant, w, data)
+	return data, result, status, apiErr
+}
+
+// resolveBatchRefs replaces the references in the data of an operation
+func resolveBatchRefs(operations []BatchOperation, results []BatchResult, index int) ([]byte, *apiError) {
+	data := operations[index].Data
+	if !bytes.Contains(data, []byte(`"$ref"`)) {
+		return data, nil
+	}
+
+	var tree interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tree); nil != err {
+		return nil, malformedBodyError(err)
+	}
+	tree, apiErr := resolveBatchTree(tree, operations, results, index)
+	if nil != apiErr {
+		return nil, apiErr
+	}
+	ret, err := json.Marshal(tree)
+	if nil != err {
+		return nil, internalError(err.Error())
+	}
+	return ret, nil
+}
+
+func resolveBatchTree(node interface{}, operations []BatchOperation, results []BatchResult, index int) (interface{}, *apiError) {
+	var apiErr *apiError
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := typed["$ref"].(string); ok && 1 == len(typed) {
+			return resolveBatchRef(ref, operations, results, index)
+		}
+		for key, value := range typed {
+			if typed[key], apiErr = resolveBatchTree(value, operations, results, index); nil != apiErr {
+				return nil, apiErr
+			}
+		}
+	case []interface{}:
+		for i, value := range typed {
+			if typed[i], apiErr = resolveBatchTree(value, operations, results, index); nil != apiErr {
+				return nil, apiErr
+			}
+		}
+	}
+	return node, nil
+}
+
+// resolveBatchRef looks up a reference like "0.ID" or "parent.Entities.0.ID"
+func resolveBatchRef(ref string, operations []BatchOperation, results []BatchResult, index int) (interface{}, *apiError) {
+	segments := strings.Split(ref, ".")
+	target := -1
+	if number, err := strconv.Atoi(segments[0]); nil == err {
+		target = number
+	} else {
+		for i := 0; i < index; i++ {
+			if segments[0] == operations[i].Name {
+				target = i
+			}
+		}
+	}
+	if 0 > target || index <= target {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidParameter, "The reference '"+ref+"' doesn't point to an earlier operation", map[string]string{"ref": ref})
+	}
+	if nil != results[target].Error {
+		return nil, newApiError(http.StatusFailedDependency, CodeFailedDependency, "The referenced operation failed", map[string]string{"ref": ref})
+	}
+
+	// the result is walked in its json form, so paths equal the response
+	encoded, err := json.Marshal(results[target].Result)
+	if nil != err {
+		return nil, internalError(err.Error())
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); nil != err {
+		return nil, internalError(err.Error())
+	}
+	for _, segment := range segments[1:] {
+		found := false
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			value, found = typed[segment]
+		case []interface{}:
+			if i, err := strconv.Atoi(segment); nil == err && 0 <= i && i < len(typed) {
+				value, found = typed[i], true
+			}
+		}
+		if !found {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidParameter, "The reference '"+ref+"' doesn't exist in the result", map[string]string{"ref": ref})
+		}
+	}
+	return value, nil
+}
+
+func decodeBatchData(data []byte, target interface{}) *apiError {
+	if err := json.Unmarshal(data, target); nil != err {
+		return malformedBodyError(err)
+	}
+	return nil
+}
+
+func batchCreateEntity(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var entity transport.TransportEntity
+	if apiErr := decodeBatchData(data, &entity); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionCreate, entity.Type); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	created, apiErr := w.createEntity(entity)
+	return created, http.StatusCreated, apiErr
+}
+
+func batchUpdateEntity(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var entity transport.TransportEntity
+	if apiErr := decodeBatchData(data, &entity); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionUpdate, entity.Type); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	updated, apiErr := w.updateEntity(entity)
+	return updated, http.StatusOK, apiErr
+}
+
+func batchDeleteEntity(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var entity transport.TransportEntity
+	if apiErr := decodeBatchData(data, &entity); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionDelete, entity.Type); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	return nil, http.StatusOK, w.deleteEntity(entity.Type, entity.ID)
+}
+
+func batchUpsertEntity(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var upsert UpsertRequest
+	if apiErr := decodeBatchData(data, &upsert); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionCreate, upsert.Type); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionUpdate, upsert.Type); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	result, apiErr := w.upsertEntity(upsert)
+	if result.Created {
+		return result, http.StatusCreated, apiErr
+	}
+	return result, http.StatusOK, apiErr
+}
+
+func batchCreateRelation(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var relation transport.TransportRelation
+	if apiErr := decodeBatchData(data, &relation); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionUpdate, relation.SourceType, relation.TargetType); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	created, apiErr := w.createRelation(relation)
+	return created, http.StatusCreated, apiErr
+}
+
+func batchUpdateRelation(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var relation transport.TransportRelation
+	if apiErr := decodeBatchData(data, &relation); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionUpdate, relation.SourceType, relation.TargetType); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	updated, apiErr := w.updateRelation(relation)
+	return updated, http.StatusOK, apiErr
+}
+
+func batchDeleteRelation(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var relation transport.TransportRelation
+	if apiErr := decodeBatchData(data, &relation); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := authorizeGrant(grant, auth.ActionUpdate, relation.SourceType, relation.TargetType); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	return nil, http.StatusOK, w.deleteRelation(relation.SourceType, relation.SourceID, relation.TargetType, relation.TargetID)
+}
+
+func batchQuery(grant *auth.Grant, w *storageWrite, data []byte) (interface{}, int, *apiError) {
+	var qry query.Query
+	if apiErr := decodeBatchData(data, &qry); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	if apiErr := restrictQuery(grant, &qry); nil != apiErr {
+		return nil, 0, apiErr
+	}
+	result, apiErr := w.executeQuery(&qry)
+	if nil != apiErr {
+		return nil, 0, apiErr
+	}
+	return filterTransport(grant, result), http.StatusOK, nil
+}
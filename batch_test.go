@@ -0,0 +1,181 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// batchRefFixture are three operations, the second one named parent,
+// and the results of the first two. The third one is the running one.
+func batchRefFixture(failed bool) ([]BatchOperation, []BatchResult) {
+	operations := []BatchOperation{
+		{Op: "createEntity"},
+		{Name: "parent", Op: "query"},
+		{Name: "current", Op: "createRelation"},
+	}
+	results := []BatchResult{
+		{Op: "createEntity", Status: 201, Result: transport.TransportEntity{Type: "Person", ID: 7, Value: "alice"}},
+		{Name: "parent", Op: "query", Status: 200, Result: transport.Transport{Entities: []transport.TransportEntity{{Type: "Company", ID: 3}, {Type: "Company", ID: 4}}}},
+		{},
+	}
+	if failed {
+		results[1] = BatchResult{Name: "parent", Op: "query", Status: 404, Error: storageNotFoundError("missing")}
+	}
+	return operations, results
+}
+
+func TestResolveBatchRef(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		ref    string
+		failed bool
+		want   string
+		status int
+	}{
+		{"by index", "0.ID", false, "7", 0},
+		{"by index to a text", "0.Value", false, `"alice"`, 0},
+		{"by name", "parent.Entities.1.ID", false, "4", 0},
+		{"whole result", "0", false, `{"ChildRelations":null,"Context":"","ID":7,"ParentRelations":null,"Properties":null,"Type":"Person","Value":"alice","Version":0}`, 0},
+		{"to itself by index", "2.ID", false, "", 400},
+		{"to itself by name", "current.ID", false, "", 400},
+		{"forward", "3.ID", false, "", 400},
+		{"negative index", "-1.ID", false, "", 400},
+		{"unknown name", "child.ID", false, "", 400},
+		{"unknown field", "0.Name", false, "", 400},
+		{"index beyond a list", "parent.Entities.2.ID", false, "", 400},
+		{"to a failed operation", "parent.Entities.0.ID", true, "", 424},
+		{"to a failed operation by index", "1", true, "", 424},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			operations, results := batchRefFixture(tc.failed)
+			value, apiErr := resolveBatchRef(tc.ref, operations, results, 2)
+			if 0 != tc.status {
+				if nil == apiErr || tc.status != apiErr.Status {
+					t.Fatalf("expected status %d, got %v", tc.status, apiErr)
+				}
+				return
+			}
+			if nil != apiErr {
+				t.Fatal(apiErr)
+			}
+			encoded, _ := json.Marshal(value)
+			if tc.want != string(encoded) {
+				t.Errorf("expected %s, got %s", tc.want, encoded)
+			}
+		})
+	}
+}
+
+// references are replaced anywhere in the data, objects with further
+// keys next to $ref are left as they are
+func TestResolveBatchRefs(t *testing.T) {
+	operations, results := batchRefFixture(false)
+	operations[2].Data = json.RawMessage(`{"SourceType":"Person","SourceID":{"$ref":"0.ID"},"TargetType":"Company","TargetID":{"$ref":"parent.Entities.0.ID"},"Properties":{"list":[{"$ref":"0.Value"}],"other":{"$ref":"0.ID","x":1}}}`)
+	data, apiErr := resolveBatchRefs(operations, results, 2)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	want := `{"Properties":{"list":["alice"],"other":{"$ref":"0.ID","x":1}},"SourceID":7,"SourceType":"Person","TargetID":3,"TargetType":"Company"}`
+	if want != string(data) {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	// data without references is passed on untouched
+	operations[2].Data = json.RawMessage(`{"SourceID": 1}`)
+	if data, _ = resolveBatchRefs(operations, results, 2); `{"SourceID": 1}` != string(data) {
+		t.Errorf("data without references got changed to %s", data)
+	}
+
+	// the first failing reference fails the operation
+	operations[2].Data = json.RawMessage(`[{"$ref":"0.ID"},{"$ref":"5.ID"}]`)
+	if _, apiErr = resolveBatchRefs(operations, results, 2); nil == apiErr || 400 != apiErr.Status {
+		t.Errorf("expected status 400, got %v", apiErr)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true"})
+	for _, tc := range []struct {
+		name       string
+		batch      string
+		statuses   []int
+		lastTarget int
+	}{
+		{"references", `{"Operations":[
+			{"Name":"alice","Op":"createEntity","Data":{"Type":"Person","Value":"alice"}},
+			{"Op":"createEntity","Data":{"Type":"Company","Value":"acme"}},
+			{"Op":"createRelation","Data":{"SourceType":"Person","SourceID":{"$ref":"alice.ID"},"TargetType":"Company","TargetID":{"$ref":"1.ID"}}}
+		]}`, []int{201, 201, 201}, 1},
+		{"skipped after a failure", `{"Operations":[
+			{"Op":"createEntity","Data":{"Type":"Person","Value":"bob"}},
+			{"Op":"updateEntity","Data":{"Type":"Person","ID":99,"Value":"nobody","Version":1}},
+			{"Op":"createEntity","Data":{"Type":"Person","Value":"carol"}}
+		]}`, []int{201, 404, 424}, 0},
+		{"continued after a failure", `{"ContinueOnError":true,"Operations":[
+			{"Op":"updateEntity","Data":{"Type":"Person","ID":99,"Value":"nobody","Version":1}},
+			{"Op":"createEntity","Data":{"Type":"Person","Value":"dave"}},
+			{"Op":"createEntity","Data":{"Type":"Person","Value":"frank"}},
+			{"Op":"createRelation","Data":{"SourceType":"Person","SourceID":{"$ref":"0.ID"},"TargetType":"Person","TargetID":{"$ref":"1.ID"}}},
+			{"Op":"createRelation","Data":{"SourceType":"Person","SourceID":{"$ref":"1.ID"},"TargetType":"Person","TargetID":{"$ref":"2.ID"}}}
+		]}`, []int{404, 201, 201, 424, 201}, 2},
+		{"reference to itself", `{"ContinueOnError":true,"Operations":[
+			{"Op":"createEntity","Data":{"Type":"Person","Value":{"$ref":"0.Value"}}},
+			{"Op":"createEntity","Data":{"Type":"Person","Value":{"$ref":"2.Value"}}},
+			{"Op":"createEntity","Data":{"Type":"Person","Value":"erin"}}
+		]}`, []int{400, 400, 201}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var results []BatchResult
+			resp := call(t, ts, "POST", "/v1/batch", map[string]string{"Storage": "batch-" + t.Name()}, tc.batch, &results)
+			if 200 != resp.StatusCode {
+				t.Fatalf("expected status 200, got %d", resp.StatusCode)
+			}
+			if len(tc.statuses) != len(results) {
+				t.Fatalf("expected %d results, got %d", len(tc.statuses), len(results))
+			}
+			for i, status := range tc.statuses {
+				if status != results[i].Status {
+					t.Errorf("operation %d: expected status %d, got %d %v", i, status, results[i].Status, results[i].Error)
+				}
+			}
+			if 0 < tc.lastTarget {
+				encoded, _ := json.Marshal(results[len(results)-1].Result)
+				var relation transport.TransportRelation
+				json.Unmarshal(encoded, &relation)
+				if tc.lastTarget != relation.TargetID || 1 != relation.SourceID {
+					t.Errorf("the references resolved to %+v", relation)
+				}
+			}
+		})
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true"})
+	for _, tc := range []struct {
+		name  string
+		batch string
+		field string
+	}{
+		{"no operations", `{"Operations":[]}`, "Operations"},
+		{"unknown operation", `{"Operations":[{"Op":"dropStorage","Data":{}}]}`, "Operations[0].Op"},
+		{"numeric name", `{"Operations":[{"Name":"1","Op":"createEntity","Data":{}}]}`, "Operations[0].Name"},
+		{"name with a dot", `{"Operations":[{"Name":"a.b","Op":"createEntity","Data":{}}]}`, "Operations[0].Name"},
+		{"name used twice", `{"Operations":[{"Name":"a","Op":"createEntity","Data":{}},{"Name":"a","Op":"createEntity","Data":{}}]}`, "Operations[1].Name"},
+		{"atomic continuing on error", `{"Atomic":true,"ContinueOnError":true,"Operations":[{"Op":"createEntity","Data":{}}]}`, "ContinueOnError"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var envelope errorEnvelope
+			resp := call(t, ts, "POST", "/v1/batch", map[string]string{"Storage": "batch-rejected"}, tc.batch, &envelope)
+			if 400 != resp.StatusCode {
+				t.Fatalf("expected status 400, got %d", resp.StatusCode)
+			}
+			details, _ := envelope.Error.Details.(map[string]interface{})
+			if tc.field != details["field"] {
+				t.Errorf("expected field %s, got %v", tc.field, envelope.Error.Details)
+			}
+		})
+	}
+}
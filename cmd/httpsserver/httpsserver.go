@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/voodooEntity/archivist"
 	"github.com/voodooEntity/gits"
 	"github.com/voodooEntity/gitsapi"
@@ -11,5 +17,32 @@ func main() {
 	config.Init(make(map[string]string))
 	archivist.Init(config.GetValue("LOG_LEVEL"), config.GetValue("LOG_TARGET"), config.GetValue("LOG_PATH"))
 	gits.NewInstance("api")
-	gitsapi.Start()
+
+	server, err := gitsapi.NewServer()
+	if nil != err {
+		archivist.Error(err.Error())
+		os.Exit(1)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.ListenAndServe()
+	}()
+
+	// wait for either a listen error or a termination signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errChan:
+		if nil != err {
+			archivist.Error(err.Error())
+			os.Exit(1)
+		}
+	case <-sigChan:
+		archivist.Info("> Shutting down, draining open requests")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); nil != err {
+			archivist.Error("Graceful shutdown failed", err.Error())
+		}
+	}
 }
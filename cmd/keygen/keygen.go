@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// keygen creates a new api key and prints the key for the client
+// together with the entry to add to the AUTH_KEYS_FILE
+func main() {
+	id := flag.String("id", "", "unique id of the key, e.g. the name of the client")
+	scopes := flag.String("scopes", auth.ScopeRead, "comma separated list of scopes (read,write,query,admin)")
+	flag.Parse()
+
+	if "" == *id {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	key, hash, err := auth.GenerateApiKey(*id)
+	if nil != err {
+		fmt.Fprintln(os.Stderr, "Could not generate api key:", err.Error())
+		os.Exit(1)
+	}
+
+	entry, _ := json.MarshalIndent(auth.ApiKey{
+		ID:     *id,
+		Hash:   hash,
+		Scopes: strings.Split(*scopes, ","),
+	}, "", "  ")
+
+	fmt.Println("Api key (hand this to the client, it can't be recovered):")
+	fmt.Println(key)
+	fmt.Println()
+	fmt.Println("Entry for the keys file:")
+	fmt.Println(string(entry))
+}
@@ -0,0 +1,151 @@
+package gitsapi
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// entityTag is the ETag of an entity or relation. Every change raises
+// the version, so it identifies the state of the single resource.
+func entityTag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+func setEntityTag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", entityTag(version))
+	// browsers hide response headers from scripts unless exposed
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+}
+
+// matchesEntityTag checks a list of tags of an If-Match or If-None-Match
+// header. If-None-Match compares weak, so W/ tags match as well.
+func matchesEntityTag(header string, version int, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if "*" == tag || entityTag(version) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondNotModified answers a GET whose If-None-Match header matches
+// the version, the return value tells if the request has been handled
+func respondNotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	setEntityTag(w, version)
+	header := r.Header.Get("If-None-Match")
+	if "" == header || !matchesEntityTag(header, version, true) {
+		return false
+	}
+	respond("", http.StatusNotModified, w)
+	return true
+}
+
+func preconditionFailedError(version int) *apiError {
+	return newApiError(http.StatusPreconditionFailed, CodePreconditionFailed, "The resource has been changed, If-Match does not match its current ETag", map[string]string{"etag": entityTag(version)})
+}
+
+// checkIfMatch compares the If-Match header with the stored version.
+// It is called within the write, so the version can't change until the
+// following update or delete.
+func checkIfMatch(ifMatch string, version int) *apiError {
+	if !matchesEntityTag(ifMatch, version, false) {
+		return preconditionFailedError(version)
+	}
+	return nil
+}
+
+// the conditional variants of the operations, an empty ifMatch skips
+// the check. Updates may leave out the Version the header already names.
+
+func updateEntityIfMatch(g *instance, ifMatch string, entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	if "" == ifMatch {
+		return updateEntity(g, entity)
+	}
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+
+	current, apiErr := readEntity(g, entity.Type, entity.ID)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	if apiErr := checkIfMatch(ifMatch, current.Version); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	if 0 == entity.Version {
+		entity.Version = current.Version
+	}
+	return w.updateEntity(entity)
+}
+
+func deleteEntityIfMatch(g *instance, ifMatch string, typeStr string, id int) *apiError {
+	if "" == ifMatch {
+		return deleteEntity(g, typeStr, id)
+	}
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer w.end()
+
+	current, apiErr := readEntity(g, typeStr, id)
+	if nil != apiErr {
+		return apiErr
+	}
+	if apiErr := checkIfMatch(ifMatch, current.Version); nil != apiErr {
+		return apiErr
+	}
+	return w.deleteEntity(typeStr, id)
+}
+
+func updateRelationIfMatch(g *instance, ifMatch string, relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	if "" == ifMatch {
+		return updateRelation(g, relation)
+	}
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	defer w.end()
+
+	current, apiErr := readRelation(g, relation.SourceType, relation.SourceID, relation.TargetType, relation.TargetID)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	if apiErr := checkIfMatch(ifMatch, current.Version); nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	if 0 == relation.Version {
+		relation.Version = current.Version
+	}
+	return w.updateRelation(relation)
+}
+
+func deleteRelationIfMatch(g *instance, ifMatch string, srcType string, srcID int, targetType string, targetID int) *apiError {
+	if "" == ifMatch {
+		return deleteRelation(g, srcType, srcID, targetType, targetID)
+	}
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer w.end()
+
+	current, apiErr := readRelation(g, srcType, srcID, targetType, targetID)
+	if nil != apiErr {
+		return apiErr
+	}
+	if apiErr := checkIfMatch(ifMatch, current.Version); nil != apiErr {
+		return apiErr
+	}
+	return w.deleteRelation(srcType, srcID, targetType, targetID)
+}
@@ -0,0 +1,149 @@
+package gitsapi
+
+import (
+	"testing"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+func TestMatchesEntityTag(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header string
+		weak   bool
+		want   bool
+	}{
+		{"strong tag", `"3"`, false, true},
+		{"other tag", `"2"`, false, false},
+		{"unquoted tag", `3`, false, false},
+		{"weak tag compared strong", `W/"3"`, false, false},
+		{"weak tag compared weak", `W/"3"`, true, true},
+		{"strong tag compared weak", `"3"`, true, true},
+		{"star", `*`, false, true},
+		{"star compared weak", `*`, true, true},
+		{"list", `"1", "3"`, false, true},
+		{"list without spaces", `"1","3"`, false, true},
+		{"list without the tag", `"1", "2"`, false, false},
+		{"list with a weak tag compared strong", `"1", W/"3"`, false, false},
+		{"list with a weak tag compared weak", `"1", W/"3"`, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.want != matchesEntityTag(tc.header, 3, tc.weak) {
+				t.Errorf("expected %v for %s", tc.want, tc.header)
+			}
+		})
+	}
+}
+
+// newConditionalFixture creates two persons related to each other and
+// returns the storage headers
+func newConditionalFixture(t *testing.T) map[string]string {
+	t.Helper()
+	name := "conditional-" + t.Name()
+	g, apiErr := newStorageSet().create(name)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	for _, value := range []string{"alice", "bob"} {
+		if _, apiErr := createEntity(g, transport.TransportEntity{Type: "Person", Value: value}); nil != apiErr {
+			t.Fatal(apiErr)
+		}
+	}
+	if _, apiErr := createRelation(g, transport.TransportRelation{SourceType: "Person", SourceID: 1, TargetType: "Person", TargetID: 2}); nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	return map[string]string{"Storage": name}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	headers := newConditionalFixture(t)
+	_, ts := newTestServer(t, nil)
+	for _, path := range []string{
+		"/v1/getEntityByTypeAndId?type=Person&id=1",
+		"/v1/getRelation?srcType=Person&srcID=1&targetType=Person&targetID=2",
+	} {
+		resp := call(t, ts, "GET", path, headers, "", nil)
+		etag := resp.Header.Get("ETag")
+		if 200 != resp.StatusCode || "" == etag {
+			t.Fatalf("%s: expected status 200 with an ETag, got %d %q", path, resp.StatusCode, etag)
+		}
+
+		for _, tc := range []struct {
+			ifNoneMatch string
+			status      int
+		}{
+			{etag, 304},
+			{"W/" + etag, 304},
+			{"*", 304},
+			{`"0", ` + etag, 304},
+			{`"0"`, 200},
+			{`"0", W/"0"`, 200},
+		} {
+			headers["If-None-Match"] = tc.ifNoneMatch
+			resp := call(t, ts, "GET", path, headers, "", nil)
+			if tc.status != resp.StatusCode {
+				t.Errorf("%s with If-None-Match %s: expected status %d, got %d", path, tc.ifNoneMatch, tc.status, resp.StatusCode)
+			}
+			if etag != resp.Header.Get("ETag") {
+				t.Errorf("%s with If-None-Match %s: expected ETag %s, got %q", path, tc.ifNoneMatch, etag, resp.Header.Get("ETag"))
+			}
+		}
+		delete(headers, "If-None-Match")
+	}
+}
+
+func TestIfMatchOnDelete(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// the resource is read by GET and deleted by DELETE on the path
+		get    string
+		delete string
+	}{
+		{"v1 entity", "/v1/getEntityByTypeAndId?type=Person&id=1", "/v1/deleteEntity?type=Person&id=1"},
+		{"v1 relation", "/v1/getRelation?srcType=Person&srcID=1&targetType=Person&targetID=2", "/v1/deleteRelation?srcType=Person&srcID=1&targetType=Person&targetID=2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			headers := newConditionalFixture(t)
+			_, ts := newTestServer(t, nil)
+			etag := call(t, ts, "GET", tc.get, headers, "", nil).Header.Get("ETag")
+
+			// weak tags never match an If-Match
+			for _, ifMatch := range []string{`"0"`, "W/" + etag, `"0", W/` + etag} {
+				var envelope errorEnvelope
+				headers["If-Match"] = ifMatch
+				resp := call(t, ts, "DELETE", tc.delete, headers, "", &envelope)
+				if 412 != resp.StatusCode {
+					t.Fatalf("If-Match %s: expected status 412, got %d", ifMatch, resp.StatusCode)
+				}
+				details, _ := envelope.Error.Details.(map[string]interface{})
+				if CodePreconditionFailed != envelope.Error.Code || etag != details["etag"] {
+					t.Errorf("If-Match %s: expected %s with the current ETag %s, got %+v", ifMatch, CodePreconditionFailed, etag, envelope.Error)
+				}
+			}
+			delete(headers, "If-Match")
+			if resp := call(t, ts, "GET", tc.get, headers, "", nil); 200 != resp.StatusCode {
+				t.Fatalf("the resource got deleted by a failed precondition, status %d", resp.StatusCode)
+			}
+
+			headers["If-Match"] = `"0", ` + etag
+			if resp := call(t, ts, "DELETE", tc.delete, headers, "", nil); 200 != resp.StatusCode {
+				t.Fatalf("expected status 200 for a list holding the ETag, got %d", resp.StatusCode)
+			}
+			delete(headers, "If-Match")
+			if resp := call(t, ts, "GET", tc.get, headers, "", nil); 404 != resp.StatusCode {
+				t.Errorf("expected the resource to be deleted, got status %d", resp.StatusCode)
+			}
+		})
+	}
+
+	// a star matches any existing resource
+	headers := newConditionalFixture(t)
+	_, ts := newTestServer(t, nil)
+	headers["If-Match"] = "*"
+	if resp := call(t, ts, "DELETE", "/v1/deleteEntity?type=Person&id=2", headers, "", nil); 200 != resp.StatusCode {
+		t.Errorf("expected status 200 for If-Match *, got %d", resp.StatusCode)
+	}
+	if resp := call(t, ts, "DELETE", "/v1/deleteEntity?type=Person&id=2", headers, "", nil); 404 != resp.StatusCode {
+		t.Errorf("expected status 404 for If-Match * on a deleted entity, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,299 @@
+package gitsapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// CSVMapping describes how the columns of a CSV import become entities
+// and relations. Columns are referenced by their header.
+type CSVMapping struct {
+	Delimiter string
+	Entities  []CSVEntityMapping
+	Relations []CSVRelationMapping
+}
+
+// CSVEntityMapping maps columns onto one entity per row. The type is
+// either fixed or read from TypeColumn, properties map a property key
+// to the column holding its value.
+type CSVEntityMapping struct {
+	Name        string
+	Type        string
+	TypeColumn  string
+	ValueColumn string
+	Context     string
+	Properties  map[string]string
+	// reuse the existing entity with the same type and value, and the same
+	// context if one is set. The properties of the row get merged into it.
+	Deduplicate bool
+}
+
+// CSVRelationMapping relates two entity mappings by their names
+type CSVRelationMapping struct {
+	Source string
+	Target string
+}
+
+// csvImporter holds the validated mapping and the column indexes
+type csvImporter struct {
+	*importer
+	mapping CSVMapping
+	columns map[string]int
+}
+
+func handleImportCSV(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "POST" != r.Method {
+		respondError(methodNotAllowedError("POST"), w)
+		return
+	}
+	defer r.Body.Close()
+
+	// the mapping part has to come first, so the data part can be
+	// processed row by row while it is streamed
+	parts, err := r.MultipartReader()
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+	part, err := parts.NextPart()
+	if nil != err || "mapping" != part.FormName() {
+		respondError(newApiError(http.StatusBadRequest, CodeMissingParameter, "The first part has to be the mapping", map[string]string{"part": "mapping"}), w)
+		return
+	}
+	var mapping CSVMapping
+	if err := json.NewDecoder(part).Decode(&mapping); nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+	if apiErr := validateCSVMapping(&mapping); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	part, err = parts.NextPart()
+	if nil != err || "data" != part.FormName() {
+		respondError(newApiError(http.StatusBadRequest, CodeMissingParameter, "The second part has to be the csv data", map[string]string{"part": "data"}), w)
+		return
+	}
+
+	reader := csv.NewReader(part)
+	reader.Comma, _ = utf8.DecodeRuneInString(mapping.Delimiter)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+
+	imp := &csvImporter{importer: newImporter(r), mapping: mapping, columns: make(map[string]int)}
+	for index, column := range header {
+		imp.columns[column] = index
+	}
+	if apiErr := imp.checkColumns(); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	for {
+		record, err := reader.Read()
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			// broken rows are reported, the following ones are still read
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				respondError(malformedBodyError(err), w)
+				return
+			}
+			imp.fail(ImportResult{Line: parseErr.Line}, malformedBodyError(err))
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		imp.importRow(line, record)
+	}
+
+	archivist.Info("> Imported csv rows, mapped/failed:", imp.report.Created, imp.report.Failed)
+	respondJson(imp.report, 200, w)
+}
+
+// validateCSVMapping checks the mapping before any row is read
+func validateCSVMapping(mapping *CSVMapping) *apiError {
+	if "" == mapping.Delimiter {
+		mapping.Delimiter = ","
+	}
+	if 1 != utf8.RuneCountInString(mapping.Delimiter) || "\"" == mapping.Delimiter {
+		return newApiError(http.StatusBadRequest, CodeInvalidParameter, "The delimiter has to be a single character", map[string]string{"field": "Delimiter"})
+	}
+	if 0 == len(mapping.Entities) {
+		return newApiError(http.StatusBadRequest, CodeMissingParameter, "The mapping contains no entities", map[string]string{"field": "Entities"})
+	}
+
+	names := make(map[string]bool)
+	for i := range mapping.Entities {
+		entity := &mapping.Entities[i]
+		field := "Entities[" + strconv.Itoa(i) + "]"
+		if ("" == entity.Type) == ("" == entity.TypeColumn) {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Either Type or TypeColumn has to be given", map[string]string{"field": field + ".Type"})
+		}
+		if "" == entity.ValueColumn {
+			return newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing ValueColumn", map[string]string{"field": field + ".ValueColumn"})
+		}
+		if "" == entity.Name {
+			entity.Name = entity.Type
+		}
+		if "" == entity.Name {
+			return newApiError(http.StatusBadRequest, CodeMissingParameter, "Entities with a TypeColumn need a Name", map[string]string{"field": field + ".Name"})
+		}
+		if names[entity.Name] {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "The name '"+entity.Name+"' is used twice", map[string]string{"field": field + ".Name"})
+		}
+		names[entity.Name] = true
+	}
+
+	for i, relation := range mapping.Relations {
+		field := "Relations[" + strconv.Itoa(i) + "]"
+		if !names[relation.Source] {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown entity '"+relation.Source+"'", map[string]string{"field": field + ".Source"})
+		}
+		if !names[relation.Target] {
+			return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown entity '"+relation.Target+"'", map[string]string{"field": field + ".Target"})
+		}
+	}
+	return nil
+}
+
+// checkColumns makes sure all mapped columns are in the header
+func (imp *csvImporter) checkColumns() *apiError {
+	for _, entity := range imp.mapping.Entities {
+		columns := []string{entity.ValueColumn}
+		if "" != entity.TypeColumn {
+			columns = append(columns, entity.TypeColumn)
+		}
+		for _, column := range entity.Properties {
+			columns = append(columns, column)
+		}
+		for _, column := range columns {
+			if _, ok := imp.columns[column]; !ok {
+				return newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown column '"+column+"'", map[string]string{"entity": entity.Name})
+			}
+		}
+	}
+	return nil
+}
+
+// mapEntity creates the entity of a row. Deduplicated entities are
+// upserted with the merge strategy instead, lookup and update happen
+// within one write.
+func (imp *csvImporter) mapEntity(entity transport.TransportEntity, deduplicate bool, result *ImportResult) (transport.TransportEntity, *apiError) {
+	if apiErr := authorizeMapping(grantFromRequest(imp.r), entity); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	if !deduplicate {
+		return mapData(storageFromRequest(imp.r), entity)
+	}
+	if apiErr := authorize(imp.r, auth.ActionUpdate, entity.Type); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	upserted, apiErr := upsertEntity(storageFromRequest(imp.r), UpsertRequest{
+		Type:       entity.Type,
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Properties: entity.Properties,
+		Strategy:   UpsertMerge,
+	})
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	result.Existing = !upserted.Created
+	return upserted.Entity, nil
+}
+
+func (imp *csvImporter) cell(record []string, column string) string {
+	if index := imp.columns[column]; index < len(record) {
+		return record[index]
+	}
+	return ""
+}
+
+// importRow maps the entities of the row one by one, then relates them.
+// Entities with an empty value cell are skipped along with their
+// relations.
+func (imp *csvImporter) importRow(line int, record []string) {
+	mapped := make(map[string]transport.TransportEntity)
+	for _, entityMapping := range imp.mapping.Entities {
+		entity := transport.TransportEntity{
+			ID:      -1,
+			Type:    entityMapping.Type,
+			Value:   imp.cell(record, entityMapping.ValueColumn),
+			Context: entityMapping.Context,
+		}
+		if "" == entity.Value {
+			continue
+		}
+		if "" != entityMapping.TypeColumn {
+			entity.Type = imp.cell(record, entityMapping.TypeColumn)
+		}
+		if 0 < len(entityMapping.Properties) {
+			entity.Properties = make(map[string]string, len(entityMapping.Properties))
+			for key, column := range entityMapping.Properties {
+				entity.Properties[key] = imp.cell(record, column)
+			}
+		}
+
+		result := ImportResult{Line: line, Type: entity.Type}
+		if "" == entity.Type {
+			imp.fail(result, newApiError(http.StatusBadRequest, CodeMissingParameter, "Empty type column '"+entityMapping.TypeColumn+"'", map[string]string{"entity": entityMapping.Name}))
+			continue
+		}
+		created, apiErr := imp.mapEntity(entity, entityMapping.Deduplicate, &result)
+		if nil != apiErr {
+			imp.fail(result, apiErr)
+			continue
+		}
+		mapped[entityMapping.Name] = created
+		result.ID = created.ID
+		imp.succeed(result)
+	}
+
+	for _, relationMapping := range imp.mapping.Relations {
+		source, sourceOk := mapped[relationMapping.Source]
+		target, targetOk := mapped[relationMapping.Target]
+		if !sourceOk || !targetOk {
+			continue
+		}
+		result := ImportResult{Line: line, SourceType: source.Type, SourceID: source.ID, TargetType: target.Type, TargetID: target.ID}
+
+		// the entities exist now, MapData only adds the missing relation
+		mapping := transport.TransportEntity{
+			Type: source.Type,
+			ID:   source.ID,
+			ChildRelations: []transport.TransportRelation{
+				{Target: transport.TransportEntity{Type: target.Type, ID: target.ID}},
+			},
+		}
+		if apiErr := authorizeMapping(grantFromRequest(imp.r), mapping); nil != apiErr {
+			imp.fail(result, apiErr)
+			continue
+		}
+		if _, apiErr := mapData(storageFromRequest(imp.r), mapping); nil != apiErr {
+			imp.fail(result, apiErr)
+			continue
+		}
+		imp.succeed(result)
+	}
+}
@@ -0,0 +1,138 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/voodooEntity/archivist"
+)
+
+// ErrorCode is the stable machine readable identifier of an api error.
+// Clients should switch on the code, the message is meant for humans.
+type ErrorCode string
+
+const (
+	CodeRouteNotFound        ErrorCode = "ROUTE_NOT_FOUND"
+	CodeUnauthorized         ErrorCode = "UNAUTHORIZED"
+	CodeForbidden            ErrorCode = "FORBIDDEN"
+	CodeMethodNotAllowed     ErrorCode = "METHOD_NOT_ALLOWED"
+	CodeMissingParameter     ErrorCode = "MISSING_PARAMETER"
+	CodeInvalidParameter     ErrorCode = "INVALID_PARAMETER"
+	CodeMalformedBody        ErrorCode = "MALFORMED_BODY"
+	CodeUnsupportedMediaType ErrorCode = "UNSUPPORTED_MEDIA_TYPE"
+	CodeEntityTypeNotFound   ErrorCode = "ENTITY_TYPE_NOT_FOUND"
+	CodeEntityNotFound       ErrorCode = "ENTITY_NOT_FOUND"
+	CodeRelationNotFound     ErrorCode = "RELATION_NOT_FOUND"
+	CodeVersionConflict      ErrorCode = "VERSION_CONFLICT"
+	CodePreconditionFailed   ErrorCode = "PRECONDITION_FAILED"
+	CodePatchFailed          ErrorCode = "PATCH_FAILED"
+	CodeStorageNotFound      ErrorCode = "STORAGE_NOT_FOUND"
+	CodeStorageExists        ErrorCode = "STORAGE_EXISTS"
+	CodeStorageIsDefault     ErrorCode = "STORAGE_IS_DEFAULT"
+	CodeSnapshotsDisabled    ErrorCode = "SNAPSHOTS_DISABLED"
+	CodeSnapshotNotFound     ErrorCode = "SNAPSHOT_NOT_FOUND"
+	CodeFailedDependency     ErrorCode = "FAILED_DEPENDENCY"
+	CodeJobNotFound          ErrorCode = "JOB_NOT_FOUND"
+	CodeTooManyJobs          ErrorCode = "TOO_MANY_JOBS"
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"
+)
+
+// apiError is the payload of every error response, it gets
+// wrapped into {"error": {...}} when sent to the client
+type apiError struct {
+	Status  int         `json:"-"`
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error *apiError `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func newApiError(status int, code ErrorCode, message string, details interface{}) *apiError {
+	return &apiError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+		Details: details,
+	}
+}
+
+func methodNotAllowedError(allowed string) *apiError {
+	return newApiError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Invalid http method for this path", map[string]string{"allowed": allowed})
+}
+
+func malformedBodyError(err error) *apiError {
+	if nil == err {
+		return newApiError(http.StatusBadRequest, CodeMalformedBody, "Malformed or no body", nil)
+	}
+	return newApiError(http.StatusBadRequest, CodeMalformedBody, "Malformed or no body", map[string]string{"reason": err.Error()})
+}
+
+func entityNotFoundError() *apiError {
+	return newApiError(http.StatusNotFound, CodeEntityNotFound, "Entity does not exist", nil)
+}
+
+func relationNotFoundError() *apiError {
+	return newApiError(http.StatusNotFound, CodeRelationNotFound, "Relation does not exist", nil)
+}
+
+func storageNotFoundError(name string) *apiError {
+	return newApiError(http.StatusNotFound, CodeStorageNotFound, "Storage does not exist", map[string]string{"storage": name})
+}
+
+func routeNotFoundError(r *http.Request) *apiError {
+	return newApiError(http.StatusNotFound, CodeRouteNotFound, "Unknown api route", map[string]string{"path": r.URL.Path})
+}
+
+func internalError(message string) *apiError {
+	return newApiError(http.StatusInternalServerError, CodeInternal, message, nil)
+}
+
+// storageError translates the plain errors returned by the gits storage
+// into api errors. gits only hands out message strings, so this is the
+// one place knowing about them.
+func storageError(err error) *apiError {
+	switch err.Error() {
+	case "Mismatch of version.":
+		return newApiError(http.StatusConflict, CodeVersionConflict, "Given version does not match the stored version", nil)
+	case "Entity on given path does not exist.", "Cant update non existing entity":
+		return entityNotFoundError()
+	case "Non existing relation requested", "Cant update non existing relation":
+		return relationNotFoundError()
+	case "Entity Type string does not exist", "Entity Type ID does not exist", "CreateEntity.Entity Type not existing", "Source Type not existing", "Target Type not existing":
+		return newApiError(http.StatusNotFound, CodeEntityTypeNotFound, "Entity type does not exist", nil)
+	}
+	return internalError(err.Error())
+}
+
+func respondError(apiErr *apiError, w http.ResponseWriter) {
+	if http.StatusInternalServerError <= apiErr.Status {
+		archivist.Error("Request failed with internal error", apiErr.Message)
+	}
+
+	responseData, err := json.Marshal(errorEnvelope{Error: apiErr})
+	if nil != err {
+		// details are the only part that could fail to encode, so retry without
+		archivist.Error("Could not encode error response", err.Error())
+		apiErr.Details = nil
+		responseData, _ = json.Marshal(errorEnvelope{Error: apiErr})
+	}
+
+	if http.StatusMethodNotAllowed == apiErr.Status {
+		if details, ok := apiErr.Details.(map[string]string); ok {
+			w.Header().Set("Allow", details["allowed"])
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	_, err = w.Write(responseData)
+	if nil != err {
+		archivist.Error("Could not write http response body ", err, string(responseData))
+	}
+}
@@ -0,0 +1,295 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gits/src/types"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// exportFlushInterval is the amount of records written between flushes
+const exportFlushInterval = 1000
+
+// ExportEntityType is the export record of an entity type, types are
+// exported as well so empty ones survive a migration
+type ExportEntityType struct {
+	EntityType string
+}
+
+// exportFilter limits an export. Entities are filtered by type and
+// context, relations are exported if both of their ends are.
+type exportFilter struct {
+	types   map[string]bool
+	context string
+	grant   *auth.Grant
+	// negate the entity IDs, so the export can be fed to /v1/import
+	tempIDs bool
+}
+
+// exportWriter receives the exported records, first all types, then
+// all entities and finally all relations
+type exportWriter interface {
+	EntityType(name string) error
+	Entity(entity transport.TransportEntity) error
+	Relation(relation transport.TransportRelation) error
+	Close() error
+}
+
+func (s *Server) registerExportRoutes() {
+	// Route: /v1/export
+	s.handleFunc("/v1/export", s.requireScope(auth.ScopeRead, handleExport))
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "GET" != r.Method {
+		respondError(methodNotAllowedError("GET"), w)
+		return
+	}
+
+	filter := exportFilterFromRequest(r)
+
+	format := negotiateGraphFormat(r)
+	if nil != format {
+		filter.tempIDs = false
+	}
+
+	// graph formats are built in memory before they are sent
+	if nil != format && nil == format.stream {
+		doc := newGraphDocument()
+		if err := exportStorage(storageFromRequest(r), filter, doc); nil != err {
+			respondError(internalError(err.Error()), w)
+			return
+		}
+		respondGraph(format, doc, serverFromRequest(r).rdfBase, w)
+		return
+	}
+
+	var out exportWriter
+	if nil != format {
+		w.Header().Set("Content-Type", format.MediaType+"; charset=utf-8")
+		out = format.stream(w, serverFromRequest(r).rdfBase)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		out = newNdjsonExportWriter(w)
+	}
+	w.WriteHeader(200)
+
+	// once streaming started errors can only be logged
+	if err := exportStorage(storageFromRequest(r), filter, out); nil != err {
+		archivist.Error("Export aborted", err.Error())
+	}
+}
+
+// exportFilterFromRequest reads the optional type and context params,
+// type can be given several times or as comma separated list
+func exportFilterFromRequest(r *http.Request) exportFilter {
+	filter := exportFilter{
+		context: r.URL.Query().Get("context"),
+		grant:   grantFromRequest(r),
+		tempIDs: "true" == r.URL.Query().Get("tempIDs"),
+	}
+	for _, param := range r.URL.Query()["type"] {
+		for _, typeStr := range strings.Split(param, ",") {
+			if typeStr = strings.TrimSpace(typeStr); "" != typeStr {
+				if nil == filter.types {
+					filter.types = make(map[string]bool)
+				}
+				filter.types[typeStr] = true
+			}
+		}
+	}
+	return filter
+}
+
+func (filter exportFilter) includesType(typeStr string) bool {
+	if nil != filter.types && !filter.types[typeStr] {
+		return false
+	}
+	return filter.grant.Allows(auth.ActionRead, typeStr)
+}
+
+// exportStorage walks the storage type by type. The storage is only
+// locked while the entities or relations of one type get copied, so
+// writers aren't blocked by slow clients. The export therefore isn't a
+// point in time copy, use a snapshot for that.
+func exportStorage(g *instance, filter exportFilter, out exportWriter) error {
+	entityTypes := g.Storage().GetEntityTypes()
+	typeIDs := []int{}
+	for typeID, typeStr := range entityTypes {
+		if filter.includesType(typeStr) {
+			typeIDs = append(typeIDs, typeID)
+		}
+	}
+	sort.Slice(typeIDs, func(i, j int) bool { return entityTypes[typeIDs[i]] < entityTypes[typeIDs[j]] })
+
+	for _, typeID := range typeIDs {
+		if err := out.EntityType(entityTypes[typeID]); nil != err {
+			return err
+		}
+	}
+
+	// with a context filter the exported entities have to be remembered,
+	// otherwise the type decides if a relation end got exported
+	var exported map[[2]int]bool
+	if "" != filter.context {
+		exported = make(map[[2]int]bool)
+	}
+	included := make(map[int]bool)
+	for _, typeID := range typeIDs {
+		included[typeID] = true
+		for _, entity := range copyEntitiesOfType(g, typeID) {
+			if "" != filter.context && filter.context != entity.Context {
+				continue
+			}
+			if nil != exported {
+				exported[[2]int{entity.Type, entity.ID}] = true
+			}
+			id := entity.ID
+			if filter.tempIDs {
+				id = -id
+			}
+			if err := out.Entity(transport.TransportEntity{
+				Type:       entityTypes[typeID],
+				ID:         id,
+				Value:      entity.Value,
+				Context:    entity.Context,
+				Properties: entity.Properties,
+				Version:    entity.Version,
+			}); nil != err {
+				return err
+			}
+		}
+	}
+
+	for _, typeID := range typeIDs {
+		for _, relation := range copyRelationsOfType(g, typeID) {
+			if !included[relation.TargetType] {
+				continue
+			}
+			if nil != exported && (!exported[[2]int{relation.SourceType, relation.SourceID}] || !exported[[2]int{relation.TargetType, relation.TargetID}]) {
+				continue
+			}
+			sourceID, targetID := relation.SourceID, relation.TargetID
+			if filter.tempIDs {
+				sourceID, targetID = -sourceID, -targetID
+			}
+			if err := out.Relation(transport.TransportRelation{
+				SourceType: entityTypes[relation.SourceType],
+				SourceID:   sourceID,
+				TargetType: entityTypes[relation.TargetType],
+				TargetID:   targetID,
+				Context:    relation.Context,
+				Properties: relation.Properties,
+				Version:    relation.Version,
+			}); nil != err {
+				return err
+			}
+		}
+	}
+	return out.Close()
+}
+
+// copyEntitiesOfType returns the entities of a type sorted by ID
+func copyEntitiesOfType(g *instance, typeID int) []types.StorageEntity {
+	store := g.Storage()
+	store.EntityStorageMutex.RLock()
+	ret := make([]types.StorageEntity, 0, len(store.EntityStorage[typeID]))
+	for _, entity := range store.EntityStorage[typeID] {
+		entity.Properties = copyStringMap(entity.Properties)
+		ret = append(ret, entity)
+	}
+	store.EntityStorageMutex.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// copyRelationsOfType returns the relations starting at entities of
+// the type, sorted by their addresses
+func copyRelationsOfType(g *instance, typeID int) []types.StorageRelation {
+	store := g.Storage()
+	store.RelationStorageMutex.RLock()
+	ret := []types.StorageRelation{}
+	for _, targetTypes := range store.RelationStorage[typeID] {
+		for _, targetIDs := range targetTypes {
+			for _, relation := range targetIDs {
+				relation.Properties = copyStringMap(relation.Properties)
+				ret = append(ret, relation)
+			}
+		}
+	}
+	store.RelationStorageMutex.RUnlock()
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].SourceID != ret[j].SourceID {
+			return ret[i].SourceID < ret[j].SourceID
+		}
+		if ret[i].TargetType != ret[j].TargetType {
+			return ret[i].TargetType < ret[j].TargetType
+		}
+		return ret[i].TargetID < ret[j].TargetID
+	})
+	return ret
+}
+
+func copyStringMap(data map[string]string) map[string]string {
+	ret := make(map[string]string, len(data))
+	for key, value := range data {
+		ret[key] = value
+	}
+	return ret
+}
+
+// ndjsonExportWriter writes one json document per line and flushes
+// the response every exportFlushInterval records
+type ndjsonExportWriter struct {
+	w       http.ResponseWriter
+	encoder *json.Encoder
+	pending int
+}
+
+func newNdjsonExportWriter(w http.ResponseWriter) *ndjsonExportWriter {
+	return &ndjsonExportWriter{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (nw *ndjsonExportWriter) EntityType(name string) error {
+	return nw.write(ExportEntityType{EntityType: name})
+}
+
+func (nw *ndjsonExportWriter) Entity(entity transport.TransportEntity) error {
+	return nw.write(entity)
+}
+
+func (nw *ndjsonExportWriter) Relation(relation transport.TransportRelation) error {
+	return nw.write(relation)
+}
+
+func (nw *ndjsonExportWriter) write(record interface{}) error {
+	if err := nw.encoder.Encode(record); nil != err {
+		return err
+	}
+	if nw.pending++; exportFlushInterval <= nw.pending {
+		nw.flush()
+	}
+	return nil
+}
+
+func (nw *ndjsonExportWriter) flush() {
+	nw.pending = 0
+	if flusher, ok := nw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (nw *ndjsonExportWriter) Close() error {
+	nw.flush()
+	return nil
+}
@@ -2,10 +2,9 @@ package gitsapi
 
 import (
 	"encoding/json"
-	"errors"
 	"github.com/voodooEntity/gits/src/query"
 	"github.com/voodooEntity/gits/src/transport"
-	"github.com/voodooEntity/gits/src/types"
+	"github.com/voodooEntity/gitsapi/src/auth"
 	"github.com/voodooEntity/gitsapi/src/config"
 	"io/ioutil"
 	"net/http"
@@ -13,24 +12,47 @@ import (
 	"strconv"
 
 	"github.com/voodooEntity/archivist"
-	"github.com/voodooEntity/gits"
 )
 
 var version = "0.1.0"
 
+// ServeMux is the mux used by Start(). It stays exposed so existing
+// programs can keep registering their own routes on it.
 var ServeMux = http.NewServeMux()
 
+// Start registers all routes on the package level ServeMux and blocks
+// while serving them. Any listen error terminates the process, use
+// NewServer for a server that can be stopped and reports its errors.
 func Start() {
-	archivist.Info("> Bootin Gits HTTP API Version: " + version)
+	conf, err := config.Current()
+	if nil != err {
+		archivist.Error(err.Error())
+		os.Exit(0)
+	}
+	s, err := newServer(ServeMux, conf)
+	if nil == err {
+		err = s.ListenAndServe()
+	}
+	if nil != err {
+		archivist.Error(err.Error())
+		os.Exit(0)
+	}
+}
 
+func (s *Server) registerRoutes() {
 	// Route: /v1/ping
-	ServeMux.HandleFunc("/v1/ping", func(w http.ResponseWriter, r *http.Request) {
+	s.handleFunc("/v1/ping", func(w http.ResponseWriter, r *http.Request) {
 		respond("pong", 200, w)
 	})
 
+	// Route: /v1/ catches every unknown path below /v1
+	s.handleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		respondError(routeNotFoundError(r), w)
+	})
+
 	// Route: /v1/mapJson
-	ServeMux.HandleFunc("/v1/mapJson", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/mapJson", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -39,42 +61,46 @@ func Start() {
 
 		// check http method
 		if "POST" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("POST"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			archivist.Error("Could not read http request body", err.Error())
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
 		// unpack the json
 		var transportData transport.TransportEntity
 		if err := json.Unmarshal(body, &transportData); err != nil {
-			archivist.Error("Invalid json query object", err.Error())
-			http.Error(w, "Invalid json query object "+err.Error(), 422)
+			respondError(malformedBodyError(err), w)
+			return
+		}
+
+		// every mapped entity has to be covered by the policy
+		if apiErr := authorizeMapping(grantFromRequest(r), transportData); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// lets pass the body to our mapper
 		// that will recursive map the entities
-		responseData := dispatchStorage(r).MapData(transportData)
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		responseData, apiErr := mapData(storageFromRequest(r), transportData)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		respondOk(transport.Transport{
 			Entities: []transport.TransportEntity{responseData},
 		}, w)
-	})
+	}))
 
 	// Route: /v1/query
-	ServeMux.HandleFunc("/v1/query", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/query", s.requireScope(auth.ScopeQuery, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -83,15 +109,14 @@ func Start() {
 
 		// check http method
 		if "POST" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("POST"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			archivist.Error("Could not read http request body", err.Error())
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
@@ -99,25 +124,42 @@ func Start() {
 		var qry query.Query
 		err = json.Unmarshal(body, &qry)
 		if err != nil {
-			archivist.Error("Invalid json query object", err.Error())
-			http.Error(w, "Invalid json query object ", 422)
+			respondError(malformedBodyError(err), w)
+			return
+		}
+
+		// restrict the query to what the policy grants
+		if apiErr := restrictQuery(grantFromRequest(r), &qry); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// lets pass the body to our mapper
 		// that will recursive map the entities
-		responseData := dispatchStorage(r).Query().Execute(&qry)
+		responseData, apiErr := executeQuery(storageFromRequest(r), &qry)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
 
-		respondOk(responseData, w)
-	})
+		// the result can also be requested as graph
+		if format := negotiateGraphFormat(r); nil != format {
+			doc := newGraphDocument()
+			doc.addTransport(filterTransport(grantFromRequest(r), responseData))
+			respondGraph(format, doc, s.rdfBase, w)
+			return
+		}
+
+		respondOk(filterTransport(grantFromRequest(r), responseData), w)
+	}))
 
 	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
 	// Direct storage functions
 	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
 
 	// Route: /v1/getEntityByTypeAndId
-	ServeMux.HandleFunc("/v1/getEntityByTypeAndId", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getEntityByTypeAndId", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -126,7 +168,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -134,64 +176,44 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// get type id for given string
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
-		if nil != err {
-			http.Error(w, string(err.Error()), 404)
+		// read the data
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// read the data
-		data, err := dispatchStorage(r).Storage().GetEntityByPath(typeID, id, "")
-
-		// if error respond
-		if nil != err {
-			http.Error(w, string(err.Error()), 404)
+		entity, apiErr := readEntity(storageFromRequest(r), urlParams["type"], id)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// retrieve the type string
-		typeStr, err := dispatchStorage(r).Storage().GetTypeStringById(data.Type)
-		if err != nil {
-			http.Error(w, string(err.Error()), 404)
+		if respondNotModified(w, r, entity.Version) {
 			return
 		}
 
 		// all seems fine lets return the data
 		respondOk(transport.Transport{
-			Entities: []transport.TransportEntity{
-				{
-					ID:         data.ID,
-					Type:       typeStr,
-					Context:    data.Context,
-					Value:      data.Value,
-					Properties: data.Properties,
-					Version:    data.Version,
-				},
-			},
+			Entities: []transport.TransportEntity{entity},
 		}, w)
-	})
+	}))
 
 	// Route: /v1/createEntity
-	ServeMux.HandleFunc("/v1/createEntity", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/createEntity", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -200,14 +222,14 @@ func Start() {
 
 		// check http method
 		if "POST" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("POST"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
@@ -215,47 +237,29 @@ func Start() {
 		var newEntity transport.TransportEntity
 		err = json.Unmarshal(body, &newEntity)
 		if nil != err {
-			http.Error(w, "Malformed json body.", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
-		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newEntity.Type)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we create the entity
+		if apiErr := authorize(r, auth.ActionCreate, newEntity.Type); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// finally we create the entity
-		newID, err := dispatchStorage(r).Storage().CreateEntity(types.StorageEntity{
-			Type:       typeID,
-			ID:         -1,
-			Properties: newEntity.Properties,
-			Context:    newEntity.Context,
-		})
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		entity, apiErr := createEntity(storageFromRequest(r), newEntity)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		respondOk(transport.Transport{
-			Entities: []transport.TransportEntity{
-				{
-					ID:         newID,
-					Type:       newEntity.Type,
-					Value:      newEntity.Value,
-					Context:    newEntity.Context,
-					Properties: newEntity.Properties,
-					Version:    1,
-				},
-			},
+			Entities: []transport.TransportEntity{entity},
 		}, w)
-	})
+	}))
 
 	// Route: /v1/getEntitiesByType
-	ServeMux.HandleFunc("/v1/getEntitiesByType", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getEntitiesByType", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -264,19 +268,18 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 403)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
 		// first we get the params
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -293,35 +296,28 @@ func Start() {
 		}
 
 		// ok we seem to be fine on types, lets call the actual getter method
-		entities, err := dispatchStorage(r).Storage().GetEntitiesByType(urlParams["type"], context)
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// translate the storage format to our transport format
-		responseData := transport.Transport{
-			Entities: []transport.TransportEntity{},
+		listParams, apiErr := getListParams(r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
 		}
-
-		for _, val := range entities {
-			responseData.Entities = append(responseData.Entities, transport.TransportEntity{
-				ID:         val.ID,
-				Type:       requiredUrlParams["type"],
-				Context:    val.Context,
-				Value:      val.Value,
-				Properties: val.Properties,
-				Version:    val.Version,
-			})
+		entities, apiErr := readEntitiesByType(storageFromRequest(r), urlParams["type"], context)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
 		}
 
 		// all seems fine lets return the data
-		respondOk(responseData, w)
-	})
+		respondEntities(entities, listParams, w)
+	}))
 
 	// Route: /v1/getEntitiesByTypeAndValue
-	ServeMux.HandleFunc("/v1/getEntitiesByTypeAndValue", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getEntitiesByTypeAndValue", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -330,7 +326,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -338,12 +334,11 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["value"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -368,9 +363,18 @@ func Start() {
 		}
 
 		// ok we seem to be fine on types, lets call the actual getter method
-		entities, err := dispatchStorage(r).Storage().GetEntitiesByTypeAndValue(urlParams["type"], urlParams["value"], mode, context)
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		listParams, apiErr := getListParams(r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		entities, err := storageFromRequest(r).Storage().GetEntitiesByTypeAndValue(urlParams["type"], urlParams["value"], mode, context)
 		if nil != err {
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
@@ -382,7 +386,7 @@ func Start() {
 		for _, val := range entities {
 			responseData.Entities = append(responseData.Entities, transport.TransportEntity{
 				ID:         val.ID,
-				Type:       requiredUrlParams["type"],
+				Type:       urlParams["type"],
 				Context:    val.Context,
 				Value:      val.Value,
 				Properties: val.Properties,
@@ -391,13 +395,13 @@ func Start() {
 		}
 
 		// all seems fine lets return the data
-		respondOk(responseData, w)
-	})
+		respondEntities(responseData.Entities, listParams, w)
+	}))
 
 	// Route: /v1/deleteEntity
-	ServeMux.HandleFunc("/v1/deleteEntity", func(w http.ResponseWriter, r *http.Request) {
+	s.handleFunc("/v1/deleteEntity", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
 
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -406,7 +410,7 @@ func Start() {
 
 		// check http method
 		if "DELETE" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("DELETE"), w)
 			return
 		}
 
@@ -414,38 +418,37 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we delete the entity
+		if apiErr := authorize(r, auth.ActionDelete, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		apiErr = deleteEntityIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), urlParams["type"], id)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// finally we delete the entity
-		dispatchStorage(r).Storage().DeleteEntity(typeID, id)
 
 		respond("", 200, w)
-	})
+	}))
 
 	// Route: /v1/updateEntity
-	ServeMux.HandleFunc("/v1/updateEntity", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/updateEntity", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -454,14 +457,14 @@ func Start() {
 
 		// check http method
 		if "PUT" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("PUT"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
@@ -469,39 +472,28 @@ func Start() {
 		var newEntity transport.TransportEntity
 		err = json.Unmarshal(body, &newEntity)
 		if nil != err {
-			http.Error(w, "Malformed json body.", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
-		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newEntity.Type)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we update the entity
+		if apiErr := authorize(r, auth.ActionUpdate, newEntity.Type); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// finally we update the entity
-		err = dispatchStorage(r).Storage().UpdateEntity(types.StorageEntity{
-			Type:       typeID,
-			ID:         newEntity.ID,
-			Value:      newEntity.Value,
-			Context:    newEntity.Context,
-			Properties: newEntity.Properties,
-			Version:    newEntity.Version,
-		})
-
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		entity, apiErr := updateEntityIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), newEntity)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
+		setEntityTag(w, entity.Version)
 
 		respond("", 200, w)
-	})
+	}))
 
 	// Route: /v1/getChildEntities
-	ServeMux.HandleFunc("/v1/getChildEntities", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getChildEntities", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -510,7 +502,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -518,20 +510,18 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -547,46 +537,25 @@ func Start() {
 			context = urlParams["context"]
 		}
 
-		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
-			return
-		}
-
 		// retrieve the child entities if given
-		childRelations, err := dispatchStorage(r).Storage().GetChildRelationsBySourceTypeAndSourceId(typeID, id, context)
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// build response data by getting entities based on childRelation data into transport format
-		returnData := transport.Transport{
-			Entities: []transport.TransportEntity{},
-		}
-
-		for _, val := range childRelations {
-			entity, err := dispatchStorage(r).Storage().GetEntityByPath(val.TargetType, val.TargetID, "")
-			if nil != err {
-				returnData.Entities = append(returnData.Entities, transport.TransportEntity{
-					ID:         entity.ID,
-					Type:       urlParams["type"],
-					Value:      entity.Value,
-					Context:    entity.Context,
-					Properties: entity.Properties,
-					Version:    entity.Version,
-				})
-			}
+		entities, apiErr := readChildEntities(storageFromRequest(r), urlParams["type"], id, context)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
 		}
 
-		respondOk(returnData, w)
-	})
+		respondOk(transport.Transport{
+			Entities: filterEntities(grantFromRequest(r), entities),
+		}, w)
+	}))
 
 	// Route: /v1/getParentEntities
-	ServeMux.HandleFunc("/v1/getParentEntities", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getParentEntities", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -595,7 +564,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -603,20 +572,18 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -632,46 +599,25 @@ func Start() {
 			context = urlParams["context"]
 		}
 
-		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// retrieve the parent entities if given
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		// retrieve the child entities if given
-		parentRelations, err := dispatchStorage(r).Storage().GetParentRelationsByTargetTypeAndTargetId(typeID, id, context)
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		entities, apiErr := readParentEntities(storageFromRequest(r), urlParams["type"], id, context)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// build response data by getting entities based on childRelation data into transport format
-		returnData := transport.Transport{
-			Entities: []transport.TransportEntity{},
-		}
-
-		for _, val := range parentRelations {
-			entity, err := dispatchStorage(r).Storage().GetEntityByPath(val.SourceType, val.SourceID, "")
-			if nil != err {
-				returnData.Entities = append(returnData.Entities, transport.TransportEntity{
-					ID:         entity.ID,
-					Type:       urlParams["type"],
-					Value:      entity.Value,
-					Context:    entity.Context,
-					Properties: entity.Properties,
-					Version:    entity.Version,
-				})
-			}
-		}
-
-		respondOk(returnData, w)
-	})
+		respondOk(transport.Transport{
+			Entities: filterEntities(grantFromRequest(r), entities),
+		}, w)
+	}))
 
 	// Route: /v1/getRelationsTo
-	ServeMux.HandleFunc("/v1/getRelationsTo", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getRelationsTo", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -680,7 +626,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -688,20 +634,18 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -718,22 +662,25 @@ func Start() {
 		}
 
 		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		typeID, err := storageFromRequest(r).Storage().GetTypeIdByString(urlParams["type"])
 		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
 		// retrieve the child entities if given
-		relations, err := dispatchStorage(r).Storage().GetParentRelationsByTargetTypeAndTargetId(typeID, id, context)
+		relations, err := storageFromRequest(r).Storage().GetParentRelationsByTargetTypeAndTargetId(typeID, id, context)
 		if nil != err {
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
 		// since we could have every possible type in our results we gonne go the easy way and retrieve all entity types for easier result translation
-		entityTypes := dispatchStorage(r).Storage().GetEntityTypes()
+		entityTypes := storageFromRequest(r).Storage().GetEntityTypes()
 
 		// prepare return data and write retrieved relations into the fitting format
 		returnData := transport.Transport{
@@ -750,13 +697,14 @@ func Start() {
 				Version:    val.Version,
 			})
 		}
+		returnData.Relations = filterRelations(grantFromRequest(r), returnData.Relations)
 
 		respondOk(returnData, w)
-	})
+	}))
 
 	// Route: /v1/getRelationsFrom
-	ServeMux.HandleFunc("/v1/getRelationsFrom", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getRelationsFrom", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -765,7 +713,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -773,20 +721,18 @@ func Start() {
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
 		requiredUrlParams["id"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		id, err := strconv.Atoi(urlParams["id"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		id, apiErr := getIntUrlParam(urlParams, "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -803,22 +749,25 @@ func Start() {
 		}
 
 		// translate the type from string to id
-		typeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["type"])
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		typeID, err := storageFromRequest(r).Storage().GetTypeIdByString(urlParams["type"])
 		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
 		// retrieve the child entities if given
-		relations, err := dispatchStorage(r).Storage().GetChildRelationsBySourceTypeAndSourceId(typeID, id, context)
+		relations, err := storageFromRequest(r).Storage().GetChildRelationsBySourceTypeAndSourceId(typeID, id, context)
 		if nil != err {
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
 		// since we could have every possible type in our results we gonne go the easy way and retrieve all entity types for easier result translation
-		entityTypes := dispatchStorage(r).Storage().GetEntityTypes()
+		entityTypes := storageFromRequest(r).Storage().GetEntityTypes()
 
 		// prepare return data and write retrieved relations into the fitting format
 		returnData := transport.Transport{
@@ -829,19 +778,20 @@ func Start() {
 				SourceID:   val.SourceID,
 				SourceType: urlParams["type"],
 				TargetID:   val.TargetID,
-				TargetType: entityTypes[val.SourceType],
+				TargetType: entityTypes[val.TargetType],
 				Context:    val.Context,
 				Properties: val.Properties,
 				Version:    val.Version,
 			})
 		}
+		returnData.Relations = filterRelations(grantFromRequest(r), returnData.Relations)
 
 		respondOk(returnData, w)
-	})
+	}))
 
 	// Route: /v1/getRelation
-	ServeMux.HandleFunc("/v1/getRelation", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getRelation", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -850,7 +800,7 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
@@ -860,69 +810,49 @@ func Start() {
 		requiredUrlParams["srcID"] = ""
 		requiredUrlParams["targetType"] = ""
 		requiredUrlParams["targetID"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		srcID, err := strconv.Atoi(urlParams["srcID"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		srcID, apiErr := getIntUrlParam(urlParams, "srcID")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id
-		targetID, err := strconv.Atoi(urlParams["targetID"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		targetID, apiErr := getIntUrlParam(urlParams, "targetID")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// translate the type from string to id
-		srcTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["srcType"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if apiErr := authorize(r, auth.ActionRead, urlParams["srcType"], urlParams["targetType"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-		targetTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(urlParams["targetType"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		relation, apiErr := readRelation(storageFromRequest(r), urlParams["srcType"], srcID, urlParams["targetType"], targetID)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-
-		relation, err := dispatchStorage(r).Storage().GetRelation(srcTypeID, srcID, targetTypeID, targetID)
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		if respondNotModified(w, r, relation.Version) {
 			return
 		}
 
 		respondOk(transport.Transport{
-			Relations: []transport.TransportRelation{
-				{
-					SourceType: urlParams["srcType"],
-					SourceID:   relation.SourceID,
-					TargetType: urlParams["targetType"],
-					TargetID:   relation.TargetID,
-					Context:    relation.Context,
-					Properties: relation.Properties,
-					Version:    relation.Version,
-				},
-			},
+			Relations: []transport.TransportRelation{relation},
 		}, w)
-	})
+	}))
 
 	// Route: /v1/getEntitiesByValue
-	ServeMux.HandleFunc("/v1/getEntitiesByValue", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getEntitiesByValue", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -931,19 +861,18 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
 		// first we get the params
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["value"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
@@ -967,15 +896,21 @@ func Start() {
 			context = urlParams["context"]
 		}
 
+		listParams, apiErr := getListParams(r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+
 		// retrieve the entities
-		entities, err := dispatchStorage(r).Storage().GetEntitiesByValue(urlParams["value"], mode, context)
+		entities, err := storageFromRequest(r).Storage().GetEntitiesByValue(urlParams["value"], mode, context)
 		if nil != err {
-			http.Error(w, err.Error(), 422)
+			respondError(storageError(err), w)
 			return
 		}
 
 		// since we could have every possible type in our results we gonne go the easy way and retrieve all entity types for easier result translation
-		entityTypes := dispatchStorage(r).Storage().GetEntityTypes()
+		entityTypes := storageFromRequest(r).Storage().GetEntityTypes()
 
 		// write return data
 		returnData := transport.Transport{
@@ -993,12 +928,13 @@ func Start() {
 			})
 		}
 
-		respondOk(returnData, w)
-	})
+		// filter before paging, so the total only counts readable entities
+		respondEntities(filterTransport(grantFromRequest(r), returnData).Entities, listParams, w)
+	}))
 
 	// Route: /v1/getEntityTypes
-	ServeMux.HandleFunc("/v1/getEntityTypes", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/getEntityTypes", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -1007,27 +943,27 @@ func Start() {
 
 		// check http method
 		if "GET" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("GET"), w)
 			return
 		}
 
 		// retrieve all entity types
-		entityTypes := dispatchStorage(r).Storage().GetEntityTypes()
+		entityTypes := filterEntityTypes(grantFromRequest(r), storageFromRequest(r).Storage().GetEntityTypes())
 
 		// than we gonne json encode it
 		// build the json
 		responseData, err := json.Marshal(entityTypes)
 		if nil != err {
-			http.Error(w, "Error building response data json", 500)
+			respondError(internalError("Error building response data json"), w)
 			return
 		}
 		// finally we gonne send our response
 		respond(string(responseData), 200, w)
-	})
+	}))
 
 	// Route: /v1/updateRelation
-	ServeMux.HandleFunc("/v1/updateRelation", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/updateRelation", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -1036,14 +972,14 @@ func Start() {
 
 		// check http method
 		if "PUT" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("PUT"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
@@ -1051,45 +987,28 @@ func Start() {
 		var newRelation transport.TransportRelation
 		err = json.Unmarshal(body, &newRelation)
 		if nil != err {
-			http.Error(w, "Malformed json body.", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
-		// translate the type from string to id
-		srcTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newRelation.SourceType)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we update the relation
+		if apiErr := authorize(r, auth.ActionUpdate, newRelation.SourceType, newRelation.TargetType); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-		targetTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newRelation.TargetType)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
-			return
-		}
-
-		// finally we update the entity
-		_, err = dispatchStorage(r).Storage().UpdateRelation(srcTypeID, newRelation.SourceID, targetTypeID, newRelation.TargetID, types.StorageRelation{
-			SourceID:   newRelation.SourceID,
-			SourceType: srcTypeID,
-			TargetID:   newRelation.TargetID,
-			TargetType: targetTypeID,
-			Context:    newRelation.Context,
-			Properties: newRelation.Properties,
-			Version:    newRelation.Version,
-		})
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		relation, apiErr := updateRelationIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), newRelation)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
+		setEntityTag(w, relation.Version)
 
 		respond("", 200, w)
-	})
+	}))
 
 	// Route: /v1/createRelation
-	ServeMux.HandleFunc("/v1/createRelation", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/createRelation", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -1098,14 +1017,14 @@ func Start() {
 
 		// check http method
 		if "POST" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("POST"), w)
 			return
 		}
 
 		// retrieve data from request
 		body, err := getRequestBody(r)
 		if nil != err {
-			http.Error(w, "Malformed or no body. ", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
@@ -1113,40 +1032,27 @@ func Start() {
 		var newRelation transport.TransportRelation
 		err = json.Unmarshal(body, &newRelation)
 		if nil != err {
-			http.Error(w, "Malformed json body.", 422)
+			respondError(malformedBodyError(err), w)
 			return
 		}
 
-		// translate the type from string to id
-		srcTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newRelation.SourceType)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we create the relation
+		if apiErr := authorize(r, auth.ActionUpdate, newRelation.SourceType, newRelation.TargetType); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-		targetTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(newRelation.TargetType)
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		_, apiErr := createRelation(storageFromRequest(r), newRelation)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// finally we update the entity
-		_, err = dispatchStorage(r).Storage().CreateRelation(srcTypeID, newRelation.SourceID, targetTypeID, newRelation.TargetID, types.StorageRelation{
-			SourceID:   newRelation.SourceID,
-			SourceType: srcTypeID,
-			TargetID:   newRelation.TargetID,
-			TargetType: targetTypeID,
-			Context:    newRelation.Context,
-			Properties: newRelation.Properties,
-		})
-
 		respond("", 200, w)
-	})
+	}))
 
-	// Route: /v1/createRelation
-	ServeMux.HandleFunc("/v1/deleteRelation", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	// Route: /v1/deleteRelation
+	s.handleFunc("/v1/deleteRelation", s.requireScope(auth.ScopeWrite, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
@@ -1155,7 +1061,7 @@ func Start() {
 
 		// check http method
 		if "DELETE" != r.Method {
-			http.Error(w, "Invalid http method for this path", 422)
+			respondError(methodNotAllowedError("DELETE"), w)
 			return
 		}
 
@@ -1165,115 +1071,385 @@ func Start() {
 		requiredUrlParams["srcID"] = ""
 		requiredUrlParams["targetType"] = ""
 		requiredUrlParams["targetID"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 
-		if nil != err {
-			http.Error(w, err.Error(), 422)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id's
-		srcID, err := strconv.Atoi(urlParams["srcID"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		srcID, apiErr := getIntUrlParam(urlParams, "srcID")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
 		// int conv id's
-		targetID, err := strconv.Atoi(urlParams["targetID"])
-		if nil != err {
-			// handle error
-			http.Error(w, "Invalid param id given", 422)
+		targetID, apiErr := getIntUrlParam(urlParams, "targetID")
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// translate the type from string to id
-		srcTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(requiredUrlParams["srcType"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		// finally we delete the relation
+		if apiErr := authorize(r, auth.ActionUpdate, urlParams["srcType"], urlParams["targetType"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
-		targetTypeID, err := dispatchStorage(r).Storage().GetTypeIdByString(requiredUrlParams["targetType"])
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 422)
+		apiErr = deleteRelationIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), urlParams["srcType"], srcID, urlParams["targetType"], targetID)
+		if nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
 
-		// finally we delete the entity
-		dispatchStorage(r).Storage().DeleteRelation(srcTypeID, srcID, targetTypeID, targetID)
-
 		respond("", 200, w)
-	})
+	}))
+
+	// Route: /v1/patchEntity, /v1/patchRelation
+	s.registerPatchRoutes()
+
+	// Route: /v1/upsertEntity
+	s.registerUpsertRoutes()
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Graph traversal
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	s.registerTraverseRoutes()
+	s.registerPathRoutes()
 
 	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
 	// Stats
 	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
 	// Route: /v1/statistics/getEntityAmount
-	ServeMux.HandleFunc("/v1/statistics/getEntityAmount", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/statistics/getEntityAmount", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
 			}
 		}
 
+		// check http method
+		if "GET" != r.Method {
+			respondError(methodNotAllowedError("GET"), w)
+			return
+		}
+
 		// calling storage directly from API is very bad ### bad bad entity change this and move to mapper
-		amount := dispatchStorage(r).Storage().GetEntityAmount()
+		amount := storageFromRequest(r).Storage().GetEntityAmount()
+
+		// restricted callers only get the amount of the types they may read
+		if grant := grantFromRequest(r); !grant.Unrestricted(auth.ActionRead) {
+			amount = 0
+			for typeID := range filterEntityTypes(grant, storageFromRequest(r).Storage().GetEntityTypes()) {
+				typeAmount, _ := storageFromRequest(r).Storage().GetEntityAmountByType(typeID)
+				amount += typeAmount
+			}
+		}
 		respond(strconv.Itoa(amount), 200, w)
-	})
+	}))
 
 	// Route: /v1/statistics/getEntityAmountByType
-	ServeMux.HandleFunc("/v1/statistics/getEntityAmountByType", func(w http.ResponseWriter, r *http.Request) {
-		if "" != config.GetValue("CORS_ORIGIN") || "" != config.GetValue("CORS_HEADER") {
+	s.handleFunc("/v1/statistics/getEntityAmountByType", s.requireScope(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
 			if "OPTIONS" == r.Method {
 				respond("", 200, w)
 				return
 			}
 		}
 
+		// check http method
+		if "GET" != r.Method {
+			respondError(methodNotAllowedError("GET"), w)
+			return
+		}
+
 		// first we get the params
 		requiredUrlParams := make(map[string]string)
 		requiredUrlParams["type"] = ""
-		urlParams, err := getRequiredUrlParams(requiredUrlParams, r)
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
 		// required params check
-		if nil != err {
-			// handle error
-			http.Error(w, err.Error(), 404)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+			respondError(apiErr, w)
 			return
 		}
+
 		// calling storage directly from API is very bad ### bad bad entity change this and move to mapper
-		entityTypes := dispatchStorage(r).Storage().GetEntityRTypes()
+		entityTypes := storageFromRequest(r).Storage().GetEntityRTypes()
 		// we should have a way to compare instead of checking an index, this could have
 		// overflow/escap/bug chances
 		if _, ok := entityTypes[urlParams["type"]]; !ok {
-			respond("Unknown entity type given", 404, w)
+			respondError(newApiError(http.StatusNotFound, CodeEntityTypeNotFound, "Unknown entity type given", nil), w)
+			return
 		}
 
 		// calling storage directly from API is very bad ### bad bad entity change this and move to mapper
-		amount, _ := dispatchStorage(r).Storage().GetEntityAmountByType(entityTypes[urlParams["type"]])
+		amount, _ := storageFromRequest(r).Storage().GetEntityAmountByType(entityTypes[urlParams["type"]])
 		respond(strconv.Itoa(amount), 200, w)
-	})
+	}))
 
-	// building server listen string by
-	// config values and print it - than listen
-	connectString := buildListenConfigString()
-	archivist.Info("> Server listening settings by config (" + connectString + ")")
-	var err error = nil
-	if "https" == config.GetValue("PROTOCOL") {
-		err = http.ListenAndServeTLS(connectString, config.GetValue("SSL_CERT_FILE"), config.GetValue("SSL_KEY_FILE"), ServeMux)
-	} else if "http" == config.GetValue("PROTOCOL") {
-		err = http.ListenAndServe(connectString, ServeMux)
-	} else {
-		err = errors.New("Unsupported protocol '" + config.GetValue("PROTOCOL") + "'")
-	}
-	if nil != err {
-		archivist.Error(err.Error())
-		os.Exit(0)
-	}
+	// Route: /v1/statistics
+	s.registerStatisticsRoutes()
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Analytics
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	s.registerAnalyticsRoutes()
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Storage administration
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Route: /v1/admin/listStorages
+	s.handleFunc("/v1/admin/listStorages", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "GET" != r.Method {
+			respondError(methodNotAllowedError("GET"), w)
+			return
+		}
+
+		respondJson(s.storages.list(), 200, w)
+	}))
+
+	// Route: /v1/admin/getStorage
+	s.handleFunc("/v1/admin/getStorage", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "GET" != r.Method {
+			respondError(methodNotAllowedError("GET"), w)
+			return
+		}
+
+		// first we get the params
+		requiredUrlParams := make(map[string]string)
+		requiredUrlParams["name"] = ""
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+
+		g := s.storages.get(urlParams["name"])
+		if nil == g {
+			respondError(storageNotFoundError(urlParams["name"]), w)
+			return
+		}
+		respondJson(storageInfo(g), 200, w)
+	}))
+
+	// Route: /v1/admin/createStorage
+	s.handleFunc("/v1/admin/createStorage", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "POST" != r.Method {
+			respondError(methodNotAllowedError("POST"), w)
+			return
+		}
+
+		// first we get the params
+		requiredUrlParams := make(map[string]string)
+		requiredUrlParams["name"] = ""
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+
+		g, apiErr := s.storages.create(urlParams["name"])
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		archivist.Info("> Created storage", g.Name)
+		respondJson(storageInfo(g), http.StatusCreated, w)
+	}))
+
+	// Route: /v1/admin/setDefaultStorage
+	s.handleFunc("/v1/admin/setDefaultStorage", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
 
+		// check http method
+		if "PUT" != r.Method {
+			respondError(methodNotAllowedError("PUT"), w)
+			return
+		}
+
+		// first we get the params
+		requiredUrlParams := make(map[string]string)
+		requiredUrlParams["name"] = ""
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+
+		if apiErr := s.storages.setDefault(urlParams["name"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		respond("", 200, w)
+	}))
+
+	// Route: /v1/admin/dropStorage
+	s.handleFunc("/v1/admin/dropStorage", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "DELETE" != r.Method {
+			respondError(methodNotAllowedError("DELETE"), w)
+			return
+		}
+
+		// first we get the params
+		requiredUrlParams := make(map[string]string)
+		requiredUrlParams["name"] = ""
+		urlParams, apiErr := getRequiredUrlParams(requiredUrlParams, r)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+
+		if apiErr := s.storages.drop(urlParams["name"]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		if nil != s.snapshots {
+			s.snapshots.remove(urlParams["name"])
+		}
+		s.storages.journals.remove(urlParams["name"])
+		archivist.Info("> Dropped storage", urlParams["name"])
+		respond("", 200, w)
+	}))
+
+	// Route: /v1/admin/snapshot
+	s.handleFunc("/v1/admin/snapshot", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "POST" != r.Method {
+			respondError(methodNotAllowedError("POST"), w)
+			return
+		}
+
+		if nil == s.snapshots {
+			respondError(snapshotsDisabledError(), w)
+			return
+		}
+
+		// without a name all storages are written
+		results := []SnapshotResult{}
+		if name := r.URL.Query().Get("name"); "" != name {
+			result, apiErr := s.snapshots.snapshot(name)
+			if nil != apiErr {
+				respondError(apiErr, w)
+				return
+			}
+			results = append(results, result)
+		} else {
+			var apiErr *apiError
+			if results, apiErr = s.snapshots.snapshotAll(); nil != apiErr {
+				respondError(apiErr, w)
+				return
+			}
+		}
+		respondJson(results, 200, w)
+	}))
+
+	// Route: /v1/admin/restore
+	s.handleFunc("/v1/admin/restore", s.requireAdmin(func(w http.ResponseWriter, r *http.Request) {
+		if "" != s.config.GetValue("CORS_ORIGIN") || "" != s.config.GetValue("CORS_HEADER") {
+			if "OPTIONS" == r.Method {
+				respond("", 200, w)
+				return
+			}
+		}
+
+		// check http method
+		if "POST" != r.Method {
+			respondError(methodNotAllowedError("POST"), w)
+			return
+		}
+
+		if nil == s.snapshots {
+			respondError(snapshotsDisabledError(), w)
+			return
+		}
+
+		// without a name all snapshots in the dir are restored
+		results := []SnapshotResult{}
+		if name := r.URL.Query().Get("name"); "" != name {
+			result, apiErr := s.snapshots.restore(name)
+			if nil != apiErr {
+				respondError(apiErr, w)
+				return
+			}
+			results = append(results, result)
+		} else {
+			var apiErr *apiError
+			if results, apiErr = s.snapshots.restoreAll(); nil != apiErr {
+				respondError(apiErr, w)
+				return
+			}
+		}
+		for _, result := range results {
+			archivist.Info("> Restored storage '"+result.Storage+"' from snapshot of", result.CreatedAt.String())
+		}
+		respondJson(results, 200, w)
+	}))
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Bulk import and export
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	s.registerImportRoutes()
+	s.registerExportRoutes()
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Batch operations
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	s.registerBatchRoutes()
+
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	// Resource oriented /v2 routes
+	// -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -  -
+	s.registerV2Routes()
 }
 
 func getOptionalUrlParams(optionalUrlParams map[string]string, urlParams map[string]string, r *http.Request) map[string]string {
@@ -1287,29 +1463,51 @@ func getOptionalUrlParams(optionalUrlParams map[string]string, urlParams map[str
 	return urlParams
 }
 
-func getRequiredUrlParams(requiredUrlParams map[string]string, r *http.Request) (map[string]string, error) {
+func getRequiredUrlParams(requiredUrlParams map[string]string, r *http.Request) (map[string]string, *apiError) {
 	urlParams := r.URL.Query()
 	for paramName := range requiredUrlParams {
 		val, ok := urlParams[paramName]
 		if !ok {
-			return nil, errors.New("Missing required url param")
+			return nil, newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing required url param '"+paramName+"'", map[string]string{"param": paramName})
 		}
 		requiredUrlParams[paramName] = val[0]
 	}
 	return requiredUrlParams, nil
 }
 
-func respond(message string, responseCode int, w http.ResponseWriter) {
+func getIntUrlParam(urlParams map[string]string, paramName string) (int, *apiError) {
+	val, err := strconv.Atoi(urlParams[paramName])
+	if nil != err {
+		return 0, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param '"+paramName+"' given, integer expected", map[string]string{"param": paramName})
+	}
+	return val, nil
+}
 
-	corsAllowHeaders := config.GetValue("CORS_HEADER")
+func (s *Server) addCorsHeaders(w http.ResponseWriter) {
+	corsAllowHeaders := s.config.GetValue("CORS_HEADER")
 	if "" != corsAllowHeaders {
-		w.Header().Add("Access-Control-Allow-Headers", corsAllowHeaders)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
 	}
-	corsAllowOrigin := config.GetValue("CORS_ORIGIN")
+	corsAllowOrigin := s.config.GetValue("CORS_ORIGIN")
 	if "" != corsAllowOrigin {
-		w.Header().Add("Access-Control-Allow-Origin", corsAllowOrigin)
+		w.Header().Set("Access-Control-Allow-Origin", corsAllowOrigin)
+	}
+}
+
+// handlePreflight answers CORS preflight requests if CORS is configured,
+// the return value tells if the request has been handled
+func handlePreflight(w http.ResponseWriter, r *http.Request) bool {
+	conf := serverFromRequest(r).config
+	if "" != conf.GetValue("CORS_ORIGIN") || "" != conf.GetValue("CORS_HEADER") {
+		if "OPTIONS" == r.Method {
+			respond("", 200, w)
+			return true
+		}
 	}
+	return false
+}
 
+func respond(message string, responseCode int, w http.ResponseWriter) {
 	w.WriteHeader(responseCode)
 	messageBytes := []byte(message)
 
@@ -1320,18 +1518,27 @@ func respond(message string, responseCode int, w http.ResponseWriter) {
 }
 
 func respondOk(data transport.Transport, w http.ResponseWriter) {
+	respondTransport(data, 200, w)
+}
+
+func respondTransport(data transport.Transport, responseCode int, w http.ResponseWriter) {
+	respondJson(data, responseCode, w)
+}
+
+func respondJson(data interface{}, responseCode int, w http.ResponseWriter) {
 	// than we gonne json encode it
 	// build the json
 	responseData, err := json.Marshal(data)
 	if nil != err {
-		http.Error(w, "Error building response data json", 500)
+		respondError(internalError("Error building response data json"), w)
 		return
 	}
 
 	// finally we gonne send our response
-	w.Header().Add("Access-Control-Allow-Headers", "*")
-	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.WriteHeader(200)
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(responseCode)
 	_, err = w.Write(responseData)
 	if nil != err {
 		archivist.Error("Could not write http response body ", err, data)
@@ -1347,20 +1554,10 @@ func getRequestBody(r *http.Request) ([]byte, error) {
 	return body, nil
 }
 
-func buildListenConfigString() string {
+func (s *Server) buildListenConfigString() string {
 	var connectString string
-	connectString += config.GetValue("HOST")
+	connectString += s.config.GetValue("HOST")
 	connectString += ":"
-	connectString += config.GetValue("PORT")
+	connectString += s.config.GetValue("PORT")
 	return connectString
 }
-
-func dispatchStorage(r *http.Request) *gits.Gits {
-	// if there is a storage header we gonne choose this storage
-	storageValue := r.Header.Get("Storage")
-	if storageValue != "" {
-		return gits.GetByName(storageValue)
-	}
-	// else we gonne go for the default connection
-	return gits.GetDefault()
-}
@@ -0,0 +1,381 @@
+package gitsapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// graphFormat is an output format for graph visualization tools or RDF,
+// it is selected by the Accept header or the format url param. Formats
+// with a stream func can be written record by record, the others are
+// rendered from the complete graph.
+type graphFormat struct {
+	Name      string
+	MediaType string
+	render    func(w io.Writer, graph *graphDocument) error
+	stream    func(w io.Writer, base rdfVocabulary) exportWriter
+}
+
+var graphFormats = []graphFormat{
+	{Name: "graphml", MediaType: "application/graphml+xml", render: renderGraphML},
+	{Name: "gexf", MediaType: "application/gexf+xml", render: renderGEXF},
+	{Name: "dot", MediaType: "text/vnd.graphviz", render: renderDOT},
+	{Name: "turtle", MediaType: "text/turtle", stream: func(w io.Writer, base rdfVocabulary) exportWriter {
+		return newRDFExportWriter(w, base, newTurtleSerializer)
+	}},
+	{Name: "ntriples", MediaType: "application/n-triples", stream: func(w io.Writer, base rdfVocabulary) exportWriter {
+		return newRDFExportWriter(w, base, newNTriplesSerializer)
+	}},
+	{Name: "jsonld", MediaType: "application/ld+json", stream: func(w io.Writer, base rdfVocabulary) exportWriter {
+		return newRDFExportWriter(w, base, newJSONLDSerializer)
+	}},
+}
+
+// negotiateGraphFormat returns the requested graph format, nil means
+// the route answers in its default format. Unknown media types are
+// ignored instead of answering 406, so generic Accept headers keep
+// working.
+func negotiateGraphFormat(r *http.Request) *graphFormat {
+	if name := r.URL.Query().Get("format"); "" != name {
+		for i := range graphFormats {
+			if strings.EqualFold(graphFormats[i].Name, name) {
+				return &graphFormats[i]
+			}
+		}
+		return nil
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if nil != err || "0" == params["q"] {
+			continue
+		}
+		for i := range graphFormats {
+			if graphFormats[i].MediaType == mediaType {
+				return &graphFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// graphDocument collects the nodes and edges of a graph. All formats
+// declare their attributes upfront, so unlike NDJSON the graph has to
+// be complete before it can be written.
+type graphDocument struct {
+	nodes     []transport.TransportEntity
+	edges     []transport.TransportRelation
+	nodeIndex map[string]bool
+	edgeIndex map[string]bool
+}
+
+func newGraphDocument() *graphDocument {
+	return &graphDocument{
+		nodeIndex: make(map[string]bool),
+		edgeIndex: make(map[string]bool),
+	}
+}
+
+func graphNodeID(typeStr string, id int) string {
+	return typeStr + ":" + strconv.Itoa(id)
+}
+
+func (doc *graphDocument) addNode(entity transport.TransportEntity) {
+	key := graphNodeID(entity.Type, entity.ID)
+	if doc.nodeIndex[key] {
+		return
+	}
+	doc.nodeIndex[key] = true
+	entity.ChildRelations = nil
+	entity.ParentRelations = nil
+	doc.nodes = append(doc.nodes, entity)
+}
+
+func (doc *graphDocument) addEdge(relation transport.TransportRelation) {
+	key := graphNodeID(relation.SourceType, relation.SourceID) + ">" + graphNodeID(relation.TargetType, relation.TargetID)
+	if doc.edgeIndex[key] {
+		return
+	}
+	doc.edgeIndex[key] = true
+	relation.Target = transport.TransportEntity{}
+	doc.edges = append(doc.edges, relation)
+}
+
+// addTransport flattens a query result, nested child and parent
+// relations become edges between the entities
+func (doc *graphDocument) addTransport(data transport.Transport) {
+	for _, entity := range data.Entities {
+		doc.addEntityTree(entity)
+	}
+	for _, relation := range data.Relations {
+		doc.addEdge(relation)
+	}
+}
+
+func (doc *graphDocument) addEntityTree(entity transport.TransportEntity) {
+	doc.addNode(entity)
+	for _, relation := range entity.ChildRelations {
+		relation.SourceType, relation.SourceID = entity.Type, entity.ID
+		relation.TargetType, relation.TargetID = relation.Target.Type, relation.Target.ID
+		doc.addEntityTree(relation.Target)
+		doc.addEdge(relation)
+	}
+	for _, relation := range entity.ParentRelations {
+		relation.SourceType, relation.SourceID = relation.Target.Type, relation.Target.ID
+		relation.TargetType, relation.TargetID = entity.Type, entity.ID
+		doc.addEntityTree(relation.Target)
+		doc.addEdge(relation)
+	}
+}
+
+// graphDocument is an exportWriter, so whole storages can be exported
+func (doc *graphDocument) EntityType(name string) error {
+	return nil
+}
+
+func (doc *graphDocument) Entity(entity transport.TransportEntity) error {
+	doc.addNode(entity)
+	return nil
+}
+
+func (doc *graphDocument) Relation(relation transport.TransportRelation) error {
+	doc.addEdge(relation)
+	return nil
+}
+
+func (doc *graphDocument) Close() error {
+	return nil
+}
+
+// writeTo passes the collected graph to an exportWriter
+func (doc *graphDocument) writeTo(out exportWriter) error {
+	for _, node := range doc.nodes {
+		if err := out.Entity(node); nil != err {
+			return err
+		}
+	}
+	for _, edge := range doc.edges {
+		if err := out.Relation(edge); nil != err {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// propertyKeys returns the sorted property keys of all nodes and edges
+func (doc *graphDocument) propertyKeys() ([]string, []string) {
+	nodeKeys := make(map[string]bool)
+	for _, node := range doc.nodes {
+		for key := range node.Properties {
+			nodeKeys[key] = true
+		}
+	}
+	edgeKeys := make(map[string]bool)
+	for _, edge := range doc.edges {
+		for key := range edge.Properties {
+			edgeKeys[key] = true
+		}
+	}
+	return sortedKeys(nodeKeys), sortedKeys(edgeKeys)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	ret := make([]string, 0, len(set))
+	for key := range set {
+		ret = append(ret, key)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func respondGraph(format *graphFormat, doc *graphDocument, base rdfVocabulary, w http.ResponseWriter) {
+	var buffer bytes.Buffer
+	render := format.render
+	if nil == render {
+		render = func(w io.Writer, doc *graphDocument) error {
+			return doc.writeTo(format.stream(w, base))
+		}
+	}
+	if err := render(&buffer, doc); nil != err {
+		respondError(internalError("Error building "+format.Name+" response"), w)
+		return
+	}
+	w.Header().Set("Content-Type", format.MediaType+"; charset=utf-8")
+	w.WriteHeader(200)
+	w.Write(buffer.Bytes())
+}
+
+// xmlEscape escapes text and attribute values
+func xmlEscape(value string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(value))
+	return buffer.String()
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// GraphML, understood by yEd, Gephi and most others
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func renderGraphML(w io.Writer, doc *graphDocument) error {
+	out := bufio.NewWriter(w)
+	nodeKeys, edgeKeys := doc.propertyKeys()
+
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	out.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, field := range []string{"Type", "Value", "Context"} {
+		out.WriteString(`  <key id="n` + field + `" for="node" attr.name="` + field + `" attr.type="string"/>` + "\n")
+	}
+	for i, key := range nodeKeys {
+		out.WriteString(`  <key id="np` + strconv.Itoa(i) + `" for="node" attr.name="` + xmlEscape(key) + `" attr.type="string"/>` + "\n")
+	}
+	out.WriteString(`  <key id="eContext" for="edge" attr.name="Context" attr.type="string"/>` + "\n")
+	for i, key := range edgeKeys {
+		out.WriteString(`  <key id="ep` + strconv.Itoa(i) + `" for="edge" attr.name="` + xmlEscape(key) + `" attr.type="string"/>` + "\n")
+	}
+
+	out.WriteString(`  <graph id="gits" edgedefault="directed">` + "\n")
+	for _, node := range doc.nodes {
+		out.WriteString(`    <node id="` + xmlEscape(graphNodeID(node.Type, node.ID)) + `">` + "\n")
+		out.WriteString(`      <data key="nType">` + xmlEscape(node.Type) + "</data>\n")
+		out.WriteString(`      <data key="nValue">` + xmlEscape(node.Value) + "</data>\n")
+		out.WriteString(`      <data key="nContext">` + xmlEscape(node.Context) + "</data>\n")
+		for i, key := range nodeKeys {
+			if value, ok := node.Properties[key]; ok {
+				out.WriteString(`      <data key="np` + strconv.Itoa(i) + `">` + xmlEscape(value) + "</data>\n")
+			}
+		}
+		out.WriteString("    </node>\n")
+	}
+	for i, edge := range doc.edges {
+		out.WriteString(`    <edge id="e` + strconv.Itoa(i) + `" source="` + xmlEscape(graphNodeID(edge.SourceType, edge.SourceID)) + `" target="` + xmlEscape(graphNodeID(edge.TargetType, edge.TargetID)) + `">` + "\n")
+		out.WriteString(`      <data key="eContext">` + xmlEscape(edge.Context) + "</data>\n")
+		for j, key := range edgeKeys {
+			if value, ok := edge.Properties[key]; ok {
+				out.WriteString(`      <data key="ep` + strconv.Itoa(j) + `">` + xmlEscape(value) + "</data>\n")
+			}
+		}
+		out.WriteString("    </edge>\n")
+	}
+	out.WriteString("  </graph>\n</graphml>\n")
+	return out.Flush()
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// GEXF, the native format of Gephi
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func renderGEXF(w io.Writer, doc *graphDocument) error {
+	out := bufio.NewWriter(w)
+	nodeKeys, edgeKeys := doc.propertyKeys()
+
+	out.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	out.WriteString(`<gexf xmlns="http://gexf.net/1.3" version="1.3">` + "\n")
+	out.WriteString(`  <graph mode="static" defaultedgetype="directed">` + "\n")
+	out.WriteString(`    <attributes class="node">` + "\n")
+	for _, field := range []string{"Type", "Value", "Context"} {
+		out.WriteString(`      <attribute id="n` + field + `" title="` + field + `" type="string"/>` + "\n")
+	}
+	for i, key := range nodeKeys {
+		out.WriteString(`      <attribute id="np` + strconv.Itoa(i) + `" title="` + xmlEscape(key) + `" type="string"/>` + "\n")
+	}
+	out.WriteString("    </attributes>\n")
+	out.WriteString(`    <attributes class="edge">` + "\n")
+	out.WriteString(`      <attribute id="eContext" title="Context" type="string"/>` + "\n")
+	for i, key := range edgeKeys {
+		out.WriteString(`      <attribute id="ep` + strconv.Itoa(i) + `" title="` + xmlEscape(key) + `" type="string"/>` + "\n")
+	}
+	out.WriteString("    </attributes>\n")
+
+	out.WriteString("    <nodes>\n")
+	for _, node := range doc.nodes {
+		out.WriteString(`      <node id="` + xmlEscape(graphNodeID(node.Type, node.ID)) + `" label="` + xmlEscape(graphNodeLabel(node)) + `">` + "\n")
+		out.WriteString("        <attvalues>\n")
+		out.WriteString(`          <attvalue for="nType" value="` + xmlEscape(node.Type) + `"/>` + "\n")
+		out.WriteString(`          <attvalue for="nValue" value="` + xmlEscape(node.Value) + `"/>` + "\n")
+		out.WriteString(`          <attvalue for="nContext" value="` + xmlEscape(node.Context) + `"/>` + "\n")
+		for i, key := range nodeKeys {
+			if value, ok := node.Properties[key]; ok {
+				out.WriteString(`          <attvalue for="np` + strconv.Itoa(i) + `" value="` + xmlEscape(value) + `"/>` + "\n")
+			}
+		}
+		out.WriteString("        </attvalues>\n      </node>\n")
+	}
+	out.WriteString("    </nodes>\n")
+
+	out.WriteString("    <edges>\n")
+	for i, edge := range doc.edges {
+		out.WriteString(`      <edge id="` + strconv.Itoa(i) + `" source="` + xmlEscape(graphNodeID(edge.SourceType, edge.SourceID)) + `" target="` + xmlEscape(graphNodeID(edge.TargetType, edge.TargetID)) + `" label="` + xmlEscape(edge.Context) + `">` + "\n")
+		out.WriteString("        <attvalues>\n")
+		out.WriteString(`          <attvalue for="eContext" value="` + xmlEscape(edge.Context) + `"/>` + "\n")
+		for j, key := range edgeKeys {
+			if value, ok := edge.Properties[key]; ok {
+				out.WriteString(`          <attvalue for="ep` + strconv.Itoa(j) + `" value="` + xmlEscape(value) + `"/>` + "\n")
+			}
+		}
+		out.WriteString("        </attvalues>\n      </edge>\n")
+	}
+	out.WriteString("    </edges>\n")
+	out.WriteString("  </graph>\n</gexf>\n")
+	return out.Flush()
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Graphviz DOT
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func renderDOT(w io.Writer, doc *graphDocument) error {
+	out := bufio.NewWriter(w)
+	out.WriteString("digraph gits {\n")
+	for _, node := range doc.nodes {
+		out.WriteString("  " + dotQuote(graphNodeID(node.Type, node.ID)) + " [")
+		// properties first, so they can't overwrite the fields
+		out.WriteString(dotAttributes(node.Properties))
+		out.WriteString("Type=" + dotQuote(node.Type) + ", Value=" + dotQuote(node.Value) + ", Context=" + dotQuote(node.Context))
+		out.WriteString(", label=" + dotQuote(graphNodeLabel(node)) + "];\n")
+	}
+	for _, edge := range doc.edges {
+		out.WriteString("  " + dotQuote(graphNodeID(edge.SourceType, edge.SourceID)) + " -> " + dotQuote(graphNodeID(edge.TargetType, edge.TargetID)) + " [")
+		out.WriteString(dotAttributes(edge.Properties))
+		out.WriteString("Context=" + dotQuote(edge.Context) + ", label=" + dotQuote(edge.Context) + "];\n")
+	}
+	out.WriteString("}\n")
+	return out.Flush()
+}
+
+func dotAttributes(properties map[string]string) string {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var ret strings.Builder
+	for _, key := range keys {
+		ret.WriteString(dotQuote(key) + "=" + dotQuote(properties[key]) + ", ")
+	}
+	return ret.String()
+}
+
+func dotQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + value + `"`
+}
+
+// graphNodeLabel is the value of the entity, or its address if the
+// value is empty
+func graphNodeLabel(node transport.TransportEntity) string {
+	if "" != node.Value {
+		return node.Value
+	}
+	return graphNodeID(node.Type, node.ID)
+}
@@ -0,0 +1,277 @@
+package gitsapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// ImportResult is the outcome of a single import line. Entities report
+// their temporary and their created ID, relations the resolved IDs. RDF
+// imports report the IRI of the subject instead of a temporary ID.
+type ImportResult struct {
+	Line       int
+	IRI        string    `json:",omitempty"`
+	Type       string    `json:",omitempty"`
+	TempID     int       `json:",omitempty"`
+	ID         int       `json:",omitempty"`
+	Existing   bool      `json:",omitempty"`
+	SourceType string    `json:",omitempty"`
+	SourceID   int       `json:",omitempty"`
+	TargetType string    `json:",omitempty"`
+	TargetID   int       `json:",omitempty"`
+	Error      *apiError `json:",omitempty"`
+}
+
+// ImportReport is the response of an import, empty lines are skipped
+// and don't show up in the results
+type ImportReport struct {
+	Created int
+	Failed  int
+	Results []ImportResult
+}
+
+// tempIDKey addresses a temporary ID, they are unique per entity type
+type tempIDKey struct {
+	Type string
+	ID   int
+}
+
+// importer creates entities and relations of an import and keeps the
+// mapping of temporary to created IDs
+type importer struct {
+	r       *http.Request
+	tempIDs map[tempIDKey]int
+	report  ImportReport
+	// the upsert strategy, entities are always created without
+	upsert string
+}
+
+func newImporter(r *http.Request) *importer {
+	return &importer{
+		r:       r,
+		tempIDs: make(map[tempIDKey]int),
+		report:  ImportReport{Results: []ImportResult{}},
+	}
+}
+
+func (s *Server) registerImportRoutes() {
+	// Route: /v1/import
+	s.handleFunc("/v1/import", s.requireScope(auth.ScopeWrite, handleImport))
+
+	// Route: /v1/import/rdf
+	s.handleFunc("/v1/import/rdf", s.requireScope(auth.ScopeWrite, handleImportRDF))
+
+	// Route: /v1/import/csv
+	s.handleFunc("/v1/import/csv", s.requireScope(auth.ScopeWrite, handleImportCSV))
+}
+
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "POST" != r.Method {
+		respondError(methodNotAllowedError("POST"), w)
+		return
+	}
+	defer r.Body.Close()
+
+	// the body is processed line by line, it is never held in memory
+	imp := newImporter(r)
+	urlParams := getOptionalUrlParams(map[string]string{"upsert": ""}, make(map[string]string), r)
+	if upsert, ok := urlParams["upsert"]; ok {
+		if UpsertReplace != upsert && UpsertMerge != upsert && UpsertKeep != upsert {
+			respondError(newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown upsert strategy '"+upsert+"', expected replace, merge or keep", map[string]string{"param": "upsert"}), w)
+			return
+		}
+		imp.upsert = upsert
+	}
+	reader := bufio.NewReader(r.Body)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if 0 < len(bytes.TrimSpace(line)) {
+			imp.importLine(lineNumber, line)
+		}
+		if io.EOF == err {
+			break
+		}
+		if nil != err {
+			respondError(malformedBodyError(err), w)
+			return
+		}
+	}
+
+	archivist.Info("> Imported records, created/failed:", imp.report.Created, imp.report.Failed)
+	respondJson(imp.report, 200, w)
+}
+
+// importLine decides by the EntityType and SourceType fields if the
+// line holds an entity type, an entity or a relation
+func (imp *importer) importLine(lineNumber int, line []byte) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); nil != err {
+		imp.fail(ImportResult{Line: lineNumber}, malformedBodyError(err))
+		return
+	}
+
+	if _, ok := fields["EntityType"]; ok {
+		var entityType ExportEntityType
+		if err := json.Unmarshal(line, &entityType); nil != err {
+			imp.fail(ImportResult{Line: lineNumber}, malformedBodyError(err))
+			return
+		}
+		imp.importEntityType(lineNumber, entityType.EntityType)
+		return
+	}
+
+	if _, ok := fields["SourceType"]; ok {
+		var relation transport.TransportRelation
+		if err := json.Unmarshal(line, &relation); nil != err {
+			imp.fail(ImportResult{Line: lineNumber}, malformedBodyError(err))
+			return
+		}
+		imp.importRelation(lineNumber, relation)
+		return
+	}
+
+	var entity transport.TransportEntity
+	if err := json.Unmarshal(line, &entity); nil != err {
+		imp.fail(ImportResult{Line: lineNumber}, malformedBodyError(err))
+		return
+	}
+	imp.importEntity(lineNumber, entity)
+}
+
+// importEntityType creates the type, existing types are fine
+func (imp *importer) importEntityType(lineNumber int, typeStr string) {
+	result := ImportResult{Line: lineNumber, Type: typeStr}
+	if apiErr := authorize(imp.r, auth.ActionCreate, typeStr); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	if apiErr := createEntityType(storageFromRequest(imp.r), typeStr); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	imp.succeed(result)
+}
+
+// importEntity creates the entity, a negative ID is a temporary ID
+// later relation lines can reference the entity by
+func (imp *importer) importEntity(lineNumber int, entity transport.TransportEntity) {
+	result := ImportResult{Line: lineNumber, Type: entity.Type}
+	if 0 < entity.ID {
+		imp.fail(result, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Imported entities are always created, use a negative temporary ID or none", map[string]string{"field": "ID"}))
+		return
+	}
+	if 0 < len(entity.ChildRelations) || 0 < len(entity.ParentRelations) {
+		imp.fail(result, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Nested relations are not imported, use relation lines or mapJson", map[string]string{"field": "ChildRelations"}))
+		return
+	}
+
+	key := tempIDKey{Type: entity.Type, ID: entity.ID}
+	if 0 > entity.ID {
+		result.TempID = entity.ID
+		if _, ok := imp.tempIDs[key]; ok {
+			imp.fail(result, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Temporary ID "+strconv.Itoa(entity.ID)+" is already used for type '"+entity.Type+"'", map[string]string{"field": "ID"}))
+			return
+		}
+	}
+
+	if apiErr := authorize(imp.r, auth.ActionCreate, entity.Type); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	created, apiErr := imp.createEntity(entity, &result)
+	if nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+
+	if 0 > entity.ID {
+		imp.tempIDs[key] = created.ID
+	}
+	result.ID = created.ID
+	imp.succeed(result)
+}
+
+// createEntity creates the entity, with an upsert strategy an existing
+// entity of the same type, value and context is used instead
+func (imp *importer) createEntity(entity transport.TransportEntity, result *ImportResult) (transport.TransportEntity, *apiError) {
+	if "" == imp.upsert {
+		return createEntity(storageFromRequest(imp.r), entity)
+	}
+	if apiErr := authorize(imp.r, auth.ActionUpdate, entity.Type); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	upserted, apiErr := upsertEntity(storageFromRequest(imp.r), UpsertRequest{
+		Type:       entity.Type,
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Properties: entity.Properties,
+		Strategy:   imp.upsert,
+	})
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	result.Existing = !upserted.Created
+	return upserted.Entity, nil
+}
+
+// importRelation creates the relation, negative source and target
+// IDs are resolved by the temporary IDs of earlier lines
+func (imp *importer) importRelation(lineNumber int, relation transport.TransportRelation) {
+	result := ImportResult{Line: lineNumber, SourceType: relation.SourceType, TargetType: relation.TargetType}
+
+	var apiErr *apiError
+	if relation.SourceID, apiErr = imp.resolve(relation.SourceType, relation.SourceID, "SourceID"); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	if relation.TargetID, apiErr = imp.resolve(relation.TargetType, relation.TargetID, "TargetID"); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	result.SourceID = relation.SourceID
+	result.TargetID = relation.TargetID
+
+	if apiErr = authorize(imp.r, auth.ActionUpdate, relation.SourceType, relation.TargetType); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	if _, apiErr = createRelation(storageFromRequest(imp.r), relation); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	imp.succeed(result)
+}
+
+func (imp *importer) resolve(typeStr string, id int, field string) (int, *apiError) {
+	if 0 < id {
+		return id, nil
+	}
+	if created, ok := imp.tempIDs[tempIDKey{Type: typeStr, ID: id}]; ok {
+		return created, nil
+	}
+	return 0, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown temporary ID "+strconv.Itoa(id)+" for type '"+typeStr+"'", map[string]string{"field": field})
+}
+
+func (imp *importer) succeed(result ImportResult) {
+	imp.report.Created++
+	imp.report.Results = append(imp.report.Results, result)
+}
+
+func (imp *importer) fail(result ImportResult, apiErr *apiError) {
+	result.Error = apiErr
+	imp.report.Failed++
+	imp.report.Results = append(imp.report.Results, result)
+}
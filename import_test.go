@@ -0,0 +1,69 @@
+package gitsapi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true"})
+	storage := map[string]string{"Storage": "import-" + t.Name()}
+
+	// bob is created first, so the temporary IDs don't match the created ones
+	body := strings.Join([]string{
+		`{"EntityType":"Person"}`,
+		`{"Type":"Person","ID":-2,"Value":"bob"}`,
+		`{"Type":"Person","ID":-1,"Value":"alice"}`,
+		`no json`,
+		`{"SourceType":"Person","SourceID":-1,"TargetType":"Person","TargetID":-2}`,
+		`{"SourceType":"Person","SourceID":-3,"TargetType":"Person","TargetID":-2}`,
+		``,
+		`{"Type":"Person","ID":-1,"Value":"alice again"}`,
+		`{"Type":"Person","ID":5,"Value":"fixed"}`,
+		`{"Type":"Person","Value":"carol"}`,
+	}, "\n")
+
+	var report ImportReport
+	resp := call(t, ts, "POST", "/v1/import", storage, body, &report)
+	if 200 != resp.StatusCode {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if 5 != report.Created || 4 != report.Failed {
+		t.Errorf("expected 5 created and 4 failed, got %d and %d", report.Created, report.Failed)
+	}
+
+	// the empty line 7 has no result
+	want := []struct {
+		line   int
+		failed bool
+		id     int
+	}{
+		{1, false, 0}, {2, false, 1}, {3, false, 2}, {4, true, 0}, {5, false, 0}, {6, true, 0}, {8, true, 0}, {9, true, 0}, {10, false, 3},
+	}
+	if len(want) != len(report.Results) {
+		t.Fatalf("expected %d results, got %+v", len(want), report.Results)
+	}
+	for i, result := range report.Results {
+		if want[i].line != result.Line || want[i].failed != (nil != result.Error) || want[i].id != result.ID {
+			t.Errorf("result %d: expected line %d failed %v id %d, got %+v", i, want[i].line, want[i].failed, want[i].id, result)
+		}
+	}
+
+	// the relation of line 5 resolved the temporary IDs to the created ones
+	relation := report.Results[4]
+	if 2 != relation.SourceID || 1 != relation.TargetID {
+		t.Errorf("expected the relation from 2 to 1, got %+v", relation)
+	}
+	resp = call(t, ts, "GET", "/v1/getRelation?srcType=Person&srcID=2&targetType=Person&targetID=1", storage, "", nil)
+	if 200 != resp.StatusCode {
+		t.Errorf("expected the relation to exist, got status %d", resp.StatusCode)
+	}
+}
+
+func TestImportUnknownUpsertStrategy(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true"})
+	resp := call(t, ts, "POST", "/v1/import?upsert=overwrite", map[string]string{"Storage": "import-upsert"}, `{"Type":"Person","Value":"bob"}`, nil)
+	if 400 != resp.StatusCode {
+		t.Errorf("expected status 400, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,299 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/query"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/journal"
+)
+
+// journaled operations, the record data is the input of the operation
+const (
+	journalOpCreateEntityType = "createEntityType"
+	journalOpCreateEntity     = "createEntity"
+	journalOpUpdateEntity     = "updateEntity"
+	journalOpDeleteEntity     = "deleteEntity"
+	journalOpCreateRelation   = "createRelation"
+	journalOpUpdateRelation   = "updateRelation"
+	journalOpDeleteRelation   = "deleteRelation"
+	journalOpMapJson          = "mapJson"
+	journalOpQuery            = "query"
+	// the data is the list of operations a committed batch applied
+	journalOpTransaction = "transaction"
+)
+
+// journalSet holds the open journals of the storages of a server
+type journalSet struct {
+	dir      string
+	policy   string
+	interval time.Duration
+	mutex    *sync.Mutex
+	open     map[string]*journal.Journal
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// loadJournal replays the journals found in JOURNAL_DIR on top of the
+// restored snapshots and enables journaling of all mutations
+func (s *Server) loadJournal(restored []SnapshotResult) error {
+	dir := s.config.GetOptionalValue("JOURNAL_DIR", "")
+	if "" == dir {
+		return nil
+	}
+
+	policy := s.config.GetOptionalValue("JOURNAL_FSYNC", journal.SyncAlways)
+	if !journal.ValidPolicy(policy) {
+		return errors.New("Invalid JOURNAL_FSYNC '" + policy + "', expected always, interval or never")
+	}
+	interval, err := time.ParseDuration(s.config.GetOptionalValue("JOURNAL_FSYNC_INTERVAL", "1s"))
+	if nil != err || 0 >= interval {
+		return errors.New("Invalid JOURNAL_FSYNC_INTERVAL '" + s.config.GetOptionalValue("JOURNAL_FSYNC_INTERVAL", "") + "', expected a duration like '1s'")
+	}
+
+	names, err := journal.List(dir)
+	if nil != err {
+		return errors.New("Could not read journal dir: " + err.Error())
+	}
+
+	journals := &journalSet{
+		dir:      dir,
+		policy:   policy,
+		interval: interval,
+		mutex:    &sync.Mutex{},
+		open:     make(map[string]*journal.Journal),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// records already contained in the snapshot are skipped
+	snapshotSeqs := make(map[string]uint64)
+	for _, result := range restored {
+		snapshotSeqs[result.Storage] = result.JournalSeq
+	}
+	s.storages.journals = journals
+	for _, name := range names {
+		amount, err := s.replayJournal(name, snapshotSeqs[name])
+		if nil != err {
+			close(journals.done)
+			journals.close()
+			return errors.New("Could not replay journal of storage '" + name + "': " + err.Error())
+		}
+		archivist.Info("> Replayed journal of storage '"+name+"', records:", amount)
+	}
+
+	if journal.SyncInterval == policy {
+		go journals.run()
+	} else {
+		close(journals.done)
+	}
+	archivist.Info("> Journal enabled with fsync policy", policy)
+	return nil
+}
+
+func (s *Server) replayJournal(name string, after uint64) (int, error) {
+	g := s.storages.get(name)
+	if nil == g {
+		var apiErr *apiError
+		if g, apiErr = s.storages.create(name); nil != apiErr {
+			return 0, apiErr
+		}
+	}
+	j, apiErr := journalFor(g)
+	if nil != apiErr {
+		return 0, apiErr
+	}
+	return j.Replay(after, func(record journal.Record) error {
+		if apiErr := replayRecord(g, record); nil != apiErr {
+			return errors.New("Record " + record.Op + " #" + strconv.FormatUint(record.Seq, 10) + " failed: " + apiErr.Error())
+		}
+		return nil
+	})
+}
+
+// replayRecord runs the journaled operation again, the operations are
+// deterministic so they lead to the same ids and versions as before
+func replayRecord(g *instance, record journal.Record) *apiError {
+	var apiErr *apiError
+	switch record.Op {
+	case journalOpCreateEntityType, journalOpCreateEntity, journalOpUpdateEntity, journalOpDeleteEntity, journalOpMapJson:
+		var entity transport.TransportEntity
+		if err := json.Unmarshal(record.Data, &entity); nil != err {
+			return malformedBodyError(err)
+		}
+		switch record.Op {
+		case journalOpCreateEntityType:
+			apiErr = createEntityType(g, entity.Type)
+		case journalOpCreateEntity:
+			_, apiErr = createEntity(g, entity)
+		case journalOpUpdateEntity:
+			_, apiErr = updateEntity(g, entity)
+		case journalOpDeleteEntity:
+			apiErr = deleteEntity(g, entity.Type, entity.ID)
+		case journalOpMapJson:
+			_, apiErr = mapData(g, entity)
+		}
+	case journalOpCreateRelation, journalOpUpdateRelation, journalOpDeleteRelation:
+		var relation transport.TransportRelation
+		if err := json.Unmarshal(record.Data, &relation); nil != err {
+			return malformedBodyError(err)
+		}
+		switch record.Op {
+		case journalOpCreateRelation:
+			_, apiErr = createRelation(g, relation)
+		case journalOpUpdateRelation:
+			_, apiErr = updateRelation(g, relation)
+		case journalOpDeleteRelation:
+			apiErr = deleteRelation(g, relation.SourceType, relation.SourceID, relation.TargetType, relation.TargetID)
+		}
+	case journalOpQuery:
+		var qry query.Query
+		if err := json.Unmarshal(record.Data, &qry); nil != err {
+			return malformedBodyError(err)
+		}
+		_, apiErr = executeQuery(g, &qry)
+	case journalOpTransaction:
+		var operations []BatchOperation
+		if err := json.Unmarshal(record.Data, &operations); nil != err {
+			return malformedBodyError(err)
+		}
+		apiErr = replayTransaction(g, operations)
+	default:
+		apiErr = internalError("Unknown journal operation '" + record.Op + "'")
+	}
+	return apiErr
+}
+
+// journalFor returns the journal of the storage, it is opened on first
+// use. Without a configured JOURNAL_DIR the journal is nil.
+func journalFor(g *instance) (*journal.Journal, *apiError) {
+	journals := g.storages.journals
+	if nil == journals {
+		return nil, nil
+	}
+	journals.mutex.Lock()
+	defer journals.mutex.Unlock()
+	if j, ok := journals.open[g.Name]; ok {
+		return j, nil
+	}
+	j, err := journal.Open(journal.Path(journals.dir, g.Name), journals.policy)
+	if nil != err {
+		archivist.Error("Could not open journal of storage", g.Name, err.Error())
+		return nil, internalError("Could not open journal of storage '" + g.Name + "'")
+	}
+	journals.open[g.Name] = j
+	return j, nil
+}
+
+// lockJournal returns the locked journal of the storage. Mutations run
+// while holding it, so the journal order equals the order they got
+// applied in.
+func lockJournal(g *instance) (*journal.Journal, *apiError) {
+	j, apiErr := journalFor(g)
+	if nil != apiErr {
+		return nil, apiErr
+	}
+	j.Lock()
+	return j, nil
+}
+
+// appendJournal records a successful mutation, if this fails the
+// mutation is applied but not durable and the client is told so
+func appendJournal(j *journal.Journal, op string, data interface{}) *apiError {
+	if err := j.Append(op, data); nil != err {
+		archivist.Error("Could not append to journal", op, err.Error())
+		return internalError("The change got applied but could not be written to the journal")
+	}
+	return nil
+}
+
+// compactJournal drops the records contained in a snapshot, the
+// journal has to be locked by the caller
+func compactJournal(j *journal.Journal, upTo uint64) *apiError {
+	if nil == j {
+		return nil
+	}
+	if err := j.Compact(upTo); nil != err {
+		return internalError("Could not compact journal: " + err.Error())
+	}
+	return nil
+}
+
+// remove deletes the journal of a dropped storage
+func (js *journalSet) remove(name string) {
+	if nil == js {
+		return
+	}
+	js.mutex.Lock()
+	j, ok := js.open[name]
+	delete(js.open, name)
+	js.mutex.Unlock()
+
+	if !ok {
+		var err error
+		if j, err = journal.Open(journal.Path(js.dir, name), js.policy); nil != err {
+			return
+		}
+	}
+	j.Lock()
+	if err := j.Remove(); nil != err {
+		archivist.Error("Could not remove journal of dropped storage", name, err.Error())
+	}
+	j.Unlock()
+}
+
+// close syncs and closes all journals on shutdown. Closed journals are
+// forgotten, a later write opens its journal again.
+func (js *journalSet) close() {
+	if nil == js {
+		return
+	}
+	select {
+	case <-js.stop:
+		return
+	default:
+		close(js.stop)
+	}
+	<-js.done
+
+	js.mutex.Lock()
+	defer js.mutex.Unlock()
+	for name, j := range js.open {
+		j.Lock()
+		if err := j.Close(); nil != err {
+			archivist.Error("Could not close journal of storage", name, err.Error())
+		}
+		j.Unlock()
+		delete(js.open, name)
+	}
+}
+
+// run syncs the journals every JOURNAL_FSYNC_INTERVAL
+func (js *journalSet) run() {
+	defer close(js.done)
+	ticker := time.NewTicker(js.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			js.mutex.Lock()
+			open := make([]*journal.Journal, 0, len(js.open))
+			for _, j := range js.open {
+				open = append(open, j)
+			}
+			js.mutex.Unlock()
+			for _, j := range open {
+				if err := j.Sync(); nil != err {
+					archivist.Error("Journal sync failed", err.Error())
+				}
+			}
+		case <-js.stop:
+			return
+		}
+	}
+}
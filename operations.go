@@ -0,0 +1,492 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/voodooEntity/gits/src/query"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// storage operations shared by the /v1 and /v2 routes,
+// they translate between transport and storage format
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func readEntity(g *instance, typeStr string, id int) (transport.TransportEntity, *apiError) {
+	typeID, err := g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	data, err := g.Storage().GetEntityByPath(typeID, id, "")
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	return transport.TransportEntity{
+		ID:         data.ID,
+		Type:       typeStr,
+		Context:    data.Context,
+		Value:      data.Value,
+		Properties: data.Properties,
+		Version:    data.Version,
+	}, nil
+}
+
+func readEntitiesByType(g *instance, typeStr string, context string) ([]transport.TransportEntity, *apiError) {
+	entities, err := g.Storage().GetEntitiesByType(typeStr, context)
+	if nil != err {
+		return nil, storageError(err)
+	}
+
+	ret := []transport.TransportEntity{}
+	for _, val := range entities {
+		ret = append(ret, transport.TransportEntity{
+			ID:         val.ID,
+			Type:       typeStr,
+			Context:    val.Context,
+			Value:      val.Value,
+			Properties: val.Properties,
+			Version:    val.Version,
+		})
+	}
+	return ret, nil
+}
+
+func createEntityType(g *instance, typeStr string) *apiError {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer w.end()
+	return w.createEntityType(typeStr)
+}
+
+// createEntityType creates the type if it doesn't exist yet
+func (w *storageWrite) createEntityType(typeStr string) *apiError {
+	if "" == typeStr {
+		return newApiError(http.StatusBadRequest, CodeMalformedBody, "Missing entity type", map[string]string{"field": "EntityType"})
+	}
+
+	if _, err := w.g.Storage().GetTypeIdByString(typeStr); nil == err {
+		return nil
+	}
+	w.undo.entityType(typeStr)
+	if _, err := w.g.Storage().CreateEntityType(typeStr); nil != err {
+		return storageError(err)
+	}
+	return w.record(journalOpCreateEntityType, transport.TransportEntity{Type: typeStr})
+}
+
+func createEntity(g *instance, entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+	return w.createEntity(entity)
+}
+
+// createEntity stores a new entity, the entity type gets created
+// if it doesn't exist yet (like mapJson does)
+func (w *storageWrite) createEntity(entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	if "" == entity.Type {
+		return transport.TransportEntity{}, newApiError(http.StatusBadRequest, CodeMalformedBody, "Missing entity type", map[string]string{"field": "Type"})
+	}
+
+	w.undo.entityType(entity.Type)
+	typeID, err := w.g.Storage().CreateEntityType(entity.Type)
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	w.undo.newEntity(typeID)
+	newID, err := w.g.Storage().CreateEntity(types.StorageEntity{
+		Type:       typeID,
+		ID:         -1,
+		Value:      entity.Value,
+		Properties: entity.Properties,
+		Context:    entity.Context,
+	})
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	return transport.TransportEntity{
+		ID:         newID,
+		Type:       entity.Type,
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Properties: entity.Properties,
+		Version:    1,
+	}, w.record(journalOpCreateEntity, entity)
+}
+
+func updateEntity(g *instance, entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+	return w.updateEntity(entity)
+}
+
+// updateEntity overwrites Value, Context and Properties of an existing
+// entity. The given Version has to match the stored one.
+func (w *storageWrite) updateEntity(entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	typeID, err := w.g.Storage().GetTypeIdByString(entity.Type)
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	w.undo.entity(typeID, entity.ID)
+	err = w.g.Storage().UpdateEntity(types.StorageEntity{
+		Type:       typeID,
+		ID:         entity.ID,
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Properties: entity.Properties,
+		Version:    entity.Version,
+	})
+	if nil != err {
+		return transport.TransportEntity{}, storageError(err)
+	}
+
+	if apiErr := w.record(journalOpUpdateEntity, entity); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	entity.Version++
+	return entity, nil
+}
+
+func replaceEntity(g *instance, entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+	return w.replaceEntity(entity)
+}
+
+// replaceEntity updates the entity whatever version is stored. The
+// version is read within the write, so no other write can get between.
+func (w *storageWrite) replaceEntity(entity transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	current, apiErr := readEntity(w.g, entity.Type, entity.ID)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	entity.Version = current.Version
+	return w.updateEntity(entity)
+}
+
+func deleteEntity(g *instance, typeStr string, id int) *apiError {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer w.end()
+	return w.deleteEntity(typeStr, id)
+}
+
+// deleteEntity removes the entity along with all its relations
+func (w *storageWrite) deleteEntity(typeStr string, id int) *apiError {
+	typeID, err := w.g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		return storageError(err)
+	}
+
+	// deleting a non existing entity is a client error
+	if !w.g.Storage().EntityExists(typeID, id) {
+		return entityNotFoundError()
+	}
+
+	w.undo.entity(typeID, id)
+	w.undo.entityRelations(typeID, id)
+	w.g.Storage().DeleteEntity(typeID, id)
+	return w.record(journalOpDeleteEntity, transport.TransportEntity{Type: typeStr, ID: id})
+}
+
+func readChildEntities(g *instance, typeStr string, id int, context string) ([]transport.TransportEntity, *apiError) {
+	typeID, err := g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		return nil, storageError(err)
+	}
+
+	childRelations, err := g.Storage().GetChildRelationsBySourceTypeAndSourceId(typeID, id, context)
+	if nil != err {
+		return nil, storageError(err)
+	}
+
+	// children can be of any type so we translate by the full type list
+	entityTypes := g.Storage().GetEntityTypes()
+	ret := []transport.TransportEntity{}
+	for _, val := range childRelations {
+		entity, err := g.Storage().GetEntityByPath(val.TargetType, val.TargetID, "")
+		if nil == err {
+			ret = append(ret, transport.TransportEntity{
+				ID:         entity.ID,
+				Type:       entityTypes[entity.Type],
+				Value:      entity.Value,
+				Context:    entity.Context,
+				Properties: entity.Properties,
+				Version:    entity.Version,
+			})
+		}
+	}
+	return ret, nil
+}
+
+func readParentEntities(g *instance, typeStr string, id int, context string) ([]transport.TransportEntity, *apiError) {
+	typeID, err := g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		return nil, storageError(err)
+	}
+
+	parentRelations, err := g.Storage().GetParentRelationsByTargetTypeAndTargetId(typeID, id, context)
+	if nil != err {
+		return nil, storageError(err)
+	}
+
+	// parents can be of any type so we translate by the full type list
+	entityTypes := g.Storage().GetEntityTypes()
+	ret := []transport.TransportEntity{}
+	for _, val := range parentRelations {
+		entity, err := g.Storage().GetEntityByPath(val.SourceType, val.SourceID, "")
+		if nil == err {
+			ret = append(ret, transport.TransportEntity{
+				ID:         entity.ID,
+				Type:       entityTypes[entity.Type],
+				Value:      entity.Value,
+				Context:    entity.Context,
+				Properties: entity.Properties,
+				Version:    entity.Version,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// relationTypeIDs resolves the source and target type of a relation
+func relationTypeIDs(g *instance, srcType string, targetType string) (int, int, *apiError) {
+	srcTypeID, err := g.Storage().GetTypeIdByString(srcType)
+	if nil != err {
+		return -1, -1, storageError(err)
+	}
+	targetTypeID, err := g.Storage().GetTypeIdByString(targetType)
+	if nil != err {
+		return -1, -1, storageError(err)
+	}
+	return srcTypeID, targetTypeID, nil
+}
+
+func readRelation(g *instance, srcType string, srcID int, targetType string, targetID int) (transport.TransportRelation, *apiError) {
+	srcTypeID, targetTypeID, apiErr := relationTypeIDs(g, srcType, targetType)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+
+	relation, err := g.Storage().GetRelation(srcTypeID, srcID, targetTypeID, targetID)
+	if nil != err {
+		return transport.TransportRelation{}, storageError(err)
+	}
+
+	return transport.TransportRelation{
+		SourceType: srcType,
+		SourceID:   relation.SourceID,
+		TargetType: targetType,
+		TargetID:   relation.TargetID,
+		Context:    relation.Context,
+		Properties: relation.Properties,
+		Version:    relation.Version,
+	}, nil
+}
+
+func createRelation(g *instance, relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	defer w.end()
+	return w.createRelation(relation)
+}
+
+// createRelation relates two existing entities
+func (w *storageWrite) createRelation(relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	srcTypeID, targetTypeID, apiErr := relationTypeIDs(w.g, relation.SourceType, relation.TargetType)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+
+	// both ends of the relation have to exist
+	if !w.g.Storage().EntityExists(srcTypeID, relation.SourceID) || !w.g.Storage().EntityExists(targetTypeID, relation.TargetID) {
+		return transport.TransportRelation{}, entityNotFoundError()
+	}
+
+	w.undo.relation(srcTypeID, relation.SourceID, targetTypeID, relation.TargetID)
+	_, err := w.g.Storage().CreateRelation(srcTypeID, relation.SourceID, targetTypeID, relation.TargetID, types.StorageRelation{
+		SourceID:   relation.SourceID,
+		SourceType: srcTypeID,
+		TargetID:   relation.TargetID,
+		TargetType: targetTypeID,
+		Context:    relation.Context,
+		Properties: relation.Properties,
+	})
+	if nil != err {
+		return transport.TransportRelation{}, storageError(err)
+	}
+
+	relation.Target = transport.TransportEntity{}
+	if apiErr := w.record(journalOpCreateRelation, relation); nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	relation.Version = 1
+	return relation, nil
+}
+
+func updateRelation(g *instance, relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	defer w.end()
+	return w.updateRelation(relation)
+}
+
+// updateRelation overwrites Context and Properties of an existing
+// relation. The given Version has to match the stored one.
+func (w *storageWrite) updateRelation(relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	srcTypeID, targetTypeID, apiErr := relationTypeIDs(w.g, relation.SourceType, relation.TargetType)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+
+	w.undo.relation(srcTypeID, relation.SourceID, targetTypeID, relation.TargetID)
+	_, err := w.g.Storage().UpdateRelation(srcTypeID, relation.SourceID, targetTypeID, relation.TargetID, types.StorageRelation{
+		SourceID:   relation.SourceID,
+		SourceType: srcTypeID,
+		TargetID:   relation.TargetID,
+		TargetType: targetTypeID,
+		Context:    relation.Context,
+		Properties: relation.Properties,
+		Version:    relation.Version,
+	})
+	if nil != err {
+		return transport.TransportRelation{}, storageError(err)
+	}
+
+	relation.Target = transport.TransportEntity{}
+	if apiErr := w.record(journalOpUpdateRelation, relation); nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	relation.Version++
+	return relation, nil
+}
+
+func replaceRelation(g *instance, relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	defer w.end()
+	return w.replaceRelation(relation)
+}
+
+// replaceRelation updates the relation whatever version is stored, like
+// replaceEntity does for entities
+func (w *storageWrite) replaceRelation(relation transport.TransportRelation) (transport.TransportRelation, *apiError) {
+	current, apiErr := readRelation(w.g, relation.SourceType, relation.SourceID, relation.TargetType, relation.TargetID)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	relation.Version = current.Version
+	return w.updateRelation(relation)
+}
+
+func deleteRelation(g *instance, srcType string, srcID int, targetType string, targetID int) *apiError {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return apiErr
+	}
+	defer w.end()
+	return w.deleteRelation(srcType, srcID, targetType, targetID)
+}
+
+func (w *storageWrite) deleteRelation(srcType string, srcID int, targetType string, targetID int) *apiError {
+	srcTypeID, targetTypeID, apiErr := relationTypeIDs(w.g, srcType, targetType)
+	if nil != apiErr {
+		return apiErr
+	}
+
+	// deleting a non existing relation is a client error
+	if !w.g.Storage().RelationExists(srcTypeID, srcID, targetTypeID, targetID) {
+		return relationNotFoundError()
+	}
+
+	w.undo.relation(srcTypeID, srcID, targetTypeID, targetID)
+	w.g.Storage().DeleteRelation(srcTypeID, srcID, targetTypeID, targetID)
+	return w.record(journalOpDeleteRelation, transport.TransportRelation{
+		SourceType: srcType,
+		SourceID:   srcID,
+		TargetType: targetType,
+		TargetID:   targetID,
+	})
+}
+
+// mapData maps a nested entity structure like mapJson does. Mapping
+// can touch any part of the storage, so it can't be undone and isn't
+// available in transactions.
+func mapData(g *instance, data transport.TransportEntity) (transport.TransportEntity, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+
+	// the journal needs the input, MapData changes the nested structure
+	record, err := json.Marshal(data)
+	if nil != err {
+		return transport.TransportEntity{}, internalError(err.Error())
+	}
+	ret := g.MapData(data)
+	return ret, w.record(journalOpMapJson, json.RawMessage(record))
+}
+
+func executeQuery(g *instance, qry *query.Query) (transport.Transport, *apiError) {
+	if !isMutatingQuery(qry) {
+		return g.Query().Execute(qry), nil
+	}
+
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.Transport{}, apiErr
+	}
+	defer w.end()
+	return w.executeQuery(qry)
+}
+
+// executeQuery runs a query, queries changing the storage get journaled.
+// Like mapData those can't be undone, transactions only allow reading.
+func (w *storageWrite) executeQuery(qry *query.Query) (transport.Transport, *apiError) {
+	if !isMutatingQuery(qry) {
+		return w.g.Query().Execute(qry), nil
+	}
+	if w.undo.active() {
+		return transport.Transport{}, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Queries changing the storage can't be part of a transaction", map[string]string{"field": "Method"})
+	}
+
+	ret := w.g.Query().Execute(qry)
+	if 0 == ret.Amount {
+		return ret, nil
+	}
+	return ret, w.record(journalOpQuery, qry)
+}
+
+func isMutatingQuery(qry *query.Query) bool {
+	switch qry.Method {
+	case query.METHOD_UPDATE, query.METHOD_DELETE, query.METHOD_LINK, query.METHOD_UNLINK:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package gitsapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// the url params of the list routes, once one of them is given the
+// entities are answered as an EntityPage
+var listUrlParams = []string{"limit", "offset", "cursor", "sort", "fields"}
+
+// the entity fields which can be sorted by and projected, properties are
+// sorted by with "Properties.<name>"
+var entityFields = []string{"Type", "ID", "Value", "Context", "Version", "Properties"}
+
+// EntityPage is a part of a sorted list of entities. Total is the amount
+// of all matching entities, Next the cursor of the following page which
+// is empty on the last one.
+type EntityPage struct {
+	// either []transport.TransportEntity or the projected fields
+	Entities interface{}
+	Amount   int
+	Total    int
+	Next     string `json:",omitempty"`
+}
+
+type listParams struct {
+	paged      bool
+	limit      int
+	offset     int
+	cursor     *pageCursor
+	sort       string
+	descending bool
+	fields     []string
+}
+
+// pageCursor points behind the last entity of a page. It carries the sort
+// key of that entity instead of a position, so entities created or deleted
+// meanwhile don't shift the following pages.
+type pageCursor struct {
+	Sort   string
+	Number int
+	Text   string
+	Type   string
+	ID     int
+}
+
+// getListParams reads and checks the list url params. Without any of them
+// the routes answer as before, unless MAX_PAGE_SIZE enforces paging.
+func getListParams(r *http.Request) (listParams, *apiError) {
+	urlParams := make(map[string]string)
+	optionalUrlParams := make(map[string]string)
+	for _, name := range listUrlParams {
+		optionalUrlParams[name] = ""
+	}
+	urlParams = getOptionalUrlParams(optionalUrlParams, urlParams, r)
+
+	params := listParams{paged: 0 < len(urlParams), sort: "ID"}
+	maxPageSize, err := strconv.Atoi(serverFromRequest(r).config.GetOptionalValue("MAX_PAGE_SIZE", "0"))
+	if nil != err || 0 > maxPageSize {
+		return params, internalError("Invalid MAX_PAGE_SIZE configured, integer expected")
+	}
+	if 0 < maxPageSize {
+		params.paged = true
+		params.limit = maxPageSize
+	}
+
+	if "" != urlParams["limit"] {
+		limit, apiErr := getIntUrlParam(urlParams, "limit")
+		if nil != apiErr {
+			return params, apiErr
+		}
+		if 1 > limit {
+			return params, invalidListParamError("limit", "Invalid param 'limit' given, it has to be at least 1")
+		}
+		// larger pages are cut down, the cursor still reaches the rest
+		if 0 == maxPageSize || limit < maxPageSize {
+			params.limit = limit
+		}
+	}
+	if "" != urlParams["offset"] {
+		offset, apiErr := getIntUrlParam(urlParams, "offset")
+		if nil != apiErr {
+			return params, apiErr
+		}
+		if 0 > offset {
+			return params, invalidListParamError("offset", "Invalid param 'offset' given, it can't be negative")
+		}
+		params.offset = offset
+	}
+
+	if "" != urlParams["sort"] {
+		params.sort = strings.TrimPrefix(urlParams["sort"], "-")
+		params.descending = strings.HasPrefix(urlParams["sort"], "-")
+		if !isEntityField(params.sort) && (!strings.HasPrefix(params.sort, "Properties.") || "Properties." == params.sort) {
+			return params, invalidListParamError("sort", "Invalid param 'sort' given, expected one of "+strings.Join(entityFields[:5], ", ")+" or Properties.<name>")
+		}
+		if "Properties" == params.sort {
+			return params, invalidListParamError("sort", "Invalid param 'sort' given, properties are sorted by with Properties.<name>")
+		}
+	}
+
+	if "" != urlParams["cursor"] {
+		if 0 < params.offset {
+			return params, invalidListParamError("cursor", "The params 'cursor' and 'offset' can't be combined")
+		}
+		cursor, err := decodePageCursor(urlParams["cursor"])
+		if nil != err || cursor.Sort != params.sortParam() {
+			return params, invalidListParamError("cursor", "Invalid param 'cursor' given, it has to be used with the same 'sort' it was returned for")
+		}
+		params.cursor = &cursor
+	}
+
+	if "" != urlParams["fields"] {
+		for _, field := range strings.Split(urlParams["fields"], ",") {
+			field = strings.TrimSpace(field)
+			if !isEntityField(field) {
+				return params, invalidListParamError("fields", "Invalid param 'fields' given, unknown field '"+field+"', expected "+strings.Join(entityFields, ", "))
+			}
+			params.fields = append(params.fields, field)
+		}
+	}
+	return params, nil
+}
+
+func invalidListParamError(param string, message string) *apiError {
+	return newApiError(http.StatusBadRequest, CodeInvalidParameter, message, map[string]string{"param": param})
+}
+
+func isEntityField(field string) bool {
+	for _, known := range entityFields {
+		if known == field {
+			return true
+		}
+	}
+	return false
+}
+
+// respondEntities answers a list route, either as plain transport or as
+// page if any list param is given
+func respondEntities(entities []transport.TransportEntity, params listParams, w http.ResponseWriter) {
+	if !params.paged {
+		respondOk(transport.Transport{
+			Entities: entities,
+		}, w)
+		return
+	}
+	respondJson(pageEntities(entities, params), 200, w)
+}
+
+// pageEntities sorts the entities and cuts out the requested page. The
+// entities are ordered by type and id on equal sort keys, so the order is
+// stable and every entity has a distinct position.
+func pageEntities(entities []transport.TransportEntity, params listParams) EntityPage {
+	sorted := make([]transport.TransportEntity, len(entities))
+	copy(sorted, entities)
+	sort.Slice(sorted, func(i, j int) bool {
+		return params.before(params.cursorOf(sorted[i]), params.cursorOf(sorted[j]))
+	})
+
+	start := params.offset
+	if nil != params.cursor {
+		start = sort.Search(len(sorted), func(i int) bool {
+			return params.before(*params.cursor, params.cursorOf(sorted[i]))
+		})
+	}
+	if start > len(sorted) {
+		start = len(sorted)
+	}
+	end := len(sorted)
+	if 0 < params.limit && start+params.limit < end {
+		end = start + params.limit
+	}
+
+	page := EntityPage{
+		Entities: sorted[start:end],
+		Amount:   end - start,
+		Total:    len(sorted),
+	}
+	if end < len(sorted) && end > start {
+		page.Next = encodePageCursor(params.cursorOf(sorted[end-1]))
+	}
+	if nil != params.fields {
+		page.Entities = projectEntities(sorted[start:end], params.fields)
+	}
+	return page
+}
+
+// sortParam is the sort url param the params stand for
+func (params listParams) sortParam() string {
+	if params.descending {
+		return "-" + params.sort
+	}
+	return params.sort
+}
+
+// cursorOf builds the sort key of an entity
+func (params listParams) cursorOf(entity transport.TransportEntity) pageCursor {
+	cursor := pageCursor{Sort: params.sortParam(), Type: entity.Type, ID: entity.ID}
+	switch params.sort {
+	case "ID":
+		cursor.Number = entity.ID
+	case "Version":
+		cursor.Number = entity.Version
+	case "Type":
+		cursor.Text = entity.Type
+	case "Value":
+		cursor.Text = entity.Value
+	case "Context":
+		cursor.Text = entity.Context
+	default:
+		cursor.Text = entity.Properties[strings.TrimPrefix(params.sort, "Properties.")]
+	}
+	return cursor
+}
+
+// before tells if a is ordered before b. Properties are compared as text,
+// missing ones are ordered like empty ones.
+func (params listParams) before(a pageCursor, b pageCursor) bool {
+	if a.Number != b.Number {
+		return (a.Number < b.Number) != params.descending
+	}
+	if a.Text != b.Text {
+		return (a.Text < b.Text) != params.descending
+	}
+	if a.Type != b.Type {
+		return (a.Type < b.Type) != params.descending
+	}
+	// equal keys are the same entity, which is never before itself
+	if a.ID != b.ID {
+		return (a.ID < b.ID) != params.descending
+	}
+	return false
+}
+
+func encodePageCursor(cursor pageCursor) string {
+	// the cursor only holds strings and ints, encoding can't fail
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageCursor(encoded string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if nil != err {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+// projectEntities reduces the entities to the requested fields. Type and
+// ID are always kept, so the entities can still be addressed.
+func projectEntities(entities []transport.TransportEntity, fields []string) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	for _, entity := range entities {
+		projected := map[string]interface{}{
+			"Type": entity.Type,
+			"ID":   entity.ID,
+		}
+		for _, field := range fields {
+			switch field {
+			case "Value":
+				projected["Value"] = entity.Value
+			case "Context":
+				projected["Context"] = entity.Context
+			case "Version":
+				projected["Version"] = entity.Version
+			case "Properties":
+				projected["Properties"] = entity.Properties
+			}
+		}
+		ret = append(ret, projected)
+	}
+	return ret
+}
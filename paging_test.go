@@ -0,0 +1,200 @@
+package gitsapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// pagingEntities have equal values, versions and properties, so the
+// order falls back to type and ID
+var pagingEntities = []transport.TransportEntity{
+	{Type: "Person", ID: 1, Value: "bob", Version: 1, Properties: map[string]string{"age": "30"}},
+	{Type: "Person", ID: 2, Value: "alice", Version: 2},
+	{Type: "Company", ID: 1, Value: "acme", Version: 1, Properties: map[string]string{"age": "10"}},
+	{Type: "Person", ID: 3, Value: "bob", Version: 3},
+	{Type: "Company", ID: 2, Value: "bob", Version: 1},
+}
+
+// listParamsOf reads the list params of the query like a list route of
+// a server with the given configs does
+func listParamsOf(t *testing.T, query string, params map[string]string) (listParams, *apiError) {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/v1/getEntitiesByType?"+query, nil)
+	s := &Server{config: testConfig(t, params)}
+	return getListParams(r.WithContext(context.WithValue(r.Context(), serverContextKey, s)))
+}
+
+func entityKeys(entities []transport.TransportEntity) []string {
+	keys := []string{}
+	for _, entity := range entities {
+		keys = append(keys, entity.Type[:1]+strconv.Itoa(entity.ID))
+	}
+	return keys
+}
+
+func TestPageEntities(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		query  string
+		config map[string]string
+		// the entities of every page, following the Next cursors
+		pages [][]string
+	}{
+		{"by ID", "limit=2", nil, [][]string{{"C1", "P1"}, {"C2", "P2"}, {"P3"}}},
+		{"by ID descending", "sort=-ID&limit=2", nil, [][]string{{"P3", "P2"}, {"C2", "P1"}, {"C1"}}},
+		{"by value", "sort=Value&limit=3", nil, [][]string{{"C1", "P2", "C2"}, {"P1", "P3"}}},
+		{"by value descending", "sort=-Value&limit=2", nil, [][]string{{"P3", "P1"}, {"C2", "P2"}, {"C1"}}},
+		{"by version", "sort=Version&limit=2", nil, [][]string{{"C1", "C2"}, {"P1", "P2"}, {"P3"}}},
+		{"by type", "sort=Type&limit=4", nil, [][]string{{"C1", "C2", "P1", "P2"}, {"P3"}}},
+		{"by property, missing ones first", "sort=Properties.age", nil, [][]string{{"C2", "P2", "P3", "C1", "P1"}}},
+		{"limit beyond the entities", "limit=10", nil, [][]string{{"C1", "P1", "C2", "P2", "P3"}}},
+		{"limit exactly the entities", "limit=5", nil, [][]string{{"C1", "P1", "C2", "P2", "P3"}}},
+		{"limit cut down by MAX_PAGE_SIZE", "limit=10", map[string]string{"MAX_PAGE_SIZE": "2"}, [][]string{{"C1", "P1"}, {"C2", "P2"}, {"P3"}}},
+		{"MAX_PAGE_SIZE without limit", "", map[string]string{"MAX_PAGE_SIZE": "3"}, [][]string{{"C1", "P1", "C2"}, {"P2", "P3"}}},
+		{"limit below MAX_PAGE_SIZE", "limit=4", map[string]string{"MAX_PAGE_SIZE": "10"}, [][]string{{"C1", "P1", "C2", "P2"}, {"P3"}}},
+		{"offset", "offset=3&limit=1", nil, [][]string{{"P2"}, {"P3"}}},
+		{"offset beyond the entities", "offset=7", nil, [][]string{{}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params, apiErr := listParamsOf(t, tc.query, tc.config)
+			if nil != apiErr {
+				t.Fatal(apiErr)
+			}
+			if !params.paged {
+				t.Fatal("params are not paged")
+			}
+			for i, want := range tc.pages {
+				page := pageEntities(pagingEntities, params)
+				entities, ok := page.Entities.([]transport.TransportEntity)
+				if !ok {
+					t.Fatalf("page %d holds %T", i, page.Entities)
+				}
+				if got := entityKeys(entities); !reflect.DeepEqual(want, got) {
+					t.Errorf("page %d: expected %v, got %v", i, want, got)
+				}
+				if len(entities) != page.Amount || len(pagingEntities) != page.Total {
+					t.Errorf("page %d: amount %d and total %d for %d entities", i, page.Amount, page.Total, len(entities))
+				}
+				if len(tc.pages)-1 == i {
+					if "" != page.Next {
+						t.Errorf("last page has next cursor %s", page.Next)
+					}
+					break
+				}
+				if "" == page.Next {
+					t.Fatalf("page %d has no next cursor", i)
+				}
+
+				// the next page is requested by the cursor instead of the offset
+				values, _ := url.ParseQuery(tc.query)
+				values.Del("offset")
+				values.Set("cursor", page.Next)
+				if params, apiErr = listParamsOf(t, values.Encode(), tc.config); nil != apiErr {
+					t.Fatal(apiErr)
+				}
+			}
+		})
+	}
+}
+
+// a cursor points behind an entity, the page after it starts at the next
+// entity even if the one it points at got deleted meanwhile
+func TestPageCursorSurvivesDeletion(t *testing.T) {
+	params, _ := listParamsOf(t, "sort=Value&limit=2", nil)
+	first := pageEntities(pagingEntities, params)
+
+	remaining := []transport.TransportEntity{}
+	for _, entity := range pagingEntities {
+		// P2 alice ends the first page
+		if "Person" != entity.Type || 2 != entity.ID {
+			remaining = append(remaining, entity)
+		}
+	}
+	params, apiErr := listParamsOf(t, "sort=Value&limit=2&cursor="+first.Next, nil)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	second := pageEntities(remaining, params)
+	if got := entityKeys(second.Entities.([]transport.TransportEntity)); !reflect.DeepEqual([]string{"C2", "P1"}, got) {
+		t.Errorf("expected [C2 P1], got %v", got)
+	}
+}
+
+func TestPageProjection(t *testing.T) {
+	params, apiErr := listParamsOf(t, "fields=Value,Properties&limit=2", nil)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	page := pageEntities(pagingEntities, params)
+	projected, ok := page.Entities.([]map[string]interface{})
+	if !ok {
+		t.Fatalf("projected page holds %T", page.Entities)
+	}
+	want := []map[string]interface{}{
+		{"Type": "Company", "ID": 1, "Value": "acme", "Properties": map[string]string{"age": "10"}},
+		{"Type": "Person", "ID": 1, "Value": "bob", "Properties": map[string]string{"age": "30"}},
+	}
+	if !reflect.DeepEqual(want, projected) {
+		t.Errorf("expected %v, got %v", want, projected)
+	}
+
+	// type and id are kept even if not asked for
+	params, _ = listParamsOf(t, "fields=Version", nil)
+	for _, entity := range pageEntities(pagingEntities, params).Entities.([]map[string]interface{}) {
+		keys := []string{}
+		for key := range entity {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual([]string{"ID", "Type", "Version"}, keys) {
+			t.Errorf("expected the fields ID, Type and Version, got %v", keys)
+		}
+	}
+}
+
+func TestListParamsRejected(t *testing.T) {
+	params, _ := listParamsOf(t, "sort=Value&limit=2", nil)
+	valueCursor := url.QueryEscape(pageEntities(pagingEntities, params).Next)
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		config map[string]string
+		param  string
+	}{
+		{"cursor of another sort", "sort=ID&cursor=" + valueCursor, nil, "cursor"},
+		{"cursor of the other direction", "sort=-Value&cursor=" + valueCursor, nil, "cursor"},
+		{"cursor without its sort", "cursor=" + valueCursor, nil, "cursor"},
+		{"broken cursor", "cursor=nocursor", nil, "cursor"},
+		{"cursor with offset", "sort=Value&offset=1&cursor=" + valueCursor, nil, "cursor"},
+		{"limit of 0", "limit=0", nil, "limit"},
+		{"limit no number", "limit=many", nil, "limit"},
+		{"negative offset", "offset=-1", nil, "offset"},
+		{"unknown sort field", "sort=Name", nil, "sort"},
+		{"sort by all properties", "sort=Properties", nil, "sort"},
+		{"sort by empty property", "sort=Properties.", nil, "sort"},
+		{"unknown field", "fields=Value,Name", nil, "fields"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, apiErr := listParamsOf(t, tc.query, tc.config)
+			if nil == apiErr {
+				t.Fatal("params got accepted")
+			}
+			details, _ := apiErr.Details.(map[string]string)
+			if 400 != apiErr.Status || tc.param != details["param"] {
+				t.Errorf("expected 400 for param %s, got %d %v", tc.param, apiErr.Status, apiErr.Details)
+			}
+		})
+	}
+
+	if _, apiErr := listParamsOf(t, "", map[string]string{"MAX_PAGE_SIZE": "-1"}); nil == apiErr || 500 != apiErr.Status {
+		t.Errorf("invalid MAX_PAGE_SIZE answered %v", apiErr)
+	}
+}
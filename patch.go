@@ -0,0 +1,248 @@
+package gitsapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+	"github.com/voodooEntity/gitsapi/src/patch"
+)
+
+// the media types of PATCH bodies, plain JSON is a merge patch
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch is announced on the PATCH routes
+const acceptPatch = mergePatchType + ", " + jsonPatchType
+
+// patchableEntity is the document a PATCH of an entity applies to. The
+// Version can't be changed, a patch setting or testing it states the
+// version the client expects.
+type patchableEntity struct {
+	Value      string
+	Context    string
+	Properties map[string]string
+	Version    int
+}
+
+// patchableRelation is the relation counterpart of patchableEntity
+type patchableRelation struct {
+	Context    string
+	Properties map[string]string
+	Version    int
+}
+
+func (s *Server) registerPatchRoutes() {
+	// Route: /v1/patchEntity
+	s.handleFunc("/v1/patchEntity", s.requireScope(auth.ScopeWrite, handlePatchEntity))
+
+	// Route: /v1/patchRelation
+	s.handleFunc("/v1/patchRelation", s.requireScope(auth.ScopeWrite, handlePatchRelation))
+}
+
+func handlePatchEntity(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if "PATCH" != r.Method {
+		respondError(methodNotAllowedError("PATCH"), w)
+		return
+	}
+
+	urlParams, apiErr := getRequiredUrlParams(map[string]string{"type": "", "id": ""}, r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	id, apiErr := getIntUrlParam(urlParams, "id")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	body, err := getRequestBody(r)
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+
+	if apiErr := authorize(r, auth.ActionUpdate, urlParams["type"]); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	entity, apiErr := patchEntity(storageFromRequest(r), r.Header.Get("If-Match"), r.Header.Get("Content-Type"), urlParams["type"], id, body)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, entity.Version)
+	respondOk(transport.Transport{
+		Entities: []transport.TransportEntity{entity},
+	}, w)
+}
+
+func handlePatchRelation(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if "PATCH" != r.Method {
+		respondError(methodNotAllowedError("PATCH"), w)
+		return
+	}
+
+	urlParams, apiErr := getRequiredUrlParams(map[string]string{"srcType": "", "srcID": "", "targetType": "", "targetID": ""}, r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	srcID, apiErr := getIntUrlParam(urlParams, "srcID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	targetID, apiErr := getIntUrlParam(urlParams, "targetID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	body, err := getRequestBody(r)
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+
+	if apiErr := authorize(r, auth.ActionUpdate, urlParams["srcType"], urlParams["targetType"]); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	address := transport.TransportRelation{SourceType: urlParams["srcType"], SourceID: srcID, TargetType: urlParams["targetType"], TargetID: targetID}
+	relation, apiErr := patchRelation(storageFromRequest(r), r.Header.Get("If-Match"), r.Header.Get("Content-Type"), address, body)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, relation.Version)
+	respondOk(transport.Transport{
+		Relations: []transport.TransportRelation{relation},
+	}, w)
+}
+
+// patchEntity reads, patches and writes the entity within one write,
+// so no other change can get lost in between
+func patchEntity(g *instance, ifMatch string, contentType string, typeStr string, id int, body []byte) (transport.TransportEntity, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	defer w.end()
+
+	entity, apiErr := readEntity(g, typeStr, id)
+	if nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	if "" != ifMatch {
+		if apiErr := checkIfMatch(ifMatch, entity.Version); nil != apiErr {
+			return transport.TransportEntity{}, apiErr
+		}
+	}
+
+	var document patchableEntity
+	current := patchableEntity{Value: entity.Value, Context: entity.Context, Properties: entity.Properties, Version: entity.Version}
+	if apiErr := applyPatch(contentType, body, current, &document); nil != apiErr {
+		return transport.TransportEntity{}, apiErr
+	}
+	entity.Value, entity.Context, entity.Properties, entity.Version = document.Value, document.Context, document.Properties, document.Version
+	return w.updateEntity(entity)
+}
+
+func patchRelation(g *instance, ifMatch string, contentType string, address transport.TransportRelation, body []byte) (transport.TransportRelation, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	defer w.end()
+
+	relation, apiErr := readRelation(g, address.SourceType, address.SourceID, address.TargetType, address.TargetID)
+	if nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	if "" != ifMatch {
+		if apiErr := checkIfMatch(ifMatch, relation.Version); nil != apiErr {
+			return transport.TransportRelation{}, apiErr
+		}
+	}
+
+	var document patchableRelation
+	current := patchableRelation{Context: relation.Context, Properties: relation.Properties, Version: relation.Version}
+	if apiErr := applyPatch(contentType, body, current, &document); nil != apiErr {
+		return transport.TransportRelation{}, apiErr
+	}
+	relation.Context, relation.Properties, relation.Version = document.Context, document.Properties, document.Version
+	return w.updateRelation(relation)
+}
+
+// applyPatch patches the current document by the media type of the
+// body and decodes the outcome into target. Members the outcome
+// doesn't have are left empty in target.
+func applyPatch(contentType string, body []byte, current interface{}, target interface{}) *apiError {
+	encoded, err := json.Marshal(current)
+	if nil != err {
+		return internalError(err.Error())
+	}
+	var document interface{}
+	if err := json.Unmarshal(encoded, &document); nil != err {
+		return internalError(err.Error())
+	}
+
+	mediaType := "application/json"
+	if "" != contentType {
+		if mediaType, _, err = mime.ParseMediaType(contentType); nil != err {
+			return unsupportedPatchTypeError(contentType)
+		}
+	}
+	switch mediaType {
+	case "application/json", mergePatchType:
+		var mergePatch interface{}
+		if err := json.Unmarshal(body, &mergePatch); nil != err {
+			return malformedBodyError(err)
+		}
+		document = patch.Merge(document, mergePatch)
+	case jsonPatchType:
+		var operations []patch.Operation
+		if err := json.Unmarshal(body, &operations); nil != err {
+			return malformedBodyError(err)
+		}
+		if document, err = patch.Apply(document, operations); nil != err {
+			patchErr, _ := err.(*patch.Error)
+			return newApiError(http.StatusConflict, CodePatchFailed, "The patch could not be applied, "+patchErr.Message, map[string]string{"operation": strconv.Itoa(patchErr.Index)})
+		}
+	default:
+		return unsupportedPatchTypeError(mediaType)
+	}
+
+	// only the patchable members with their types are accepted
+	if encoded, err = json.Marshal(document); nil != err {
+		return internalError(err.Error())
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); nil != err {
+		return newApiError(http.StatusBadRequest, CodeInvalidParameter, "The patched document is invalid, "+err.Error(), nil)
+	}
+	return nil
+}
+
+func unsupportedPatchTypeError(contentType string) *apiError {
+	return newApiError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Unsupported patch type '"+contentType+"'", map[string]string{"accepted": acceptPatch})
+}
@@ -0,0 +1,539 @@
+package gitsapi
+
+import (
+	"container/heap"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+	"github.com/voodooEntity/gitsapi/src/config"
+)
+
+// Path is a way from the source to the target entity. Relations[i]
+// connects Entities[i] and Entities[i+1], it keeps its own direction, so
+// walking in or both it may point backwards. Cost is the sum of the
+// weights, the amount of relations if no weight is given.
+type Path struct {
+	Entities  []transport.TransportEntity
+	Relations []transport.TransportRelation
+	Length    int
+	Cost      float64
+}
+
+// PathResult lists the found paths ordered by their cost. Truncated is
+// set if listing all paths stopped at MAX_PATHS or MAX_PATH_STEPS.
+type PathResult struct {
+	Paths     []Path
+	Amount    int
+	Truncated bool `json:",omitempty"`
+}
+
+// graphPath is a path while searching, weights[i] belongs to steps[i]
+type graphPath struct {
+	nodes   []graphNode
+	steps   []graphStep
+	weights []float64
+	cost    float64
+}
+
+func (s *Server) registerPathRoutes() {
+	// Route: /v1/path
+	s.handleFunc("/v1/path", s.requireScope(auth.ScopeRead, handlePath))
+}
+
+func handlePath(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "GET" != r.Method {
+		respondError(methodNotAllowedError("GET"), w)
+		return
+	}
+
+	urlParams, apiErr := getRequiredUrlParams(map[string]string{"srcType": "", "srcID": "", "targetType": "", "targetID": ""}, r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	srcID, apiErr := getIntUrlParam(urlParams, "srcID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	targetID, apiErr := getIntUrlParam(urlParams, "targetID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	urlParams = getOptionalUrlParams(map[string]string{"k": "", "all": "", "maxDepth": "", "weight": ""}, urlParams, r)
+	limits, apiErr := getPathLimits(serverFromRequest(r).config, urlParams)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	if apiErr := authorize(r, auth.ActionRead, urlParams["srcType"], urlParams["targetType"]); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	walk, apiErr := newGraphWalk(storageFromRequest(r), grantFromRequest(r), r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	walk.weight = urlParams["weight"]
+	source, apiErr := walk.node(urlParams["srcType"], srcID)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	target, apiErr := walk.node(urlParams["targetType"], targetID)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	// the ends have been authorized, they may be of any of the types
+	walk.ends = []graphNode{source, target}
+
+	// both ends have to exist, otherwise there just would be no path
+	for _, node := range walk.ends {
+		if _, err := walk.entity(node); nil != err {
+			respondError(storageError(err), w)
+			return
+		}
+	}
+
+	var paths []graphPath
+	truncated := false
+	if "true" == urlParams["all"] {
+		paths, truncated, apiErr = walk.allPaths(source, target, limits)
+	} else {
+		paths, apiErr = walk.shortestPaths(source, target, limits.k, limits.maxDepth)
+	}
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	result := PathResult{Paths: []Path{}, Truncated: truncated}
+	for _, path := range paths {
+		translated, apiErr := walk.translatePath(path)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		result.Paths = append(result.Paths, translated)
+	}
+	result.Amount = len(result.Paths)
+	respondJson(result, 200, w)
+}
+
+// pathLimits bound the work of a path search, a maxDepth of 0 leaves the
+// length of shortest paths open
+type pathLimits struct {
+	k        int
+	maxDepth int
+	maxPaths int
+	// the amount of relations listing all paths may follow
+	maxSteps int
+}
+
+// getPathLimits reads k and maxDepth, they may not exceed MAX_PATHS and
+// MAX_PATH_DEPTH. All paths can only be listed up to a maxDepth, their
+// amount grows too fast otherwise. Even then a dense graph has too many
+// partial paths to walk them all, so MAX_PATH_STEPS bounds the search.
+func getPathLimits(conf *config.Config, urlParams map[string]string) (pathLimits, *apiError) {
+	limits := pathLimits{k: 1}
+	maxPaths, err := strconv.Atoi(conf.GetOptionalValue("MAX_PATHS", "100"))
+	if nil != err || 1 > maxPaths {
+		return limits, internalError("Invalid MAX_PATHS configured, integer of at least 1 expected")
+	}
+	maxPathDepth, err := strconv.Atoi(conf.GetOptionalValue("MAX_PATH_DEPTH", "10"))
+	if nil != err || 1 > maxPathDepth {
+		return limits, internalError("Invalid MAX_PATH_DEPTH configured, integer of at least 1 expected")
+	}
+	maxSteps, err := strconv.Atoi(conf.GetOptionalValue("MAX_PATH_STEPS", "100000"))
+	if nil != err || 1 > maxSteps {
+		return limits, internalError("Invalid MAX_PATH_STEPS configured, integer of at least 1 expected")
+	}
+	limits.maxPaths = maxPaths
+	limits.maxSteps = maxSteps
+
+	var apiErr *apiError
+	if "" != urlParams["k"] {
+		if limits.k, apiErr = getIntUrlParam(urlParams, "k"); nil != apiErr {
+			return limits, apiErr
+		}
+		if 1 > limits.k || maxPaths < limits.k {
+			return limits, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param 'k' given, it has to be between 1 and "+strconv.Itoa(maxPaths), map[string]string{"param": "k"})
+		}
+	}
+	if "" != urlParams["maxDepth"] {
+		if limits.maxDepth, apiErr = getIntUrlParam(urlParams, "maxDepth"); nil != apiErr {
+			return limits, apiErr
+		}
+		if 1 > limits.maxDepth || maxPathDepth < limits.maxDepth {
+			return limits, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param 'maxDepth' given, it has to be between 1 and "+strconv.Itoa(maxPathDepth), map[string]string{"param": "maxDepth"})
+		}
+	}
+	if "" != urlParams["all"] && "true" != urlParams["all"] && "false" != urlParams["all"] {
+		return limits, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param 'all' given, expected true or false", map[string]string{"param": "all"})
+	}
+	if "true" == urlParams["all"] {
+		if 0 == limits.maxDepth {
+			return limits, newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing url param 'maxDepth', it is required to list all paths", map[string]string{"param": "maxDepth"})
+		}
+		if "" != urlParams["k"] {
+			return limits, newApiError(http.StatusBadRequest, CodeInvalidParameter, "The params 'k' and 'all' can't be combined", map[string]string{"param": "k"})
+		}
+	}
+	return limits, nil
+}
+
+// stepWeight reads the weight of a relation. Relations without the
+// property weigh 1 like all relations do if no weight is given.
+func (walk *graphWalk) stepWeight(step graphStep) (float64, *apiError) {
+	if "" == walk.weight {
+		return 1, nil
+	}
+	value, ok := step.relation.Properties[walk.weight]
+	if !ok {
+		return 1, nil
+	}
+	weight, err := strconv.ParseFloat(value, 64)
+	if nil != err || 0 > weight || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 0, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Relation weight '"+walk.weight+"' has to be a non negative number, got '"+value+"'", map[string]string{
+			"param":      "weight",
+			"sourceType": step.relation.SourceType,
+			"sourceID":   strconv.Itoa(step.relation.SourceID),
+			"targetType": step.relation.TargetType,
+			"targetID":   strconv.Itoa(step.relation.TargetID),
+		})
+	}
+	return weight, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// shortest paths
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// shortestPath searches by Dijkstra, which is a breadth first search as
+// long as all relations weigh 1. With a maxDepth the hops are part of the
+// search state, a cheaper path could be too long otherwise. Nodes and
+// relations can be excluded for the spur paths of shortestPaths.
+func (walk *graphWalk) shortestPath(source graphNode, target graphNode, maxDepth int, excludedNodes map[graphNode]bool, excludedRelations map[[2]graphNode]bool) (*graphPath, *apiError) {
+	type previous struct {
+		state  pathState
+		step   graphStep
+		weight float64
+	}
+	start := pathState{node: source}
+	costs := map[pathState]float64{start: 0}
+	hops := map[pathState]int{start: 0}
+	previousStates := make(map[pathState]previous)
+	done := make(map[pathState]bool)
+	queue := &pathQueue{}
+	heap.Push(queue, &pathQueueItem{state: start})
+
+	for 0 < queue.Len() {
+		current := heap.Pop(queue).(*pathQueueItem).state
+		if done[current] {
+			continue
+		}
+		done[current] = true
+
+		if target == current.node {
+			// walk back the previous states to build the path
+			path := &graphPath{cost: costs[current]}
+			for at := current; at != start; at = previousStates[at].state {
+				prev := previousStates[at]
+				path.nodes = append([]graphNode{at.node}, path.nodes...)
+				path.steps = append([]graphStep{prev.step}, path.steps...)
+				path.weights = append([]float64{prev.weight}, path.weights...)
+			}
+			path.nodes = append([]graphNode{source}, path.nodes...)
+			return path, nil
+		}
+		if 0 < maxDepth && maxDepth == current.hops {
+			continue
+		}
+
+		steps, apiErr := walk.steps(current.node)
+		if nil != apiErr {
+			return nil, apiErr
+		}
+		for _, step := range steps {
+			if excludedNodes[step.node] || excludedRelations[step.key] {
+				continue
+			}
+			weight, apiErr := walk.stepWeight(step)
+			if nil != apiErr {
+				return nil, apiErr
+			}
+			next := pathState{node: step.node}
+			if 0 < maxDepth {
+				next.hops = current.hops + 1
+			}
+			if done[next] {
+				continue
+			}
+			cost := costs[current] + weight
+			if known, ok := costs[next]; ok && (known < cost || known == cost && hops[next] <= hops[current]+1) {
+				continue
+			}
+			costs[next] = cost
+			hops[next] = hops[current] + 1
+			previousStates[next] = previous{state: current, step: step, weight: weight}
+			heap.Push(queue, &pathQueueItem{state: next, cost: cost, hops: hops[next]})
+		}
+	}
+	return nil, nil
+}
+
+// shortestPaths finds the k shortest simple paths by Yen's algorithm.
+// Every further path branches off a known one at a spur node, the part
+// before it is kept and the rest is searched without the nodes of that
+// part and without the relations the known paths leave the spur node by.
+func (walk *graphWalk) shortestPaths(source graphNode, target graphNode, k int, maxDepth int) ([]graphPath, *apiError) {
+	first, apiErr := walk.shortestPath(source, target, maxDepth, nil, nil)
+	if nil != apiErr || nil == first {
+		return []graphPath{}, apiErr
+	}
+	found := []graphPath{*first}
+	candidates := []graphPath{}
+	known := map[string]bool{first.key(): true}
+
+	for len(found) < k {
+		last := found[len(found)-1]
+		for i := 0; i < len(last.nodes)-1; i++ {
+			root := last.prefix(i)
+			excludedNodes := make(map[graphNode]bool)
+			for _, node := range root.nodes[:i] {
+				excludedNodes[node] = true
+			}
+			excludedRelations := make(map[[2]graphNode]bool)
+			for _, path := range found {
+				if len(path.nodes) > i+1 && path.prefix(i).key() == root.key() {
+					excludedRelations[path.steps[i].key] = true
+				}
+			}
+
+			spurDepth := 0
+			if 0 < maxDepth {
+				spurDepth = maxDepth - i
+			}
+			spur, apiErr := walk.shortestPath(last.nodes[i], target, spurDepth, excludedNodes, excludedRelations)
+			if nil != apiErr {
+				return nil, apiErr
+			}
+			if nil == spur {
+				continue
+			}
+			candidate := root.join(*spur)
+			if !known[candidate.key()] {
+				known[candidate.key()] = true
+				candidates = append(candidates, candidate)
+			}
+		}
+		if 0 == len(candidates) {
+			break
+		}
+		// the cheapest candidate is the next path, fewer hops first on equal cost
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].cost != candidates[j].cost {
+				return candidates[i].cost < candidates[j].cost
+			}
+			return len(candidates[i].steps) < len(candidates[j].steps)
+		})
+		found = append(found, candidates[0])
+		candidates = candidates[1:]
+	}
+	return found, nil
+}
+
+// allPaths lists every simple path up to maxDepth by depth first search,
+// ordered like the shortest paths. The search stops once maxPaths are
+// found or maxSteps relations have been followed, which is told by the
+// returned bool.
+func (walk *graphWalk) allPaths(source graphNode, target graphNode, limits pathLimits) ([]graphPath, bool, *apiError) {
+	maxDepth, maxPaths := limits.maxDepth, limits.maxPaths
+	found := []graphPath{}
+	current := graphPath{nodes: []graphNode{source}}
+	visited := map[graphNode]bool{source: true}
+	truncated := false
+	budget := limits.maxSteps
+
+	var search func(node graphNode) *apiError
+	search = func(node graphNode) *apiError {
+		if target == node {
+			// a further path exists but it doesn't fit anymore
+			if maxPaths == len(found) {
+				truncated = true
+				return nil
+			}
+			found = append(found, current.prefix(len(current.steps)))
+			return nil
+		}
+		if maxDepth == len(current.steps) {
+			return nil
+		}
+		steps, apiErr := walk.steps(node)
+		if nil != apiErr {
+			return apiErr
+		}
+		for _, step := range steps {
+			if truncated {
+				return nil
+			}
+			// every followed relation counts, also the ones leading
+			// nowhere, those are the expensive part of a dense graph
+			if 0 == budget {
+				truncated = true
+				return nil
+			}
+			budget--
+			if visited[step.node] {
+				continue
+			}
+			weight, apiErr := walk.stepWeight(step)
+			if nil != apiErr {
+				return apiErr
+			}
+			visited[step.node] = true
+			current.nodes = append(current.nodes, step.node)
+			current.steps = append(current.steps, step)
+			current.weights = append(current.weights, weight)
+			current.cost += weight
+			if apiErr := search(step.node); nil != apiErr {
+				return apiErr
+			}
+			current.cost -= weight
+			current.nodes = current.nodes[:len(current.nodes)-1]
+			current.steps = current.steps[:len(current.steps)-1]
+			current.weights = current.weights[:len(current.weights)-1]
+			delete(visited, step.node)
+		}
+		return nil
+	}
+	if apiErr := search(source); nil != apiErr {
+		return nil, false, apiErr
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].cost != found[j].cost {
+			return found[i].cost < found[j].cost
+		}
+		return len(found[i].steps) < len(found[j].steps)
+	})
+	return found, truncated, nil
+}
+
+// prefix copies the first hops of the path
+func (path graphPath) prefix(hops int) graphPath {
+	ret := graphPath{
+		nodes:   append([]graphNode{}, path.nodes[:hops+1]...),
+		steps:   append([]graphStep{}, path.steps[:hops]...),
+		weights: append([]float64{}, path.weights[:hops]...),
+	}
+	for _, weight := range ret.weights {
+		ret.cost += weight
+	}
+	return ret
+}
+
+// join appends a path starting at the last node of this one
+func (path graphPath) join(other graphPath) graphPath {
+	path.nodes = append(path.nodes, other.nodes[1:]...)
+	path.steps = append(path.steps, other.steps...)
+	path.weights = append(path.weights, other.weights...)
+	path.cost += other.cost
+	return path
+}
+
+// key identifies the path by the relations it takes, two entities can be
+// connected in both directions
+func (path graphPath) key() string {
+	ret := strconv.Itoa(path.nodes[0].typeID) + ":" + strconv.Itoa(path.nodes[0].id)
+	for _, step := range path.steps {
+		ret += "|" + strconv.Itoa(step.key[0].typeID) + ":" + strconv.Itoa(step.key[0].id) + ">" + strconv.Itoa(step.key[1].typeID) + ":" + strconv.Itoa(step.key[1].id)
+	}
+	return ret
+}
+
+func (walk *graphWalk) translatePath(path graphPath) (Path, *apiError) {
+	ret := Path{
+		Entities:  []transport.TransportEntity{},
+		Relations: []transport.TransportRelation{},
+		Length:    len(path.steps),
+		Cost:      path.cost,
+	}
+	for _, node := range path.nodes {
+		entity, err := walk.entity(node)
+		if nil != err {
+			return Path{}, storageError(err)
+		}
+		ret.Entities = append(ret.Entities, entity)
+	}
+	for _, step := range path.steps {
+		ret.Relations = append(ret.Relations, step.relation)
+	}
+	return ret, nil
+}
+
+// pathState is a node reached while searching, with a maxDepth the
+// hops it took are part of the state
+type pathState struct {
+	node graphNode
+	hops int
+}
+
+// pathQueue is a min heap by cost. Equal costs prefer fewer hops, which
+// keeps paths simple even over relations weighing 0, and then keep their
+// insertion order so searches are repeatable.
+type pathQueue struct {
+	items []*pathQueueItem
+	count int
+}
+
+type pathQueueItem struct {
+	state pathState
+	cost  float64
+	hops  int
+	order int
+}
+
+func (q *pathQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *pathQueue) Less(i, j int) bool {
+	if q.items[i].cost != q.items[j].cost {
+		return q.items[i].cost < q.items[j].cost
+	}
+	if q.items[i].hops != q.items[j].hops {
+		return q.items[i].hops < q.items[j].hops
+	}
+	return q.items[i].order < q.items[j].order
+}
+
+func (q *pathQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *pathQueue) Push(x interface{}) {
+	item := x.(*pathQueueItem)
+	item.order = q.count
+	q.count++
+	q.items = append(q.items, item)
+}
+
+func (q *pathQueue) Pop() interface{} {
+	last := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return last
+}
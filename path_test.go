@@ -0,0 +1,160 @@
+package gitsapi
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/voodooEntity/gits/src/transport"
+)
+
+// newPathFixture creates five persons, the paths out of 1 to 5 are
+//
+//	1-2-3-5 costs 3 with 3 hops
+//	1-3-5   costs 4
+//	1-2-5   costs 6
+//	1-4-5   costs 7
+//	1-5     costs 10 with 1 hop
+//
+// by the weight property w. The property bad is no valid weight.
+func newPathFixture(t *testing.T) string {
+	t.Helper()
+	name := "path-" + t.Name()
+	g, apiErr := newStorageSet().create(name)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	for i := 1; i <= 5; i++ {
+		if _, apiErr := createEntity(g, transport.TransportEntity{Type: "Person", Value: strconv.Itoa(i)}); nil != apiErr {
+			t.Fatal(apiErr)
+		}
+	}
+	for _, relation := range []struct {
+		source int
+		target int
+		weight string
+	}{
+		{1, 2, "1"}, {2, 3, "1"}, {3, 5, "1"}, {1, 3, "3"}, {2, 5, "5"}, {1, 4, "5"}, {4, 5, "2"}, {1, 5, "10"},
+	} {
+		properties := map[string]string{"w": relation.weight}
+		if 4 == relation.target {
+			properties["bad"] = "-1"
+		}
+		if 3 == relation.source {
+			properties["text"] = "heavy"
+		}
+		if _, apiErr := createRelation(g, transport.TransportRelation{SourceType: "Person", SourceID: relation.source, TargetType: "Person", TargetID: relation.target, Properties: properties}); nil != apiErr {
+			t.Fatal(apiErr)
+		}
+	}
+	return name
+}
+
+// pathIDs turns the entities of a path into a string like 1-2-5
+func pathIDs(path Path) string {
+	ids := []string{}
+	for _, entity := range path.Entities {
+		ids = append(ids, strconv.Itoa(entity.ID))
+	}
+	return strings.Join(ids, "-")
+}
+
+func TestPath(t *testing.T) {
+	storage := newPathFixture(t)
+	for _, tc := range []struct {
+		name      string
+		config    map[string]string
+		query     string
+		status    int
+		paths     []string
+		truncated bool
+	}{
+		{"shortest by hops", nil, "", 200, []string{"1-5"}, false},
+		{"shortest by weight", nil, "&weight=w", 200, []string{"1-2-3-5"}, false},
+		{"k shortest", nil, "&weight=w&k=3", 200, []string{"1-2-3-5", "1-3-5", "1-2-5"}, false},
+		{"k beyond the amount of paths", nil, "&weight=w&k=10", 200, []string{"1-2-3-5", "1-3-5", "1-2-5", "1-4-5", "1-5"}, false},
+		// the cheapest way to 3 takes 2 hops, it can't be extended to 5
+		{"shortest within maxDepth", nil, "&weight=w&maxDepth=2", 200, []string{"1-3-5"}, false},
+		{"k shortest within maxDepth", nil, "&weight=w&k=10&maxDepth=2", 200, []string{"1-3-5", "1-2-5", "1-4-5", "1-5"}, false},
+		{"maxDepth of one hop", nil, "&weight=w&k=10&maxDepth=1", 200, []string{"1-5"}, false},
+		{"excluded types", nil, "&weight=w&types=Company", 200, []string{"1-5"}, false},
+		{"against the direction", nil, "&direction=in", 200, []string{}, false},
+		{"all", nil, "&weight=w&all=true&maxDepth=3", 200, []string{"1-2-3-5", "1-3-5", "1-2-5", "1-4-5", "1-5"}, false},
+		{"all within maxDepth", nil, "&weight=w&all=true&maxDepth=2", 200, []string{"1-3-5", "1-2-5", "1-4-5", "1-5"}, false},
+		{"all cut by MAX_PATHS", map[string]string{"MAX_PATHS": "2"}, "&all=true&maxDepth=3", 200, nil, true},
+		{"all cut by MAX_PATH_STEPS", map[string]string{"MAX_PATH_STEPS": "3"}, "&all=true&maxDepth=3", 200, nil, true},
+		{"all without maxDepth", nil, "&all=true", 400, nil, false},
+		{"k combined with all", nil, "&all=true&maxDepth=2&k=2", 400, nil, false},
+		{"k beyond MAX_PATHS", map[string]string{"MAX_PATHS": "2"}, "&k=3", 400, nil, false},
+		{"maxDepth beyond MAX_PATH_DEPTH", nil, "&maxDepth=11", 400, nil, false},
+		{"negative weight", nil, "&weight=bad", 400, nil, false},
+		{"non numeric weight", nil, "&weight=text&k=10", 400, nil, false},
+		{"negative weight while listing all", nil, "&weight=bad&all=true&maxDepth=2", 400, nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ts := newTestServer(t, tc.config)
+			var result PathResult
+			query := "&direction=out" + tc.query
+			if strings.Contains(tc.query, "direction") {
+				query = tc.query
+			}
+			resp := call(t, ts, "GET", "/v1/path?srcType=Person&srcID=1&targetType=Person&targetID=5"+query, map[string]string{"Storage": storage}, "", &result)
+			if tc.status != resp.StatusCode {
+				t.Fatalf("expected status %d, got %d", tc.status, resp.StatusCode)
+			}
+			if 200 != tc.status {
+				return
+			}
+			if tc.truncated != result.Truncated {
+				t.Errorf("expected truncated %v, got %v", tc.truncated, result.Truncated)
+			}
+			if len(result.Paths) != result.Amount {
+				t.Errorf("amount %d doesn't match %d paths", result.Amount, len(result.Paths))
+			}
+
+			seen := make(map[string]bool)
+			got := []string{}
+			for i, path := range result.Paths {
+				ids := pathIDs(path)
+				got = append(got, ids)
+				if seen[ids] {
+					t.Errorf("path %s is listed twice", ids)
+				}
+				seen[ids] = true
+				if 0 < i && result.Paths[i-1].Cost > path.Cost {
+					t.Errorf("path %s is cheaper than the one before", ids)
+				}
+
+				// simple, connected and from 1 to 5
+				visited := make(map[int]bool)
+				for _, entity := range path.Entities {
+					if visited[entity.ID] {
+						t.Errorf("path %s visits %d twice", ids, entity.ID)
+					}
+					visited[entity.ID] = true
+				}
+				if !strings.HasPrefix(ids, "1-") || !strings.HasSuffix(ids, "-5") {
+					t.Errorf("path %s doesn't lead from 1 to 5", ids)
+				}
+				if path.Length != len(path.Relations) || len(path.Entities) != len(path.Relations)+1 {
+					t.Errorf("path %s has %d entities, %d relations and length %d", ids, len(path.Entities), len(path.Relations), path.Length)
+					continue
+				}
+				for j, relation := range path.Relations {
+					if path.Entities[j].ID != relation.SourceID || path.Entities[j+1].ID != relation.TargetID {
+						t.Errorf("relation %d of path %s doesn't connect its entities", j, ids)
+					}
+				}
+			}
+			if tc.truncated {
+				if maxPaths, ok := tc.config["MAX_PATHS"]; ok && maxPaths != strconv.Itoa(len(got)) {
+					t.Errorf("expected %s paths before truncating, got %v", maxPaths, got)
+				}
+				return
+			}
+			if strings.Join(tc.paths, " ") != strings.Join(got, " ") {
+				t.Errorf("expected %v, got %v", tc.paths, got)
+			}
+		})
+	}
+}
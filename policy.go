@@ -0,0 +1,237 @@
+package gitsapi
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/query"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// loadPolicy enables per storage and entity type authorization
+// if a POLICY_FILE is configured
+func (s *Server) loadPolicy() error {
+	policyFile := s.config.GetOptionalValue("POLICY_FILE", "")
+	if "" == policyFile {
+		return nil
+	}
+	policy, err := auth.LoadPolicy(policyFile)
+	if nil != err {
+		return errors.New("Could not load policy file: " + err.Error())
+	}
+	s.policy = policy
+	archivist.Info("> Authorization policy enabled")
+	return nil
+}
+
+// grantFromRequest returns the permissions the middleware resolved
+// for the request, nil means everything is allowed
+func grantFromRequest(r *http.Request) *auth.Grant {
+	grant, _ := r.Context().Value(grantContextKey).(*auth.Grant)
+	return grant
+}
+
+// authorize checks if the action may be applied to all given entity types
+func authorize(r *http.Request, action string, entityTypes ...string) *apiError {
+	return authorizeGrant(grantFromRequest(r), action, entityTypes...)
+}
+
+// authorizeGrant is authorize for operations running apart from their
+// request, a nil grant allows everything
+func authorizeGrant(grant *auth.Grant, action string, entityTypes ...string) *apiError {
+	for _, entityType := range entityTypes {
+		// empty types are rejected by the storage operations themselves
+		if "" != entityType && !grant.Allows(action, entityType) {
+			return forbiddenActionError(action, entityType)
+		}
+	}
+	return nil
+}
+
+func forbiddenActionError(action string, entityType string) *apiError {
+	return newApiError(http.StatusForbidden, CodeForbidden, "Action '"+action+"' on entity type '"+entityType+"' is not granted", map[string]string{"action": action, "type": entityType})
+}
+
+// actionByMethod maps the http methods of the /v2 routes onto policy actions
+func actionByMethod(r *http.Request) string {
+	switch r.Method {
+	case "POST":
+		return auth.ActionCreate
+	case "PUT", "PATCH":
+		return auth.ActionUpdate
+	case "DELETE":
+		return auth.ActionDelete
+	}
+	return auth.ActionRead
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// result filtering, entities of types the caller may
+// not read are stripped instead of being reported
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func filterTransport(grant *auth.Grant, data transport.Transport) transport.Transport {
+	if grant.Unrestricted(auth.ActionRead) {
+		return data
+	}
+	if nil != data.Entities {
+		data.Entities = filterEntities(grant, data.Entities)
+	}
+	if nil != data.Relations {
+		data.Relations = filterRelations(grant, data.Relations)
+	}
+	if 0 < data.Amount && nil != data.Entities {
+		data.Amount = len(data.Entities)
+	}
+	return data
+}
+
+func filterEntities(grant *auth.Grant, entities []transport.TransportEntity) []transport.TransportEntity {
+	if grant.Unrestricted(auth.ActionRead) {
+		return entities
+	}
+	ret := []transport.TransportEntity{}
+	for _, entity := range entities {
+		if !grant.Allows(auth.ActionRead, entity.Type) {
+			continue
+		}
+		if nil != entity.ChildRelations {
+			entity.ChildRelations = filterRelations(grant, entity.ChildRelations)
+		}
+		if nil != entity.ParentRelations {
+			entity.ParentRelations = filterRelations(grant, entity.ParentRelations)
+		}
+		ret = append(ret, entity)
+	}
+	return ret
+}
+
+// filterRelations drops relations with an end of a forbidden type and
+// filters the nested target entities
+func filterRelations(grant *auth.Grant, relations []transport.TransportRelation) []transport.TransportRelation {
+	if grant.Unrestricted(auth.ActionRead) {
+		return relations
+	}
+	ret := []transport.TransportRelation{}
+	for _, relation := range relations {
+		if ("" != relation.SourceType && !grant.Allows(auth.ActionRead, relation.SourceType)) ||
+			("" != relation.TargetType && !grant.Allows(auth.ActionRead, relation.TargetType)) ||
+			("" != relation.Target.Type && !grant.Allows(auth.ActionRead, relation.Target.Type)) {
+			continue
+		}
+		if "" != relation.Target.Type {
+			relation.Target = filterEntities(grant, []transport.TransportEntity{relation.Target})[0]
+		}
+		ret = append(ret, relation)
+	}
+	return ret
+}
+
+// filterEntityTypes reduces an id -> type name map to the readable types
+func filterEntityTypes(grant *auth.Grant, entityTypes map[int]string) map[int]string {
+	if grant.Unrestricted(auth.ActionRead) {
+		return entityTypes
+	}
+	ret := make(map[int]string)
+	for id, entityType := range entityTypes {
+		if grant.Allows(auth.ActionRead, entityType) {
+			ret[id] = entityType
+		}
+	}
+	return ret
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// queries and mapped data
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// restrictQuery checks the root pool of a query against the action its
+// method implies. Unreadable types are removed from all pools used for
+// reading, so they can neither be returned nor influence the matching.
+func restrictQuery(grant *auth.Grant, qry *query.Query) *apiError {
+	if nil == grant {
+		return nil
+	}
+
+	var actions []string
+	switch qry.Method {
+	case query.METHOD_UPDATE:
+		actions = []string{auth.ActionUpdate}
+	case query.METHOD_UPSERT:
+		actions = []string{auth.ActionCreate, auth.ActionUpdate}
+	case query.METHOD_DELETE:
+		actions = []string{auth.ActionDelete}
+	case query.METHOD_LINK, query.METHOD_UNLINK:
+		// linking changes the relations of both ends
+		actions = []string{auth.ActionUpdate}
+		for _, subQuery := range qry.Map {
+			if apiErr := requirePool(grant, subQuery.Pool, actions); nil != apiErr {
+				return apiErr
+			}
+		}
+	}
+
+	if nil == actions {
+		qry.Pool = readablePool(grant, qry.Pool)
+	} else if apiErr := requirePool(grant, qry.Pool, actions); nil != apiErr {
+		return apiErr
+	}
+	for i := range qry.Map {
+		restrictSubQuery(grant, &qry.Map[i])
+	}
+	return nil
+}
+
+func restrictSubQuery(grant *auth.Grant, qry *query.Query) {
+	qry.Pool = readablePool(grant, qry.Pool)
+	for i := range qry.Map {
+		restrictSubQuery(grant, &qry.Map[i])
+	}
+}
+
+func requirePool(grant *auth.Grant, pool []string, actions []string) *apiError {
+	for _, entityType := range pool {
+		for _, action := range actions {
+			if !grant.Allows(action, entityType) {
+				return forbiddenActionError(action, entityType)
+			}
+		}
+	}
+	return nil
+}
+
+func readablePool(grant *auth.Grant, pool []string) []string {
+	ret := []string{}
+	for _, entityType := range pool {
+		if grant.Allows(auth.ActionRead, entityType) {
+			ret = append(ret, entityType)
+		}
+	}
+	return ret
+}
+
+// authorizeMapping checks all entities of a mapJson body, new entities
+// need the create action while referenced existing ones get new
+// relations and therefore need the update action
+func authorizeMapping(grant *auth.Grant, entity transport.TransportEntity) *apiError {
+	action := auth.ActionCreate
+	if 0 < entity.ID {
+		action = auth.ActionUpdate
+	}
+	if !grant.Allows(action, entity.Type) {
+		return forbiddenActionError(action, entity.Type)
+	}
+	for _, relation := range entity.ChildRelations {
+		if apiErr := authorizeMapping(grant, relation.Target); nil != apiErr {
+			return apiErr
+		}
+	}
+	for _, relation := range entity.ParentRelations {
+		if apiErr := authorizeMapping(grant, relation.Target); nil != apiErr {
+			return apiErr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package gitsapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/rdf"
+)
+
+// defaultRDFBase is used if RDF_BASE_IRI isn't configured
+const defaultRDFBase = "urn:gits:"
+
+// rdfVocabulary is the base IRI all entity, type and predicate IRIs
+// are built of
+type rdfVocabulary string
+
+// loadRDFBase reads RDF_BASE_IRI. The base has to be absolute and end
+// with '/', '#' or ':' so it can be used as JSON-LD prefix.
+func (s *Server) loadRDFBase() error {
+	base := s.config.GetOptionalValue("RDF_BASE_IRI", defaultRDFBase)
+	if _, err := url.Parse(base); nil != err || !strings.Contains(base, ":") || !strings.ContainsAny(base[len(base)-1:], "/#:") {
+		return errors.New("Invalid RDF_BASE_IRI '" + base + "', expected an absolute IRI ending with '/', '#' or ':'")
+	}
+	s.rdfBase = rdfVocabulary(base)
+	return nil
+}
+
+// the IRIs of the gits vocabulary, names are path escaped
+func (base rdfVocabulary) entityIRI(typeStr string, id int) string {
+	return string(base) + "entity/" + url.PathEscape(typeStr) + "/" + strconv.Itoa(id)
+}
+
+func (base rdfVocabulary) typeIRI(typeStr string) string {
+	return string(base) + "type/" + url.PathEscape(typeStr)
+}
+
+func (base rdfVocabulary) propertyIRI(key string) string {
+	return string(base) + "property/" + url.PathEscape(key)
+}
+
+func (base rdfVocabulary) relationIRI(context string) string {
+	return string(base) + "relation/" + url.PathEscape(context)
+}
+
+func (base rdfVocabulary) valueIRI() string {
+	return string(base) + "value"
+}
+
+func (base rdfVocabulary) contextIRI() string {
+	return string(base) + "context"
+}
+
+// rdfStatement is a predicate and object of a subject
+type rdfStatement struct {
+	predicate string
+	object    string
+	literal   bool
+}
+
+// rdfSerializer writes all statements of one subject at once
+type rdfSerializer interface {
+	begin() error
+	subject(subject string, statements []rdfStatement) error
+	end() error
+}
+
+// rdfExportWriter turns the exported records into statements, types
+// aren't written since empty types have no statements
+type rdfExportWriter struct {
+	base       rdfVocabulary
+	out        rdfSerializer
+	w          *bufio.Writer
+	underlying io.Writer
+	pending    int
+	started    bool
+}
+
+func newRDFExportWriter(w io.Writer, base rdfVocabulary, newSerializer func(w *bufio.Writer, base rdfVocabulary) rdfSerializer) *rdfExportWriter {
+	buffered := bufio.NewWriter(w)
+	return &rdfExportWriter{base: base, out: newSerializer(buffered, base), w: buffered, underlying: w}
+}
+
+func (rw *rdfExportWriter) start() error {
+	if rw.started {
+		return nil
+	}
+	rw.started = true
+	return rw.out.begin()
+}
+
+func (rw *rdfExportWriter) EntityType(name string) error {
+	return rw.start()
+}
+
+func (rw *rdfExportWriter) Entity(entity transport.TransportEntity) error {
+	if err := rw.start(); nil != err {
+		return err
+	}
+	statements := []rdfStatement{
+		{predicate: rdf.RDFType, object: rw.base.typeIRI(entity.Type)},
+		{predicate: rw.base.valueIRI(), object: entity.Value, literal: true},
+	}
+	if "" != entity.Context {
+		statements = append(statements, rdfStatement{predicate: rw.base.contextIRI(), object: entity.Context, literal: true})
+	}
+	keys := make([]string, 0, len(entity.Properties))
+	for key := range entity.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		statements = append(statements, rdfStatement{predicate: rw.base.propertyIRI(key), object: entity.Properties[key], literal: true})
+	}
+	return rw.write(rw.base.entityIRI(entity.Type, entity.ID), statements)
+}
+
+// Relation writes the relation as predicate named by its context, RDF
+// has no place for the relation properties so they are left out
+func (rw *rdfExportWriter) Relation(relation transport.TransportRelation) error {
+	if err := rw.start(); nil != err {
+		return err
+	}
+	return rw.write(rw.base.entityIRI(relation.SourceType, relation.SourceID), []rdfStatement{
+		{predicate: rw.base.relationIRI(relation.Context), object: rw.base.entityIRI(relation.TargetType, relation.TargetID)},
+	})
+}
+
+func (rw *rdfExportWriter) write(subject string, statements []rdfStatement) error {
+	if err := rw.out.subject(subject, statements); nil != err {
+		return err
+	}
+	if rw.pending++; exportFlushInterval <= rw.pending {
+		return rw.flush()
+	}
+	return nil
+}
+
+func (rw *rdfExportWriter) flush() error {
+	rw.pending = 0
+	if err := rw.w.Flush(); nil != err {
+		return err
+	}
+	if flusher, ok := rw.underlying.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+func (rw *rdfExportWriter) Close() error {
+	if err := rw.start(); nil != err {
+		return err
+	}
+	if err := rw.out.end(); nil != err {
+		return err
+	}
+	return rw.flush()
+}
+
+// rdfLiteral quotes a literal for N-Triples and Turtle
+func rdfLiteral(value string) string {
+	var ret strings.Builder
+	ret.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			ret.WriteString(`\"`)
+		case '\\':
+			ret.WriteString(`\\`)
+		case '\n':
+			ret.WriteString(`\n`)
+		case '\r':
+			ret.WriteString(`\r`)
+		case '\t':
+			ret.WriteString(`\t`)
+		default:
+			if r < 0x20 || 0x7f == r {
+				ret.WriteString(`\u` + strings.ToUpper(strconv.FormatInt(int64(0x10000+r), 16)[1:]))
+				continue
+			}
+			ret.WriteRune(r)
+		}
+	}
+	ret.WriteByte('"')
+	return ret.String()
+}
+
+func rdfObject(statement rdfStatement) string {
+	if statement.literal {
+		return rdfLiteral(statement.object)
+	}
+	return "<" + statement.object + ">"
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// N-Triples, one statement per line
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type ntriplesSerializer struct {
+	w *bufio.Writer
+}
+
+func newNTriplesSerializer(w *bufio.Writer, base rdfVocabulary) rdfSerializer {
+	return &ntriplesSerializer{w: w}
+}
+
+func (ns *ntriplesSerializer) begin() error {
+	return nil
+}
+
+func (ns *ntriplesSerializer) subject(subject string, statements []rdfStatement) error {
+	for _, statement := range statements {
+		if _, err := ns.w.WriteString("<" + subject + "> <" + statement.predicate + "> " + rdfObject(statement) + " .\n"); nil != err {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ns *ntriplesSerializer) end() error {
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// Turtle, statements grouped by subject
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+type turtleSerializer struct {
+	w       *bufio.Writer
+	started bool
+}
+
+func newTurtleSerializer(w *bufio.Writer, base rdfVocabulary) rdfSerializer {
+	return &turtleSerializer{w: w}
+}
+
+func (ts *turtleSerializer) begin() error {
+	return nil
+}
+
+func (ts *turtleSerializer) subject(subject string, statements []rdfStatement) error {
+	if ts.started {
+		ts.w.WriteString("\n")
+	}
+	ts.started = true
+	ts.w.WriteString("<" + subject + ">")
+	for i, statement := range statements {
+		if 0 < i {
+			ts.w.WriteString(" ;\n   ")
+		}
+		if rdf.RDFType == statement.predicate {
+			ts.w.WriteString(" a ")
+		} else {
+			ts.w.WriteString(" <" + statement.predicate + "> ")
+		}
+		ts.w.WriteString(rdfObject(statement))
+	}
+	_, err := ts.w.WriteString(" .\n")
+	return err
+}
+
+func (ts *turtleSerializer) end() error {
+	return nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// JSON-LD, one node object per subject in @graph
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// jsonldPrefix is the prefix the base IRI is declared as
+const jsonldPrefix = "gits"
+
+type jsonldSerializer struct {
+	w     *bufio.Writer
+	base  string
+	first bool
+}
+
+func newJSONLDSerializer(w *bufio.Writer, base rdfVocabulary) rdfSerializer {
+	return &jsonldSerializer{w: w, base: string(base), first: true}
+}
+
+// compact shortens IRIs of the gits vocabulary by the prefix
+func (js *jsonldSerializer) compact(iri string) string {
+	if strings.HasPrefix(iri, js.base) {
+		return jsonldPrefix + ":" + strings.TrimPrefix(iri, js.base)
+	}
+	return iri
+}
+
+func (js *jsonldSerializer) begin() error {
+	context, err := json.Marshal(map[string]string{jsonldPrefix: js.base})
+	if nil != err {
+		return err
+	}
+	_, err = js.w.WriteString(`{"@context":` + string(context) + `,"@graph":[`)
+	return err
+}
+
+// subject writes a node object, nodes with the same @id are merged by
+// JSON-LD processors so relations can be written separately
+func (js *jsonldSerializer) subject(subject string, statements []rdfStatement) error {
+	node := map[string]interface{}{"@id": js.compact(subject)}
+	for _, statement := range statements {
+		switch {
+		case rdf.RDFType == statement.predicate:
+			node["@type"] = js.compact(statement.object)
+		case statement.literal:
+			node[js.compact(statement.predicate)] = statement.object
+		default:
+			node[js.compact(statement.predicate)] = map[string]string{"@id": js.compact(statement.object)}
+		}
+	}
+	data, err := json.Marshal(node)
+	if nil != err {
+		return err
+	}
+	if !js.first {
+		js.w.WriteString(",")
+	}
+	js.first = false
+	js.w.WriteString("\n")
+	_, err = js.w.Write(data)
+	return err
+}
+
+func (js *jsonldSerializer) end() error {
+	_, err := js.w.WriteString("\n]}\n")
+	return err
+}
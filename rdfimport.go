@@ -0,0 +1,217 @@
+package gitsapi
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+	"github.com/voodooEntity/gitsapi/src/rdf"
+)
+
+// rdfDefaultType is the type of resources without rdf:type
+const rdfDefaultType = "Resource"
+
+// rdfResource collects the statements of a subject until it gets
+// created as entity
+type rdfResource struct {
+	iri       string
+	line      int
+	entity    transport.TransportEntity
+	typed     bool
+	relations []rdf.Triple
+}
+
+func handleImportRDF(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "POST" != r.Method {
+		respondError(methodNotAllowedError("POST"), w)
+		return
+	}
+	defer r.Body.Close()
+
+	// unlike NDJSON the statements of a subject can be spread over the
+	// whole document, so it is parsed completely first
+	base := serverFromRequest(r).rdfBase
+	triples, err := rdf.Parse(r.Body, string(base))
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+
+	imp := newImporter(r)
+	resources, order := base.collectResources(triples)
+	created := make(map[string]int)
+	for _, iri := range order {
+		resource := resources[iri]
+		result := ImportResult{Line: resource.line, IRI: iri, Type: resource.entity.Type}
+		if apiErr := authorize(r, auth.ActionCreate, resource.entity.Type); nil != apiErr {
+			imp.fail(result, apiErr)
+			continue
+		}
+		entity, apiErr := createEntity(storageFromRequest(r), resource.entity)
+		if nil != apiErr {
+			imp.fail(result, apiErr)
+			continue
+		}
+		created[iri] = entity.ID
+		result.ID = entity.ID
+		imp.succeed(result)
+	}
+
+	for _, iri := range order {
+		resource := resources[iri]
+		for _, triple := range resource.relations {
+			imp.importRDFRelation(base, resource, resources[triple.Object.Value], triple, created)
+		}
+	}
+
+	archivist.Info("> Imported rdf statements, created/failed:", imp.report.Created, imp.report.Failed)
+	respondJson(imp.report, 200, w)
+}
+
+// collectResources maps the statements onto entities. Objects that
+// are IRIs or blank nodes become resources as well, except for gits
+// entity IRIs which aren't described in the document, they reference
+// existing entities.
+func (base rdfVocabulary) collectResources(triples []rdf.Triple) (map[string]*rdfResource, []string) {
+	resources := make(map[string]*rdfResource)
+	order := []string{}
+	add := func(term rdf.Term, line int) *rdfResource {
+		if resource, ok := resources[term.Value]; ok {
+			return resource
+		}
+		resource := &rdfResource{
+			iri:    term.Value,
+			line:   line,
+			entity: transport.TransportEntity{Type: rdfDefaultType},
+		}
+		// resources without gits:value keep their IRI as value
+		if rdf.KindIRI == term.Kind {
+			resource.entity.Value = term.Value
+		}
+		if typeStr, _, ok := base.parseEntityIRI(term.Value); ok {
+			resource.entity.Type = typeStr
+		}
+		resources[term.Value] = resource
+		order = append(order, term.Value)
+		return resource
+	}
+
+	for _, triple := range triples {
+		resource := add(triple.Subject, triple.Line)
+		predicate := triple.Predicate.Value
+		switch {
+		case rdf.RDFType == predicate && rdf.KindIRI == triple.Object.Kind:
+			// further types are dropped, gits entities have exactly one
+			if !resource.typed {
+				resource.typed = true
+				resource.entity.Type = base.name(triple.Object.Value, "type/")
+			}
+		case rdf.KindLiteral != triple.Object.Kind:
+			resource.relations = append(resource.relations, triple)
+		case base.valueIRI() == predicate:
+			resource.entity.Value = triple.Object.Value
+		case base.contextIRI() == predicate:
+			resource.entity.Context = triple.Object.Value
+		default:
+			if nil == resource.entity.Properties {
+				resource.entity.Properties = make(map[string]string)
+			}
+			resource.entity.Properties[base.name(predicate, "property/")] = triple.Object.Value
+		}
+	}
+
+	// referenced objects, the subjects are known at this point
+	for _, triple := range triples {
+		if rdf.KindLiteral == triple.Object.Kind || rdf.RDFType == triple.Predicate.Value {
+			continue
+		}
+		if _, ok := resources[triple.Object.Value]; ok {
+			continue
+		}
+		if _, _, ok := base.parseEntityIRI(triple.Object.Value); ok {
+			continue
+		}
+		add(triple.Object, triple.Line)
+	}
+	return resources, order
+}
+
+// importRDFRelation creates the relation of a statement, the target
+// is either an imported resource or an existing gits entity
+func (imp *importer) importRDFRelation(base rdfVocabulary, source *rdfResource, target *rdfResource, triple rdf.Triple, created map[string]int) {
+	relation := transport.TransportRelation{
+		SourceType: source.entity.Type,
+		SourceID:   created[source.iri],
+		Context:    base.name(triple.Predicate.Value, "relation/"),
+	}
+	result := ImportResult{Line: triple.Line, IRI: source.iri, SourceType: relation.SourceType, SourceID: relation.SourceID}
+
+	if nil != target {
+		relation.TargetType, relation.TargetID = target.entity.Type, created[target.iri]
+	} else {
+		relation.TargetType, relation.TargetID, _ = base.parseEntityIRI(triple.Object.Value)
+	}
+	result.TargetType, result.TargetID = relation.TargetType, relation.TargetID
+
+	// the failure of the entity is already reported
+	if 0 == relation.SourceID || 0 == relation.TargetID {
+		imp.fail(result, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Source or target of the statement could not be imported", map[string]string{"object": triple.Object.Value}))
+		return
+	}
+	if apiErr := authorize(imp.r, auth.ActionUpdate, relation.SourceType, relation.TargetType); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	if _, apiErr := createRelation(storageFromRequest(imp.r), relation); nil != apiErr {
+		imp.fail(result, apiErr)
+		return
+	}
+	imp.succeed(result)
+}
+
+// parseRDFEntityIRI returns type and ID of a gits entity IRI
+func (base rdfVocabulary) parseEntityIRI(iri string) (string, int, bool) {
+	if !strings.HasPrefix(iri, string(base)+"entity/") {
+		return "", 0, false
+	}
+	escapedType, idStr, found := strings.Cut(strings.TrimPrefix(iri, string(base)+"entity/"), "/")
+	if !found {
+		return "", 0, false
+	}
+	typeStr, err := url.PathUnescape(escapedType)
+	if nil != err || "" == typeStr {
+		return "", 0, false
+	}
+	id, err := strconv.Atoi(idStr)
+	if nil != err || 0 >= id {
+		return "", 0, false
+	}
+	return typeStr, id, true
+}
+
+// rdfName returns the name of a gits vocabulary IRI, other IRIs are
+// named by their last segment
+func (base rdfVocabulary) name(iri string, kind string) string {
+	if strings.HasPrefix(iri, string(base)+kind) {
+		if name, err := url.PathUnescape(strings.TrimPrefix(iri, string(base)+kind)); nil == err {
+			return name
+		}
+	}
+	name := iri
+	if index := strings.LastIndexAny(strings.TrimRight(iri, "/#"), "/#:"); -1 != index {
+		name = strings.TrimRight(iri[index+1:], "/#")
+	}
+	if "" == name {
+		return iri
+	}
+	return name
+}
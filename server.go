@@ -0,0 +1,168 @@
+package gitsapi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits"
+	"github.com/voodooEntity/gitsapi/src/auth"
+	"github.com/voodooEntity/gitsapi/src/config"
+)
+
+// Server is a self contained gitsapi http server. Every Server owns its
+// own ServeMux, config, storage index, write locks and journals, so several
+// of them can run side by side in one process. The gits instances and the
+// default storage set by gits.SetDefault are process global though, all
+// servers share the storages and switching the default in one server
+// switches it for all of them, see storageSet.
+type Server struct {
+	ServeMux   *http.ServeMux
+	httpServer *http.Server
+	config     *config.Config
+	storages   *storageSet
+	rdfBase    rdfVocabulary
+	keyStore   *auth.KeyStore
+	jwt        *auth.JwtVerifier
+	policy     *auth.Policy
+	// create unknown storages on first use instead of answering 404
+	autoCreateStorages bool
+	// nil unless SNAPSHOT_DIR is configured
+	snapshots *snapshotter
+	analytics *analyticsRunner
+}
+
+// NewServer creates a Server with all api routes registered on a fresh
+// ServeMux, using the config loaded by config.Init. The listen address is
+// taken from the HOST and PORT configs.
+func NewServer() (*Server, error) {
+	conf, err := config.Current()
+	if nil != err {
+		return nil, err
+	}
+	return NewServerWithConfig(conf)
+}
+
+// NewServerWithConfig is like NewServer but uses the given config
+func NewServerWithConfig(conf *config.Config) (*Server, error) {
+	return newServer(http.NewServeMux(), conf)
+}
+
+func newServer(mux *http.ServeMux, conf *config.Config) (*Server, error) {
+	archivist.Info("> Bootin Gits HTTP API Version: " + version)
+	s := &Server{
+		ServeMux: mux,
+		config:   conf,
+		storages: newStorageSet(),
+	}
+	s.httpServer = &http.Server{
+		Addr:    s.buildListenConfigString(),
+		Handler: mux,
+	}
+	if defaultInstance := gits.GetDefault(); nil != defaultInstance {
+		s.RegisterStorage(defaultInstance.Name)
+	}
+	s.autoCreateStorages = "true" == conf.GetOptionalValue("STORAGE_AUTO_CREATE", "false")
+	// these go first, they leave nothing to clean up if they fail
+	if err := s.loadRDFBase(); nil != err {
+		return nil, err
+	}
+	if err := s.loadAuth(); nil != err {
+		return nil, err
+	}
+	if err := s.loadAnalytics(); nil != err {
+		return nil, err
+	}
+	restored, err := s.loadSnapshots()
+	if nil != err {
+		return nil, err
+	}
+	if err := s.loadJournal(restored); nil != err {
+		return nil, err
+	}
+	s.startSnapshots()
+	s.registerRoutes()
+	return s, nil
+}
+
+// Handler returns the http.Handler serving all routes of the server,
+// useful to mount the api into another server or into tests.
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// ListenAndServe listens on the configured address using the configured
+// protocol and blocks until the server fails or gets shut down. After a
+// Shutdown it returns nil.
+func (s *Server) ListenAndServe() error {
+	archivist.Info("> Server listening settings by config (" + s.httpServer.Addr + ")")
+	var err error
+	switch s.config.GetValue("PROTOCOL") {
+	case "https":
+		err = s.httpServer.ListenAndServeTLS(s.config.GetValue("SSL_CERT_FILE"), s.config.GetValue("SSL_KEY_FILE"))
+	case "http":
+		err = s.httpServer.ListenAndServe()
+	default:
+		err = errors.New("Unsupported protocol '" + s.config.GetValue("PROTOCOL") + "'")
+	}
+	return filterServerClosed(err)
+}
+
+// Serve is like ListenAndServe but accepts connections on the given
+// listener instead of the configured address.
+func (s *Server) Serve(listener net.Listener) error {
+	var err error
+	switch s.config.GetValue("PROTOCOL") {
+	case "https":
+		err = s.httpServer.ServeTLS(listener, s.config.GetValue("SSL_CERT_FILE"), s.config.GetValue("SSL_KEY_FILE"))
+	case "http":
+		err = s.httpServer.Serve(listener)
+	default:
+		err = errors.New("Unsupported protocol '" + s.config.GetValue("PROTOCOL") + "'")
+	}
+	return filterServerClosed(err)
+}
+
+// Shutdown stops accepting new connections and waits for in-flight
+// requests to finish or the context to expire, whichever comes first.
+// Running analytics jobs get cancelled. If snapshots are enabled a final
+// one is written afterwards, open journals get synced and closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	s.analytics.close()
+	if nil != s.snapshots {
+		if apiErr := s.snapshots.close(); nil != apiErr {
+			archivist.Error("Final snapshot failed", apiErr.Message)
+			if nil == err {
+				err = errors.New(apiErr.Message)
+			}
+		}
+	}
+	s.storages.journals.close()
+	return err
+}
+
+// handleFunc registers a route on the ServeMux of the server. The CORS
+// headers are added to every response and the handler finds the server
+// by serverFromRequest.
+func (s *Server) handleFunc(pattern string, handler http.HandlerFunc) {
+	s.ServeMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		s.addCorsHeaders(w)
+		handler(w, r.WithContext(context.WithValue(r.Context(), serverContextKey, s)))
+	})
+}
+
+// serverFromRequest returns the server the route is registered on
+func serverFromRequest(r *http.Request) *Server {
+	s, _ := r.Context().Value(serverContextKey).(*Server)
+	return s
+}
+
+func filterServerClosed(err error) error {
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,245 @@
+package gitsapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits"
+	"github.com/voodooEntity/gitsapi/src/config"
+)
+
+func init() {
+	archivist.Init("error", "stdout", "")
+	if nil == gits.GetDefault() {
+		gits.NewInstance("test")
+	}
+}
+
+// testConfig returns the required configs plus the given ones
+func testConfig(t *testing.T, params map[string]string) *config.Config {
+	t.Helper()
+	conf := map[string]string{
+		"HOST":          "127.0.0.1",
+		"PORT":          "0",
+		"LOG_TARGET":    "stdout",
+		"LOG_PATH":      "",
+		"LOG_LEVEL":     "error",
+		"CORS_HEADER":   "",
+		"CORS_ORIGIN":   "",
+		"SSL_CERT_FILE": "",
+		"SSL_KEY_FILE":  "",
+		"PROTOCOL":      "http",
+	}
+	for key, value := range params {
+		conf[key] = value
+	}
+	c, err := config.New(conf)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newTestServer creates a server of the testConfig, it is served by an
+// httptest server
+func newTestServer(t *testing.T, params map[string]string) (*Server, *httptest.Server) {
+	t.Helper()
+	s, err := NewServerWithConfig(testConfig(t, params))
+	if nil != err {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		s.Shutdown(context.Background())
+	})
+	return s, ts
+}
+
+// call sends a request to the test server and decodes the json answer
+// into result if given
+func call(t *testing.T, ts *httptest.Server, method string, path string, headers map[string]string, body string, result interface{}) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if nil != err {
+		t.Fatal(err)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := ts.Client().Do(req)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if nil != result {
+		if err := json.Unmarshal(data, result); nil != err {
+			t.Fatalf("%s %s answered no json: %s", method, path, string(data))
+		}
+	}
+	return resp
+}
+
+func storageNames(t *testing.T, ts *httptest.Server) []string {
+	t.Helper()
+	var infos []StorageInfo
+	call(t, ts, "GET", "/v1/admin/listStorages", nil, "", &infos)
+	names := []string{}
+	for _, info := range infos {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+func contains(list []string, value string) bool {
+	for _, entry := range list {
+		if entry == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServersSideBySide(t *testing.T) {
+	first, firstTs := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true", "CORS_ORIGIN": "https://first.example"})
+	_, secondTs := newTestServer(t, map[string]string{"CORS_ORIGIN": "https://second.example"})
+
+	// the storage auto created by the first server is unknown to the second
+	entity := `{"Type":"Person","Value":"alice"}`
+	if resp := call(t, firstTs, "POST", "/v1/createEntity", map[string]string{"Storage": "side-by-side-first"}, entity, nil); 200 != resp.StatusCode {
+		t.Fatalf("createEntity on first server answered %d", resp.StatusCode)
+	}
+	if !contains(storageNames(t, firstTs), "side-by-side-first") {
+		t.Error("first server doesn't list its auto created storage")
+	}
+	if contains(storageNames(t, secondTs), "side-by-side-first") {
+		t.Error("second server lists the storage of the first one")
+	}
+	var apiErr errorEnvelope
+	if resp := call(t, secondTs, "POST", "/v1/createEntity", map[string]string{"Storage": "side-by-side-second"}, entity, &apiErr); 404 != resp.StatusCode || CodeStorageNotFound != apiErr.Error.Code {
+		t.Errorf("second server without STORAGE_AUTO_CREATE answered %d %v", resp.StatusCode, apiErr.Error)
+	}
+
+	// every server answers with its own CORS config
+	for _, tc := range []struct {
+		ts     *httptest.Server
+		origin string
+	}{
+		{firstTs, "https://first.example"},
+		{secondTs, "https://second.example"},
+	} {
+		resp := call(t, tc.ts, "GET", "/v1/ping", nil, "", nil)
+		if origin := resp.Header.Get("Access-Control-Allow-Origin"); tc.origin != origin {
+			t.Errorf("expected origin %s, got %s", tc.origin, origin)
+		}
+	}
+
+	// shutting down the first server leaves the second one working
+	if err := first.Shutdown(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	var created struct {
+		Entities []struct{ ID int }
+	}
+	if resp := call(t, secondTs, "POST", "/v1/createEntity", nil, entity, &created); 200 != resp.StatusCode || 1 != len(created.Entities) {
+		t.Fatalf("createEntity on second server after shutdown answered %d", resp.StatusCode)
+	}
+	if resp := call(t, secondTs, "GET", "/v1/ping", nil, "", nil); 200 != resp.StatusCode {
+		t.Errorf("ping on second server after shutdown answered %d", resp.StatusCode)
+	}
+}
+
+// a storage dropped by one server is gone for the others as well, until
+// one of them creates it again
+func TestDropStorageHidesItFromOtherServers(t *testing.T) {
+	first, firstTs := newTestServer(t, nil)
+	second, secondTs := newTestServer(t, nil)
+	name := "dropped-" + t.Name()
+	if _, apiErr := first.storages.create(name); nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	second.RegisterStorage(name)
+
+	headers := map[string]string{"Storage": name}
+	entity := `{"Type":"Person","Value":"alice"}`
+	if resp := call(t, firstTs, "DELETE", "/v1/admin/dropStorage?name="+name, nil, "", nil); 200 != resp.StatusCode {
+		t.Fatalf("dropStorage answered %d", resp.StatusCode)
+	}
+	for _, ts := range []*httptest.Server{firstTs, secondTs} {
+		if contains(storageNames(t, ts), name) {
+			t.Errorf("%s still lists the dropped storage", ts.URL)
+		}
+		if resp := call(t, ts, "POST", "/v1/createEntity", headers, entity, nil); 404 != resp.StatusCode {
+			t.Errorf("createEntity on the dropped storage answered %d", resp.StatusCode)
+		}
+	}
+
+	// recreated by the second server, the first one can use it again
+	if resp := call(t, secondTs, "POST", "/v1/admin/createStorage?name="+name, nil, "", nil); 201 != resp.StatusCode {
+		t.Fatalf("createStorage answered %d", resp.StatusCode)
+	}
+	if resp := call(t, secondTs, "POST", "/v1/admin/createStorage?name="+name, nil, "", nil); 409 != resp.StatusCode {
+		t.Errorf("creating the storage twice answered %d", resp.StatusCode)
+	}
+	if resp := call(t, firstTs, "POST", "/v1/createEntity", headers, entity, nil); 200 != resp.StatusCode {
+		t.Errorf("createEntity on the recreated storage answered %d", resp.StatusCode)
+	}
+}
+
+func TestShutdownKeepsJournalsOfOtherServers(t *testing.T) {
+	first, firstTs := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true", "JOURNAL_DIR": t.TempDir()})
+	_, secondTs := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true", "JOURNAL_DIR": t.TempDir()})
+
+	entity := `{"Type":"Person","Value":"alice"}`
+	for _, ts := range []*httptest.Server{firstTs, secondTs} {
+		if resp := call(t, ts, "POST", "/v1/createEntity", map[string]string{"Storage": "journal-" + ts.URL}, entity, nil); 200 != resp.StatusCode {
+			t.Fatalf("createEntity answered %d", resp.StatusCode)
+		}
+	}
+	if err := first.Shutdown(context.Background()); nil != err {
+		t.Fatal(err)
+	}
+	if resp := call(t, secondTs, "POST", "/v1/createEntity", map[string]string{"Storage": "journal-" + secondTs.URL}, entity, nil); 200 != resp.StatusCode {
+		t.Errorf("createEntity on second server after shutdown answered %d", resp.StatusCode)
+	}
+}
+
+func TestNewServerRejectsInvalidConfig(t *testing.T) {
+	if _, err := config.New(map[string]string{"HOST": "127.0.0.1"}); nil == err {
+		t.Error("config without the required values got accepted")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		params map[string]string
+	}{
+		{"journal fsync policy", map[string]string{"JOURNAL_DIR": t.TempDir(), "JOURNAL_FSYNC": "sometimes"}},
+		{"rdf base iri", map[string]string{"RDF_BASE_IRI": "relative/"}},
+		{"api keys file", map[string]string{"AUTH_KEYS_FILE": filepath.Join(t.TempDir(), "missing.json")}},
+		{"jwt without audience", map[string]string{"JWT_HS256_SECRET": strings.Repeat("s", 32)}},
+		{"jwt short secret", map[string]string{"JWT_HS256_SECRET": "short", "JWT_AUDIENCE": "gitsapi"}},
+		{"policy file", map[string]string{"POLICY_FILE": filepath.Join(t.TempDir(), "missing.json")}},
+		{"analytics workers", map[string]string{"ANALYTICS_WORKERS": "0"}},
+		{"analytics job ttl", map[string]string{"ANALYTICS_JOB_TTL": "forever"}},
+		{"analytics max queued", map[string]string{"ANALYTICS_MAX_QUEUED": "none"}},
+		{"snapshot interval", map[string]string{"SNAPSHOT_DIR": t.TempDir(), "SNAPSHOT_INTERVAL": "-1m"}},
+		{"journal fsync interval", map[string]string{"JOURNAL_DIR": t.TempDir(), "JOURNAL_FSYNC": "interval", "JOURNAL_FSYNC_INTERVAL": "soon"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewServerWithConfig(testConfig(t, tc.params)); nil == err {
+				t.Error("server got created")
+			}
+		})
+	}
+}
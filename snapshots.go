@@ -0,0 +1,229 @@
+package gitsapi
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gitsapi/src/snapshot"
+)
+
+// snapshotter writes the known storages to SNAPSHOT_DIR and loads
+// them back, optionally every SNAPSHOT_INTERVAL
+type snapshotter struct {
+	storages *storageSet
+	dir      string
+	interval time.Duration
+	// snapshot and restore runs must not overlap
+	mutex *sync.Mutex
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// SnapshotResult describes a written or restored snapshot
+type SnapshotResult struct {
+	Storage        string
+	Path           string
+	CreatedAt      time.Time
+	EntityAmount   int
+	RelationAmount int
+	JournalSeq     uint64 `json:",omitempty"`
+}
+
+// loadSnapshots restores all snapshots found in SNAPSHOT_DIR
+func (s *Server) loadSnapshots() ([]SnapshotResult, error) {
+	dir := s.config.GetOptionalValue("SNAPSHOT_DIR", "")
+	if "" == dir {
+		return nil, nil
+	}
+
+	interval, err := time.ParseDuration(s.config.GetOptionalValue("SNAPSHOT_INTERVAL", "0s"))
+	if nil != err || 0 > interval {
+		return nil, errors.New("Invalid SNAPSHOT_INTERVAL '" + s.config.GetOptionalValue("SNAPSHOT_INTERVAL", "") + "', expected a duration like '10m'")
+	}
+
+	s.snapshots = &snapshotter{
+		storages: s.storages,
+		dir:      dir,
+		interval: interval,
+		mutex:    &sync.Mutex{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	results, apiErr := s.snapshots.restoreAll()
+	if nil != apiErr {
+		return nil, errors.New("Could not restore snapshots: " + apiErr.Message)
+	}
+	for _, result := range results {
+		archivist.Info("> Restored storage '"+result.Storage+"' from snapshot of", result.CreatedAt.String())
+	}
+	return results, nil
+}
+
+// startSnapshots starts the periodic snapshots if configured, this
+// has to wait until the journals got replayed
+func (s *Server) startSnapshots() {
+	if nil == s.snapshots {
+		return
+	}
+	if 0 < s.snapshots.interval {
+		go s.snapshots.run()
+		archivist.Info("> Periodic snapshots enabled every", s.snapshots.interval.String())
+	} else {
+		close(s.snapshots.done)
+	}
+}
+
+func (sn *snapshotter) run() {
+	defer close(sn.done)
+	ticker := time.NewTicker(sn.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, apiErr := sn.snapshotAll(); nil != apiErr {
+				archivist.Error("Periodic snapshot failed", apiErr.Message)
+			}
+		case <-sn.stop:
+			return
+		}
+	}
+}
+
+// close stops the periodic snapshots and writes a final one
+func (sn *snapshotter) close() *apiError {
+	select {
+	case <-sn.stop:
+		return nil
+	default:
+		close(sn.stop)
+	}
+	<-sn.done
+	_, apiErr := sn.snapshotAll()
+	return apiErr
+}
+
+func (sn *snapshotter) snapshot(name string) (SnapshotResult, *apiError) {
+	g := sn.storages.get(name)
+	if nil == g {
+		return SnapshotResult{}, storageNotFoundError(name)
+	}
+
+	sn.mutex.Lock()
+	defer sn.mutex.Unlock()
+
+	// no mutation may happen while capturing and until the sequence is
+	// read, the write lock keeps them out even without a journal
+	write, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return SnapshotResult{}, apiErr
+	}
+	snap := snapshot.Capture(name, g.Storage())
+	j := write.journal
+	snap.JournalSeq = j.Seq()
+	write.end()
+
+	path, err := snapshot.Write(sn.dir, snap)
+	if nil != err {
+		return SnapshotResult{}, internalError("Could not write snapshot of storage '" + name + "': " + err.Error())
+	}
+
+	// records written meanwhile are kept
+	j.Lock()
+	defer j.Unlock()
+	return snapshotResult(snap, path), compactJournal(j, snap.JournalSeq)
+}
+
+func (sn *snapshotter) snapshotAll() ([]SnapshotResult, *apiError) {
+	results := []SnapshotResult{}
+	for _, name := range sn.storages.names() {
+		result, apiErr := sn.snapshot(name)
+		if nil != apiErr {
+			return results, apiErr
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// restore loads the snapshot of the named storage, the storage
+// gets created if it doesn't exist
+func (sn *snapshotter) restore(name string) (SnapshotResult, *apiError) {
+	sn.mutex.Lock()
+	defer sn.mutex.Unlock()
+
+	path := snapshot.Path(sn.dir, name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return SnapshotResult{}, newApiError(http.StatusNotFound, CodeSnapshotNotFound, "No snapshot existing for the storage", map[string]string{"storage": name})
+	}
+	snap, err := snapshot.Read(path)
+	if nil != err {
+		return SnapshotResult{}, internalError(err.Error())
+	}
+
+	g := sn.storages.get(name)
+	if nil == g {
+		var apiErr *apiError
+		if g, apiErr = sn.storages.create(name); nil != apiErr {
+			return SnapshotResult{}, apiErr
+		}
+	}
+
+	// changes made after the snapshot are discarded, including their
+	// journal records. On startup there is no journal yet.
+	write, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return SnapshotResult{}, apiErr
+	}
+	defer write.end()
+	if err = snap.Restore(g.Storage()); nil != err {
+		return SnapshotResult{}, internalError("Snapshot of storage '" + name + "' is inconsistent: " + err.Error())
+	}
+	sn.storages.register(name)
+	return snapshotResult(snap, path), compactJournal(write.journal, write.journal.Seq())
+}
+
+func (sn *snapshotter) restoreAll() ([]SnapshotResult, *apiError) {
+	names, err := snapshot.List(sn.dir)
+	if nil != err {
+		return nil, internalError("Could not read snapshot dir: " + err.Error())
+	}
+	results := []SnapshotResult{}
+	for _, name := range names {
+		result, apiErr := sn.restore(name)
+		if nil != apiErr {
+			return results, apiErr
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// remove deletes the snapshot of a dropped storage, so it
+// doesn't come back on the next start
+func (sn *snapshotter) remove(name string) {
+	sn.mutex.Lock()
+	defer sn.mutex.Unlock()
+	if err := snapshot.Remove(sn.dir, name); nil != err {
+		archivist.Error("Could not remove snapshot of dropped storage", name, err.Error())
+	}
+}
+
+func snapshotResult(snap snapshot.Snapshot, path string) SnapshotResult {
+	return SnapshotResult{
+		Storage:        snap.Storage,
+		Path:           path,
+		CreatedAt:      snap.CreatedAt,
+		EntityAmount:   len(snap.Entities),
+		RelationAmount: len(snap.Relations),
+		JournalSeq:     snap.JournalSeq,
+	}
+}
+
+func snapshotsDisabledError() *apiError {
+	return newApiError(http.StatusConflict, CodeSnapshotsDisabled, "Snapshots are not enabled, configure a SNAPSHOT_DIR", nil)
+}
@@ -0,0 +1,193 @@
+package analytics
+
+import (
+	"context"
+	"math"
+)
+
+// Graph is a directed graph of nodes numbered from 0. Both directions are
+// kept, so the algorithms can walk relations backwards.
+type Graph struct {
+	Out [][]int
+	In  [][]int
+}
+
+// NewGraph creates a graph of size nodes without relations
+func NewGraph(size int) *Graph {
+	return &Graph{
+		Out: make([][]int, size),
+		In:  make([][]int, size),
+	}
+}
+
+// AddEdge adds a relation leading from one node to another
+func (g *Graph) AddEdge(from int, to int) {
+	g.Out[from] = append(g.Out[from], to)
+	g.In[to] = append(g.In[to], from)
+}
+
+// Size is the amount of nodes
+func (g *Graph) Size() int {
+	return len(g.Out)
+}
+
+// Components finds the weakly connected components, relations connect
+// nodes whatever their direction. It returns the component of each node
+// and the size of each component, components are numbered by their
+// lowest node.
+func Components(g *Graph) ([]int, []int) {
+	parent := make([]int, g.Size())
+	for node := range parent {
+		parent[node] = node
+	}
+	var find func(node int) int
+	find = func(node int) int {
+		if parent[node] != node {
+			parent[node] = find(parent[node])
+		}
+		return parent[node]
+	}
+	for from, targets := range g.Out {
+		for _, to := range targets {
+			a, b := find(from), find(to)
+			// the lower root wins, so numbering follows the nodes
+			if a < b {
+				parent[b] = a
+			} else if b < a {
+				parent[a] = b
+			}
+		}
+	}
+
+	membership := make([]int, g.Size())
+	index := make(map[int]int)
+	sizes := []int{}
+	for node := range parent {
+		root := find(node)
+		component, ok := index[root]
+		if !ok {
+			component = len(sizes)
+			index[root] = component
+			sizes = append(sizes, 0)
+		}
+		membership[node] = component
+		sizes[component]++
+	}
+	return membership, sizes
+}
+
+// PageRank scores the nodes by the random surfer model. Nodes without
+// outgoing relations spread their rank over all nodes. It stops when
+// the ranks change less than tolerance in sum, or after maxIterations,
+// and returns the ranks and the iterations it took.
+func PageRank(ctx context.Context, g *Graph, damping float64, maxIterations int, tolerance float64) ([]float64, int, error) {
+	size := g.Size()
+	if 0 == size {
+		return []float64{}, 0, nil
+	}
+	ranks := make([]float64, size)
+	for node := range ranks {
+		ranks[node] = 1 / float64(size)
+	}
+
+	iteration := 0
+	for iteration < maxIterations {
+		if err := ctx.Err(); nil != err {
+			return nil, iteration, err
+		}
+		iteration++
+
+		dangling := 0.0
+		for node, targets := range g.Out {
+			if 0 == len(targets) {
+				dangling += ranks[node]
+			}
+		}
+		base := (1-damping)/float64(size) + damping*dangling/float64(size)
+		next := make([]float64, size)
+		change := 0.0
+		for node, sources := range g.In {
+			next[node] = base
+			for _, source := range sources {
+				next[node] += damping * ranks[source] / float64(len(g.Out[source]))
+			}
+			change += math.Abs(next[node] - ranks[node])
+		}
+		ranks = next
+		if change < tolerance {
+			break
+		}
+	}
+	return ranks, iteration, nil
+}
+
+// Betweenness scores the nodes by the amount of shortest paths between
+// other nodes passing them, by Brandes' algorithm. Undirected every path
+// is found from both of its ends, so the scores get halved.
+func Betweenness(ctx context.Context, g *Graph, directed bool) ([]float64, error) {
+	size := g.Size()
+	scores := make([]float64, size)
+	neighbours := g.Out
+	if !directed {
+		neighbours = make([][]int, size)
+		for node := range neighbours {
+			// relations in both directions between two nodes count once
+			seen := make(map[int]bool)
+			for _, next := range append(append([]int{}, g.Out[node]...), g.In[node]...) {
+				if !seen[next] {
+					seen[next] = true
+					neighbours[node] = append(neighbours[node], next)
+				}
+			}
+		}
+	}
+
+	distance := make([]int, size)
+	paths := make([]float64, size)
+	dependency := make([]float64, size)
+	predecessors := make([][]int, size)
+	for source := 0; source < size; source++ {
+		if err := ctx.Err(); nil != err {
+			return nil, err
+		}
+		for node := range distance {
+			distance[node] = -1
+			paths[node] = 0
+			dependency[node] = 0
+			predecessors[node] = predecessors[node][:0]
+		}
+		distance[source] = 0
+		paths[source] = 1
+
+		// breadth first, order keeps the nodes by distance
+		order := []int{source}
+		for i := 0; i < len(order); i++ {
+			node := order[i]
+			for _, next := range neighbours[node] {
+				if -1 == distance[next] {
+					distance[next] = distance[node] + 1
+					order = append(order, next)
+				}
+				if distance[next] == distance[node]+1 {
+					paths[next] += paths[node]
+					predecessors[next] = append(predecessors[next], node)
+				}
+			}
+		}
+
+		// back from the farthest nodes the dependencies add up
+		for i := len(order) - 1; 0 < i; i-- {
+			node := order[i]
+			for _, previous := range predecessors[node] {
+				dependency[previous] += paths[previous] / paths[node] * (1 + dependency[node])
+			}
+			scores[node] += dependency[node]
+		}
+	}
+	if !directed {
+		for node := range scores {
+			scores[node] /= 2
+		}
+	}
+	return scores, nil
+}
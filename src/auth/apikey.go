@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ApiKey is a single entry of the keys file. Only the bcrypt hash
+// of the secret is stored, the key handed to clients has the
+// form "<ID>.<secret>". Storages optionally limits the usable
+// gits instances.
+type ApiKey struct {
+	ID       string
+	Hash     string
+	Scopes   []string
+	Storages []string `json:",omitempty"`
+}
+
+// KeyStore verifies api keys against the entries of a keys file
+type KeyStore struct {
+	keys map[string]ApiKey
+	// bcrypt is slow by design, so keys that already passed the
+	// check are remembered by their sha256 sum
+	verified      map[[sha256.Size]byte]string
+	verifiedMutex *sync.RWMutex
+}
+
+func NewKeyStore(keys []ApiKey) (*KeyStore, error) {
+	ks := &KeyStore{
+		keys:          make(map[string]ApiKey),
+		verified:      make(map[[sha256.Size]byte]string),
+		verifiedMutex: &sync.RWMutex{},
+	}
+	for _, key := range keys {
+		if "" == key.ID || strings.Contains(key.ID, ".") {
+			return nil, errors.New("Api key ID must be set and can't contain a '.'")
+		}
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, errors.New("Duplicate api key ID '" + key.ID + "'")
+		}
+		if _, err := bcrypt.Cost([]byte(key.Hash)); nil != err {
+			return nil, errors.New("Invalid bcrypt hash for api key '" + key.ID + "'")
+		}
+		ks.keys[key.ID] = key
+	}
+	return ks, nil
+}
+
+// LoadKeyStore reads a json keys file containing a list of ApiKey
+func LoadKeyStore(path string) (*KeyStore, error) {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	var keys []ApiKey
+	if err = json.Unmarshal(data, &keys); nil != err {
+		return nil, errors.New("Api keys file content is not a valid json: " + err.Error())
+	}
+	return NewKeyStore(keys)
+}
+
+// Verify checks the given "<ID>.<secret>" key and returns the
+// identity of the key owner
+func (ks *KeyStore) Verify(key string) (*Identity, error) {
+	id, secret, found := strings.Cut(key, ".")
+	if !found || "" == secret {
+		return nil, errors.New("Malformed api key")
+	}
+	apiKey, ok := ks.keys[id]
+	if !ok {
+		return nil, errors.New("Unknown api key")
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	ks.verifiedMutex.RLock()
+	verifiedID, cached := ks.verified[sum]
+	ks.verifiedMutex.RUnlock()
+	if !cached || verifiedID != id {
+		if err := bcrypt.CompareHashAndPassword([]byte(apiKey.Hash), []byte(secret)); nil != err {
+			return nil, errors.New("Invalid api key")
+		}
+		ks.verifiedMutex.Lock()
+		ks.verified[sum] = id
+		ks.verifiedMutex.Unlock()
+	}
+
+	return &Identity{
+		Name:     apiKey.ID,
+		Scopes:   apiKey.Scopes,
+		Storages: apiKey.Storages,
+	}, nil
+}
+
+// GenerateApiKey creates a random key for the given ID and returns
+// the key to hand out together with the hash to store
+func GenerateApiKey(id string) (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); nil != err {
+		return "", "", err
+	}
+	secret := hex.EncodeToString(raw)
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if nil != err {
+		return "", "", err
+	}
+	return id + "." + secret, string(hash), nil
+}
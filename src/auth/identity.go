@@ -0,0 +1,41 @@
+package auth
+
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeQuery = "query"
+	ScopeAdmin = "admin"
+)
+
+// Identity is an authenticated caller of the api. Storages limits
+// the gits instances it may use, nil allows all of them.
+type Identity struct {
+	Name     string
+	Scopes   []string
+	Storages []string
+}
+
+// HasScope tells if the identity was granted the given scope,
+// the admin scope implies all others
+func (i *Identity) HasScope(scope string) bool {
+	for _, granted := range i.Scopes {
+		if granted == scope || ScopeAdmin == granted {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessStorage tells if the identity may use the gits instance
+// with the given name
+func (i *Identity) CanAccessStorage(name string) bool {
+	if nil == i.Storages {
+		return true
+	}
+	for _, allowed := range i.Storages {
+		if allowed == name || "*" == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+)
+
+// reasons a token gets rejected for, handed to the client
+// so it can tell an expired token from a broken one
+const (
+	ReasonMalformed            = "malformed"
+	ReasonUnsupportedAlgorithm = "unsupported_algorithm"
+	ReasonUnknownKey           = "unknown_key"
+	ReasonInvalidSignature     = "invalid_signature"
+	ReasonExpired              = "expired"
+	ReasonNotYetValid          = "not_yet_valid"
+	ReasonInvalidAudience      = "invalid_audience"
+	ReasonInvalidIssuer        = "invalid_issuer"
+)
+
+// JwtIdentityPrefix is put in front of the sub claim to name the identity
+// of a token, so subjects can't be mistaken for api key IDs in policies
+const JwtIdentityPrefix = "jwt:"
+
+// TokenError describes why a bearer token was rejected
+type TokenError struct {
+	Reason  string
+	Message string
+}
+
+func (e *TokenError) Error() string {
+	return e.Message
+}
+
+func tokenError(reason string, message string) *TokenError {
+	return &TokenError{Reason: reason, Message: message}
+}
+
+// Claims are the token claims evaluated by gitsapi. Scopes can either
+// be given as space separated "scope" string or as "scopes" list.
+// Without a "storages" claim the token is valid for all storages.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Scopes    []string `json:"scopes"`
+	Storages  []string `json:"storages"`
+}
+
+// audience can be a single string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); nil == err {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); nil != err {
+		return errors.New("aud has to be a string or a list of strings")
+	}
+	*a = list
+	return nil
+}
+
+type verificationKey struct {
+	ID  string
+	Alg string
+	Key interface{}
+}
+
+// JwtVerifier validates bearer tokens against locally known keys,
+// no identity provider is contacted at runtime
+type JwtVerifier struct {
+	keys     []verificationKey
+	audience string
+	issuer   string
+	now      func() time.Time
+}
+
+// NewJwtVerifier creates a verifier accepting tokens for the given
+// audience. The issuer is only checked if not empty.
+func NewJwtVerifier(audience string, issuer string) (*JwtVerifier, error) {
+	if "" == audience {
+		return nil, errors.New("A token audience is required")
+	}
+	return &JwtVerifier{
+		audience: audience,
+		issuer:   issuer,
+		now:      time.Now,
+	}, nil
+}
+
+// HasKeys tells if at least one verification key was added
+func (jv *JwtVerifier) HasKeys() bool {
+	return 0 < len(jv.keys)
+}
+
+// AddHmacSecret adds a shared secret for HS256 signed tokens
+func (jv *JwtVerifier) AddHmacSecret(secret string) error {
+	if 32 > len(secret) {
+		return errors.New("HS256 secret has to be at least 32 bytes long")
+	}
+	jv.keys = append(jv.keys, verificationKey{Alg: AlgHS256, Key: []byte(secret)})
+	return nil
+}
+
+// AddPublicKeyFile adds a PEM encoded RSA or P-256 public key
+func (jv *JwtVerifier) AddPublicKeyFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return err
+	}
+	block, _ := pem.Decode(data)
+	if nil == block {
+		return errors.New("Public key file contains no PEM block")
+	}
+
+	var pub interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return errors.New("Unsupported PEM block type '" + block.Type + "'")
+	}
+	if nil != err {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		jv.keys = append(jv.keys, verificationKey{Alg: AlgRS256, Key: key})
+	case *ecdsa.PublicKey:
+		if elliptic.P256() != key.Curve {
+			return errors.New("Only P-256 ecdsa keys are supported")
+		}
+		jv.keys = append(jv.keys, verificationKey{Alg: AlgES256, Key: key})
+	default:
+		return errors.New("Unsupported public key type")
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// AddJwksFile adds all signing keys of a local JWKS file
+func (jv *JwtVerifier) AddJwksFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); nil != err {
+		return errors.New("JWKS file content is not a valid json: " + err.Error())
+	}
+
+	for i, key := range set.Keys {
+		if "" != key.Use && "sig" != key.Use {
+			continue
+		}
+		verKey, err := key.verificationKey()
+		if nil != err {
+			return errors.New("JWKS key " + key.Kid + " at index " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		jv.keys = append(jv.keys, verKey)
+	}
+	return nil
+}
+
+func (key jwk) verificationKey() (verificationKey, error) {
+	ret := verificationKey{ID: key.Kid}
+	switch key.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(key.K)
+		if nil != err || 32 > len(secret) {
+			return ret, errors.New("oct key needs a base64url encoded secret of at least 32 bytes")
+		}
+		ret.Alg, ret.Key = AlgHS256, secret
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(key.N)
+		e, errE := base64.RawURLEncoding.DecodeString(key.E)
+		if nil != errN || nil != errE || 0 == len(n) || 0 == len(e) {
+			return ret, errors.New("RSA key needs base64url encoded n and e")
+		}
+		ret.Alg, ret.Key = AlgRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		if "P-256" != key.Crv {
+			return ret, errors.New("Only P-256 EC keys are supported")
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(key.X)
+		y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+		if nil != errX || nil != errY {
+			return ret, errors.New("EC key needs base64url encoded x and y")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return ret, errors.New("EC key point is not on the curve")
+		}
+		ret.Alg, ret.Key = AlgES256, pub
+	default:
+		return ret, errors.New("Unsupported key type '" + key.Kty + "'")
+	}
+
+	if "" != key.Alg && ret.Alg != key.Alg {
+		return ret, errors.New("Unsupported algorithm '" + key.Alg + "'")
+	}
+	return ret, nil
+}
+
+// Verify checks signature and claims of the given token and returns
+// the identity described by it. All errors are of type *TokenError.
+func (jv *JwtVerifier) Verify(token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if 3 != len(parts) {
+		return nil, tokenError(ReasonMalformed, "Token has to consist of three dot separated parts")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); nil != err {
+		return nil, tokenError(ReasonMalformed, "Token header is not valid base64url encoded json")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if nil != err {
+		return nil, tokenError(ReasonMalformed, "Token signature is not valid base64url")
+	}
+	if AlgHS256 != header.Alg && AlgRS256 != header.Alg && AlgES256 != header.Alg {
+		return nil, tokenError(ReasonUnsupportedAlgorithm, "Token algorithm '"+header.Alg+"' is not supported")
+	}
+
+	// the algorithm is bound to the key, so a token can't
+	// make us use a public key as hmac secret
+	signed := []byte(parts[0] + "." + parts[1])
+	candidates := 0
+	verified := false
+	for _, key := range jv.keys {
+		if key.Alg != header.Alg || ("" != header.Kid && "" != key.ID && key.ID != header.Kid) {
+			continue
+		}
+		candidates++
+		if verifySignature(key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if 0 == candidates {
+		return nil, tokenError(ReasonUnknownKey, "No verification key known for this token")
+	}
+	if !verified {
+		return nil, tokenError(ReasonInvalidSignature, "Token signature is invalid")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); nil != err {
+		return nil, tokenError(ReasonMalformed, "Token claims are not valid: "+err.Error())
+	}
+	if err := jv.validateClaims(claims); nil != err {
+		return nil, err
+	}
+
+	scopes := claims.Scopes
+	if "" != claims.Scope {
+		scopes = append(scopes, strings.Fields(claims.Scope)...)
+	}
+	return &Identity{
+		Name:     JwtIdentityPrefix + claims.Subject,
+		Scopes:   scopes,
+		Storages: claims.Storages,
+	}, nil
+}
+
+func (jv *JwtVerifier) validateClaims(claims Claims) error {
+	now := float64(jv.now().Unix())
+	// tokens without subject would all share one identity
+	if "" == claims.Subject {
+		return tokenError(ReasonMalformed, "Token has no sub claim")
+	}
+	if nil == claims.ExpiresAt {
+		return tokenError(ReasonMalformed, "Token has no exp claim")
+	}
+	if now >= *claims.ExpiresAt {
+		return tokenError(ReasonExpired, "Token expired at "+time.Unix(int64(*claims.ExpiresAt), 0).UTC().Format(time.RFC3339))
+	}
+	if nil != claims.NotBefore && now < *claims.NotBefore {
+		return tokenError(ReasonNotYetValid, "Token is not valid before "+time.Unix(int64(*claims.NotBefore), 0).UTC().Format(time.RFC3339))
+	}
+
+	audienceMatches := false
+	for _, aud := range claims.Audience {
+		if aud == jv.audience {
+			audienceMatches = true
+			break
+		}
+	}
+	if !audienceMatches {
+		return tokenError(ReasonInvalidAudience, "Token audience does not contain '"+jv.audience+"'")
+	}
+
+	if "" != jv.issuer && claims.Issuer != jv.issuer {
+		return tokenError(ReasonInvalidIssuer, "Token issuer '"+claims.Issuer+"' is not accepted")
+	}
+	return nil
+}
+
+func verifySignature(key verificationKey, signed []byte, signature []byte) bool {
+	switch key.Alg {
+	case AlgHS256:
+		mac := hmac.New(sha256.New, key.Key.([]byte))
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case AlgRS256:
+		sum := sha256.Sum256(signed)
+		return nil == rsa.VerifyPKCS1v15(key.Key.(*rsa.PublicKey), crypto.SHA256, sum[:], signature)
+	case AlgES256:
+		// jws uses the raw r|s concatenation instead of asn.1
+		if 64 != len(signature) {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key.Key.(*ecdsa.PublicKey), sum[:], r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if nil != err {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
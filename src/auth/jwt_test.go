@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var testNow = time.Unix(1700000000, 0)
+
+// signToken builds a token with the given header and claims, key is a
+// hmac secret, an *rsa.PrivateKey or an *ecdsa.PrivateKey
+func signToken(t *testing.T, header map[string]interface{}, claims map[string]interface{}, key interface{}) string {
+	t.Helper()
+	encode := func(value interface{}) string {
+		data, err := json.Marshal(value)
+		if nil != err {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	sum := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch typed := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, typed)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, typed, crypto.SHA256, sum[:]); nil != err {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, typed, sum[:])
+		if nil != err {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims are accepted by the verifier of newTestVerifier
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   "alice",
+		"iss":   "https://issuer.example",
+		"aud":   "gitsapi",
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"scope": "read write",
+	}
+}
+
+type testKeys struct {
+	rsa      *rsa.PrivateKey
+	ecdsa    *ecdsa.PrivateKey
+	otherRsa *rsa.PrivateKey
+}
+
+// newTestVerifier knows the hmac secret, the rsa key as PEM file and
+// the ecdsa key with kid "ec1" from a JWKS file
+func newTestVerifier(t *testing.T) (*JwtVerifier, testKeys) {
+	t.Helper()
+	var keys testKeys
+	var err error
+	if keys.rsa, err = rsa.GenerateKey(rand.Reader, 2048); nil != err {
+		t.Fatal(err)
+	}
+	if keys.otherRsa, err = rsa.GenerateKey(rand.Reader, 2048); nil != err {
+		t.Fatal(err)
+	}
+	if keys.ecdsa, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); nil != err {
+		t.Fatal(err)
+	}
+
+	jv, err := NewJwtVerifier("gitsapi", "https://issuer.example")
+	if nil != err {
+		t.Fatal(err)
+	}
+	jv.now = func() time.Time { return testNow }
+	if err := jv.AddHmacSecret(testSecret); nil != err {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	der, err := x509.MarshalPKIXPublicKey(&keys.rsa.PublicKey)
+	if nil != err {
+		t.Fatal(err)
+	}
+	pemPath := filepath.Join(dir, "rsa.pem")
+	if err := os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); nil != err {
+		t.Fatal(err)
+	}
+	if err := jv.AddPublicKeyFile(pemPath); nil != err {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{{
+		"kty": "EC",
+		"kid": "ec1",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(keys.ecdsa.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(keys.ecdsa.Y.FillBytes(make([]byte, 32))),
+	}}})
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0600); nil != err {
+		t.Fatal(err)
+	}
+	if err := jv.AddJwksFile(jwksPath); nil != err {
+		t.Fatal(err)
+	}
+	return jv, keys
+}
+
+func TestVerify(t *testing.T) {
+	jv, keys := newTestVerifier(t)
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := validClaims()
+		for name, value := range changes {
+			if nil == value {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	for _, tc := range []struct {
+		name   string
+		token  string
+		reason string
+	}{
+		{"HS256", signToken(t, hs256, validClaims(), []byte(testSecret)), ""},
+		{"RS256", signToken(t, map[string]interface{}{"alg": "RS256"}, validClaims(), keys.rsa), ""},
+		{"ES256", signToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec1"}, validClaims(), keys.ecdsa), ""},
+		{"ES256 without kid", signToken(t, map[string]interface{}{"alg": "ES256"}, validClaims(), keys.ecdsa), ""},
+		{"audience list", signToken(t, hs256, with(map[string]interface{}{"aud": []string{"other", "gitsapi"}}), []byte(testSecret)), ""},
+		{"nbf passed", signToken(t, hs256, with(map[string]interface{}{"nbf": testNow.Unix()}), []byte(testSecret)), ""},
+
+		// alg
+		{"alg none", signToken(t, map[string]interface{}{"alg": "none"}, validClaims(), []byte(testSecret)), ReasonUnsupportedAlgorithm},
+		{"alg HS512", signToken(t, map[string]interface{}{"alg": "HS512"}, validClaims(), []byte(testSecret)), ReasonUnsupportedAlgorithm},
+		{"alg missing", signToken(t, map[string]interface{}{}, validClaims(), []byte(testSecret)), ReasonUnsupportedAlgorithm},
+		{"RS256 signed with the hmac secret", signToken(t, map[string]interface{}{"alg": "RS256"}, validClaims(), []byte(testSecret)), ReasonInvalidSignature},
+		{"RS256 signed by an unknown key", signToken(t, map[string]interface{}{"alg": "RS256"}, validClaims(), keys.otherRsa), ReasonInvalidSignature},
+		{"HS256 signed with another secret", signToken(t, hs256, validClaims(), []byte("another secret of at least 32 bytes")), ReasonInvalidSignature},
+		{"unknown kid", signToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec2"}, validClaims(), keys.ecdsa), ReasonUnknownKey},
+
+		// sub
+		{"sub missing", signToken(t, hs256, with(map[string]interface{}{"sub": nil}), []byte(testSecret)), ReasonMalformed},
+		{"sub empty", signToken(t, hs256, with(map[string]interface{}{"sub": ""}), []byte(testSecret)), ReasonMalformed},
+
+		// exp
+		{"exp missing", signToken(t, hs256, with(map[string]interface{}{"exp": nil}), []byte(testSecret)), ReasonMalformed},
+		{"exp passed", signToken(t, hs256, with(map[string]interface{}{"exp": testNow.Add(-time.Second).Unix()}), []byte(testSecret)), ReasonExpired},
+		{"exp now", signToken(t, hs256, with(map[string]interface{}{"exp": testNow.Unix()}), []byte(testSecret)), ReasonExpired},
+
+		// nbf
+		{"nbf in the future", signToken(t, hs256, with(map[string]interface{}{"nbf": testNow.Add(time.Second).Unix()}), []byte(testSecret)), ReasonNotYetValid},
+
+		// aud
+		{"aud missing", signToken(t, hs256, with(map[string]interface{}{"aud": nil}), []byte(testSecret)), ReasonInvalidAudience},
+		{"aud other", signToken(t, hs256, with(map[string]interface{}{"aud": "other"}), []byte(testSecret)), ReasonInvalidAudience},
+		{"aud list without ours", signToken(t, hs256, with(map[string]interface{}{"aud": []string{"a", "b"}}), []byte(testSecret)), ReasonInvalidAudience},
+		{"aud no string", signToken(t, hs256, with(map[string]interface{}{"aud": 1}), []byte(testSecret)), ReasonMalformed},
+
+		// iss
+		{"iss missing", signToken(t, hs256, with(map[string]interface{}{"iss": nil}), []byte(testSecret)), ReasonInvalidIssuer},
+		{"iss other", signToken(t, hs256, with(map[string]interface{}{"iss": "https://evil.example"}), []byte(testSecret)), ReasonInvalidIssuer},
+
+		// structure
+		{"two parts", "a.b", ReasonMalformed},
+		{"header no json", base64.RawURLEncoding.EncodeToString([]byte("nope")) + ".e30.", ReasonMalformed},
+		{"signature no base64", signToken(t, hs256, validClaims(), []byte(testSecret)) + "!", ReasonMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			identity, err := jv.Verify(tc.token)
+			if "" == tc.reason {
+				if nil != err {
+					t.Fatal(err)
+				}
+				want := &Identity{Name: "jwt:alice", Scopes: []string{"read", "write"}}
+				if !reflect.DeepEqual(want, identity) {
+					t.Errorf("expected %+v, got %+v", want, identity)
+				}
+				return
+			}
+			tokenErr, ok := err.(*TokenError)
+			if !ok {
+				t.Fatalf("expected a *TokenError, got %v", err)
+			}
+			if tc.reason != tokenErr.Reason {
+				t.Errorf("expected reason %s, got %s: %s", tc.reason, tokenErr.Reason, tokenErr.Message)
+			}
+		})
+	}
+}
+
+// without a configured issuer every issuer is accepted
+func TestVerifyWithoutIssuer(t *testing.T) {
+	jv, err := NewJwtVerifier("gitsapi", "")
+	if nil != err {
+		t.Fatal(err)
+	}
+	jv.now = func() time.Time { return testNow }
+	jv.AddHmacSecret(testSecret)
+
+	claims := validClaims()
+	claims["iss"] = "https://anyone.example"
+	claims["scopes"] = []string{"admin"}
+	claims["storages"] = []string{"team1"}
+	identity, err := jv.Verify(signToken(t, map[string]interface{}{"alg": "HS256"}, claims, []byte(testSecret)))
+	if nil != err {
+		t.Fatal(err)
+	}
+	want := &Identity{Name: "jwt:alice", Scopes: []string{"admin", "read", "write"}, Storages: []string{"team1"}}
+	if !reflect.DeepEqual(want, identity) {
+		t.Errorf("expected %+v, got %+v", want, identity)
+	}
+}
+
+func TestNewJwtVerifierRequiresAudience(t *testing.T) {
+	if _, err := NewJwtVerifier("", "https://issuer.example"); nil == err {
+		t.Error("verifier without audience got created")
+	}
+}
+
+func TestAddHmacSecretRejectsShortSecret(t *testing.T) {
+	jv, _ := NewJwtVerifier("gitsapi", "")
+	if err := jv.AddHmacSecret(testSecret[:31]); nil == err {
+		t.Error("short secret got accepted")
+	}
+	if jv.HasKeys() {
+		t.Error("short secret got added")
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+)
+
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Wildcard matches any identity, storage or entity type in a policy
+const Wildcard = "*"
+
+// AnonymousIdentity is used for policy lookups while
+// no authentication method is configured
+const AnonymousIdentity = "anonymous"
+
+// Policy decides which identity may do what within which storage. The
+// rules map identity -> storage -> action -> entity types, identities
+// and storages without an own entry fall back to the "*" entry.
+type Policy struct {
+	rules map[string]map[string]map[string][]string
+}
+
+// Grant holds the permissions of one identity within one storage.
+// A nil Grant allows everything.
+type Grant struct {
+	actions map[string]map[string]bool
+}
+
+func NewPolicy(rules map[string]map[string]map[string][]string) (*Policy, error) {
+	for identity, storages := range rules {
+		for storage, actions := range storages {
+			for action := range actions {
+				if ActionRead != action && ActionCreate != action && ActionUpdate != action && ActionDelete != action {
+					return nil, errors.New("Unknown action '" + action + "' in policy of identity '" + identity + "' for storage '" + storage + "'")
+				}
+			}
+		}
+	}
+	return &Policy{rules: rules}, nil
+}
+
+// LoadPolicy reads a json policy file
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, err
+	}
+	var rules map[string]map[string]map[string][]string
+	if err = json.Unmarshal(data, &rules); nil != err {
+		return nil, errors.New("Policy file content is not a valid json: " + err.Error())
+	}
+	return NewPolicy(rules)
+}
+
+// Grant returns the permissions of the identity within the storage,
+// ok is false if the identity may not use the storage at all
+func (p *Policy) Grant(identity string, storage string) (*Grant, bool) {
+	storages, ok := p.rules[identity]
+	if !ok {
+		storages, ok = p.rules[Wildcard]
+		if !ok {
+			return nil, false
+		}
+	}
+	actions, ok := storages[storage]
+	if !ok {
+		actions, ok = storages[Wildcard]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	grant := &Grant{actions: make(map[string]map[string]bool)}
+	for action, entityTypes := range actions {
+		grant.actions[action] = make(map[string]bool)
+		for _, entityType := range entityTypes {
+			grant.actions[action][entityType] = true
+		}
+	}
+	return grant, true
+}
+
+// Allows tells if the action may be applied to entities of the given type
+func (g *Grant) Allows(action string, entityType string) bool {
+	if nil == g {
+		return true
+	}
+	entityTypes := g.actions[action]
+	return entityTypes[entityType] || entityTypes[Wildcard]
+}
+
+// Unrestricted tells if the action is allowed for every entity type,
+// callers can skip filtering in this case
+func (g *Grant) Unrestricted(action string) bool {
+	return nil == g || g.actions[action][Wildcard]
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/voodooEntity/archivist"
 	"io/ioutil"
 	"os"
@@ -10,6 +11,10 @@ import (
 var Data = make(map[string]string)
 var requiredConfigs = [10]string{"HOST", "PORT", "LOG_TARGET", "LOG_PATH", "LOG_LEVEL", "CORS_HEADER", "CORS_ORIGIN", "SSL_CERT_FILE", "SSL_KEY_FILE", "PROTOCOL"}
 
+// optional configs enable additional features, if not set
+// Config.GetOptionalValue returns the given fallback
+var optionalConfigs = []string{"AUTH_KEYS_FILE", "JWT_AUDIENCE", "JWT_ISSUER", "JWT_HS256_SECRET", "JWT_PUBLIC_KEY_FILE", "JWT_JWKS_FILE", "POLICY_FILE", "STORAGE_AUTO_CREATE", "SNAPSHOT_DIR", "SNAPSHOT_INTERVAL", "JOURNAL_DIR", "JOURNAL_FSYNC", "JOURNAL_FSYNC_INTERVAL", "RDF_BASE_IRI", "MAX_PAGE_SIZE", "MAX_PATHS", "MAX_PATH_DEPTH", "MAX_PATH_STEPS", "ANALYTICS_WORKERS", "ANALYTICS_JOB_TTL", "ANALYTICS_MAX_QUEUED"}
+
 func Init(params map[string]string) {
 	// first lets check if there is a parseable config file
 	handleConfigFile()
@@ -38,6 +43,51 @@ func GetValue(key string) string {
 	return val
 }
 
+// Config holds the config of one server. Unlike the package level Data
+// it isn't shared, so servers with different configs can run side by side.
+type Config struct {
+	data map[string]string
+}
+
+// New returns a Config of the given params, neither the config file nor
+// the env are read. All required configs have to be given.
+func New(params map[string]string) (*Config, error) {
+	conf := &Config{data: make(map[string]string)}
+	for key, value := range params {
+		conf.data[key] = value
+	}
+	for _, val := range requiredConfigs {
+		if _, ok := conf.data[val]; !ok {
+			return nil, errors.New("Missing required config " + val)
+		}
+	}
+	return conf, nil
+}
+
+// Current returns a copy of the config loaded by Init
+func Current() (*Config, error) {
+	return New(Data)
+}
+
+// GetValue returns the value of a required config
+func (conf *Config) GetValue(key string) string {
+	return conf.data[key]
+}
+
+// GetOptionalValue returns the value of a config or the fallback if it
+// isn't set
+func (conf *Config) GetOptionalValue(key string, fallback string) string {
+	val, exist := conf.data[key]
+	if !exist || "" == val {
+		return fallback
+	}
+	return val
+}
+
+func knownConfigs() []string {
+	return append(requiredConfigs[:], optionalConfigs...)
+}
+
 func handleConfigParams(params map[string]string) {
 	if 0 < len(params) {
 		for key, value := range params {
@@ -48,7 +98,7 @@ func handleConfigParams(params map[string]string) {
 
 func handleEnv() {
 	// check the env for the required configs and overwrite/write in our Data map
-	for _, name := range requiredConfigs {
+	for _, name := range knownConfigs() {
 		value := os.Getenv(name)
 		if value != "" {
 			Data[name] = value
@@ -80,8 +130,8 @@ func handleConfigFile() {
 		return
 	}
 
-	// finally we write all given configs into our config Data map ### need to change this rn we can only have required configs Oo what the fuck was i tinking
-	for _, name := range requiredConfigs {
+	// finally we write all known configs into our config Data map
+	for _, name := range knownConfigs() {
 		value, ok := Conf[name]
 		if ok {
 			Data[name] = value
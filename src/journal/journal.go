@@ -0,0 +1,299 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileExtension of journal files, one file per storage
+const FileExtension = ".journal"
+
+// fsync policies
+const (
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncNever    = "never"
+)
+
+// Record is a single mutation. Records without Op only carry the
+// sequence reached at the last compaction.
+type Record struct {
+	Seq  uint64
+	Time time.Time
+	Op   string          `json:",omitempty"`
+	Data json.RawMessage `json:",omitempty"`
+}
+
+// Journal is the append only log of a storage. The mutex is held by
+// the api while a mutation is applied and appended, so the order of
+// the records is the order the mutations got applied in.
+type Journal struct {
+	mutex     sync.Mutex
+	path      string
+	policy    string
+	file      *os.File
+	seq       uint64
+	dirty     bool
+	replaying bool
+}
+
+// Lock serializes the mutations of the storage, locking a nil journal
+// does nothing so storages without journal don't need a special case
+func (j *Journal) Lock() {
+	if nil != j {
+		j.mutex.Lock()
+	}
+}
+
+// Unlock releases the lock taken by Lock
+func (j *Journal) Unlock() {
+	if nil != j {
+		j.mutex.Unlock()
+	}
+}
+
+// ValidPolicy reports if policy is a known fsync policy
+func ValidPolicy(policy string) bool {
+	return SyncAlways == policy || SyncInterval == policy || SyncNever == policy
+}
+
+// Path returns the journal file of the named storage inside dir
+func Path(dir string, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+FileExtension)
+}
+
+// Open opens or creates the journal file. A record torn by a crash
+// while appending is cut off.
+func Open(path string, policy string) (*Journal, error) {
+	if !ValidPolicy(policy) {
+		return nil, errors.New("Unknown journal fsync policy '" + policy + "'")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); nil != err {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if nil != err {
+		return nil, err
+	}
+
+	j := &Journal{path: path, policy: policy, file: file}
+	valid, err := j.scan(func(record Record) error {
+		j.seq = record.Seq
+		return nil
+	})
+	if nil == err {
+		err = file.Truncate(valid)
+	}
+	if nil != err {
+		file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// scan reads all records and returns the size of the valid part
+func (j *Journal) scan(fn func(record Record) error) (int64, error) {
+	if _, err := j.file.Seek(0, io.SeekStart); nil != err {
+		return 0, err
+	}
+	reader := bufio.NewReader(j.file)
+	var valid int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if io.EOF == err {
+			// an unterminated last line is a torn write
+			return valid, nil
+		}
+		if nil != err {
+			return valid, err
+		}
+		var record Record
+		if err = json.Unmarshal(line, &record); nil != err {
+			return valid, errors.New("Journal " + j.path + " is corrupt: " + err.Error())
+		}
+		if err = fn(record); nil != err {
+			return valid, err
+		}
+		valid += int64(len(line))
+	}
+}
+
+// Seq returns the sequence of the last appended record
+func (j *Journal) Seq() uint64 {
+	if nil == j {
+		return 0
+	}
+	return j.seq
+}
+
+// Replay calls fn for all records after the given sequence. Appends
+// done by fn are ignored, they are already part of the journal.
+func (j *Journal) Replay(after uint64, fn func(record Record) error) (int, error) {
+	j.replaying = true
+	defer func() { j.replaying = false }()
+
+	amount := 0
+	_, err := j.scan(func(record Record) error {
+		if "" == record.Op || record.Seq <= after {
+			return nil
+		}
+		amount++
+		return fn(record)
+	})
+	return amount, err
+}
+
+// Append writes a record of the mutation, with the always policy it
+// only returns after the record reached the disk. The caller has to
+// hold the mutex. Appending to a nil journal does nothing.
+func (j *Journal) Append(op string, data interface{}) error {
+	if nil == j || j.replaying {
+		return nil
+	}
+	if nil == j.file {
+		return errors.New("Journal " + j.path + " is closed")
+	}
+	raw, err := json.Marshal(data)
+	if nil != err {
+		return err
+	}
+	line, err := json.Marshal(Record{Seq: j.seq + 1, Time: time.Now().UTC(), Op: op, Data: raw})
+	if nil != err {
+		return err
+	}
+	if _, err = j.file.Write(append(line, '\n')); nil != err {
+		return err
+	}
+	j.seq++
+	if SyncAlways == j.policy {
+		return j.file.Sync()
+	}
+	j.dirty = true
+	return nil
+}
+
+// Sync flushes appended records to disk, used by the interval policy
+func (j *Journal) Sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if nil == j.file || !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// Compact drops all records up to the given sequence, they are part
+// of a snapshot by now. The caller has to hold the mutex.
+func (j *Journal) Compact(upTo uint64) error {
+	if nil == j.file {
+		return errors.New("Journal " + j.path + " is closed")
+	}
+
+	// the first line keeps the sequence, so it continues after a restart
+	var buffer bytes.Buffer
+	mark, _ := json.Marshal(Record{Seq: upTo, Time: time.Now().UTC()})
+	buffer.Write(append(mark, '\n'))
+	if _, err := j.scan(func(record Record) error {
+		if "" == record.Op || record.Seq <= upTo {
+			return nil
+		}
+		line, err := json.Marshal(record)
+		if nil != err {
+			return err
+		}
+		buffer.Write(append(line, '\n'))
+		return nil
+	}); nil != err {
+		return err
+	}
+
+	dir := filepath.Dir(j.path)
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(j.path)+".tmp-*")
+	if nil != err {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(buffer.Bytes()); nil == err {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); nil == err {
+		err = closeErr
+	}
+	if nil != err {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), j.path); nil != err {
+		return err
+	}
+
+	// keep appending to the new file
+	file, err := os.OpenFile(j.path, os.O_RDWR|os.O_APPEND, 0640)
+	if nil != err {
+		return err
+	}
+	j.file.Close()
+	j.file = file
+	j.dirty = false
+	if upTo > j.seq {
+		j.seq = upTo
+	}
+	return nil
+}
+
+// Close syncs and closes the file, the caller has to hold the mutex
+func (j *Journal) Close() error {
+	if nil == j.file {
+		return nil
+	}
+	err := j.file.Sync()
+	if closeErr := j.file.Close(); nil == err {
+		err = closeErr
+	}
+	j.file = nil
+	return err
+}
+
+// Remove closes and deletes the journal, the caller has to hold the mutex
+func (j *Journal) Remove() error {
+	j.Close()
+	err := os.Remove(j.path)
+	if nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the storage names of all journals inside dir
+func List(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), FileExtension) {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(file.Name(), FileExtension))
+		if nil != err {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
@@ -0,0 +1,224 @@
+package journal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// openTest opens a journal in a temp dir and appends the given ops, the
+// data of every record is its op
+func openTest(t *testing.T, ops ...string) (*Journal, string) {
+	t.Helper()
+	path := Path(t.TempDir(), "test")
+	j, err := Open(path, SyncAlways)
+	if nil != err {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { j.Close() })
+	for _, op := range ops {
+		if err := j.Append(op, op); nil != err {
+			t.Fatal(err)
+		}
+	}
+	return j, path
+}
+
+func replayed(t *testing.T, j *Journal, after uint64) []string {
+	t.Helper()
+	ops := []string{}
+	amount, err := j.Replay(after, func(record Record) error {
+		var data string
+		if err := json.Unmarshal(record.Data, &data); nil != err {
+			return err
+		}
+		if data != record.Op {
+			t.Errorf("record %d carries data %s instead of %s", record.Seq, data, record.Op)
+		}
+		ops = append(ops, record.Op)
+		return nil
+	})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if len(ops) != amount {
+		t.Errorf("replay reported %d records but passed %d", amount, len(ops))
+	}
+	return ops
+}
+
+func TestReplayAndCompact(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		ops     []string
+		compact uint64
+		after   uint64
+		want    []string
+		seq     uint64
+	}{
+		{"everything", []string{"a", "b", "c"}, 0, 0, []string{"a", "b", "c"}, 3},
+		{"after a sequence", []string{"a", "b", "c"}, 0, 2, []string{"c"}, 3},
+		{"after the last record", []string{"a", "b", "c"}, 0, 3, []string{}, 3},
+		{"compacted", []string{"a", "b", "c"}, 2, 0, []string{"c"}, 3},
+		{"compacted completely", []string{"a", "b", "c"}, 3, 0, []string{}, 3},
+		{"compacted beyond the last record", []string{"a"}, 5, 0, []string{}, 5},
+		{"empty", nil, 0, 0, []string{}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			j, path := openTest(t, tc.ops...)
+			if 0 < tc.compact {
+				if err := j.Compact(tc.compact); nil != err {
+					t.Fatal(err)
+				}
+			}
+			if got := replayed(t, j, tc.after); !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+			if tc.seq != j.Seq() {
+				t.Errorf("expected seq %d, got %d", tc.seq, j.Seq())
+			}
+
+			// the sequence continues after reopening, records appended
+			// later are kept by the compaction
+			j.Close()
+			reopened, err := Open(path, SyncAlways)
+			if nil != err {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if tc.seq != reopened.Seq() {
+				t.Errorf("expected seq %d after reopening, got %d", tc.seq, reopened.Seq())
+			}
+			if err := reopened.Append("next", "next"); nil != err {
+				t.Fatal(err)
+			}
+			if tc.seq+1 != reopened.Seq() {
+				t.Errorf("expected seq %d after append, got %d", tc.seq+1, reopened.Seq())
+			}
+			if got := replayed(t, reopened, tc.seq); !reflect.DeepEqual([]string{"next"}, got) {
+				t.Errorf("expected the appended record, got %v", got)
+			}
+		})
+	}
+}
+
+func TestCompactKeepsAppendingToTheNewFile(t *testing.T) {
+	j, _ := openTest(t, "a", "b")
+	if err := j.Compact(1); nil != err {
+		t.Fatal(err)
+	}
+	if err := j.Append("c", "c"); nil != err {
+		t.Fatal(err)
+	}
+	if got := replayed(t, j, 0); !reflect.DeepEqual([]string{"b", "c"}, got) {
+		t.Errorf("expected [b c], got %v", got)
+	}
+}
+
+func TestReplayIgnoresAppends(t *testing.T) {
+	j, _ := openTest(t, "a", "b")
+	if _, err := j.Replay(0, func(record Record) error {
+		return j.Append("again", record.Op)
+	}); nil != err {
+		t.Fatal(err)
+	}
+	if 2 != j.Seq() {
+		t.Errorf("appends during the replay got written, seq is %d", j.Seq())
+	}
+}
+
+func TestOpenCutsTornRecord(t *testing.T) {
+	j, path := openTest(t, "a", "b")
+	j.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+	if nil != err {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"Seq":3,"Op":"c","Da`)
+	file.Close()
+
+	reopened, err := Open(path, SyncAlways)
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if 2 != reopened.Seq() {
+		t.Errorf("expected seq 2, got %d", reopened.Seq())
+	}
+	if err := reopened.Append("c", "c"); nil != err {
+		t.Fatal(err)
+	}
+	if got := replayed(t, reopened, 0); !reflect.DeepEqual([]string{"a", "b", "c"}, got) {
+		t.Errorf("expected [a b c], got %v", got)
+	}
+}
+
+func TestOpenRejectsCorruptRecord(t *testing.T) {
+	path := Path(t.TempDir(), "test")
+	if err := os.WriteFile(path, []byte("no json\n"), 0640); nil != err {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, SyncAlways); nil == err {
+		t.Error("corrupt journal got opened")
+	}
+}
+
+func TestOpenRejectsUnknownPolicy(t *testing.T) {
+	if _, err := Open(Path(t.TempDir(), "test"), "sometimes"); nil == err {
+		t.Error("unknown policy got accepted")
+	}
+}
+
+func TestClosedJournal(t *testing.T) {
+	j, _ := openTest(t, "a")
+	if err := j.Close(); nil != err {
+		t.Fatal(err)
+	}
+	if err := j.Append("b", "b"); nil == err {
+		t.Error("append to a closed journal succeeded")
+	}
+	if err := j.Compact(1); nil == err {
+		t.Error("compaction of a closed journal succeeded")
+	}
+}
+
+func TestNilJournal(t *testing.T) {
+	var j *Journal
+	j.Lock()
+	j.Unlock()
+	if err := j.Append("a", "a"); nil != err {
+		t.Error(err)
+	}
+	if 0 != j.Seq() {
+		t.Errorf("expected seq 0, got %d", j.Seq())
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"team1", "with/slash", "with space"} {
+		j, err := Open(Path(dir, name), SyncNever)
+		if nil != err {
+			t.Fatal(err)
+		}
+		j.Close()
+	}
+	os.WriteFile(filepath.Join(dir, "other.txt"), nil, 0640)
+
+	names, err := List(dir)
+	if nil != err {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	if want := []string{"team1", "with space", "with/slash"}; !reflect.DeepEqual(want, names) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+
+	if names, err = List(filepath.Join(dir, "missing")); nil != err || 0 != len(names) {
+		t.Errorf("missing dir answered %v %v", names, err)
+	}
+}
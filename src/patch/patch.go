@@ -0,0 +1,296 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Operation is a single step of a JSON Patch (RFC 6902). Value stays
+// nil if the member is missing, so it can be told apart from null.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Error reports the operation a JSON Patch failed at
+type Error struct {
+	Index   int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "operation " + strconv.Itoa(e.Index) + ": " + e.Message
+}
+
+// Merge applies a JSON Merge Patch (RFC 7396) onto a decoded document.
+// Objects are merged recursively, null removes a member and anything
+// else replaces the target.
+func Merge(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if nil == value {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = Merge(targetObject[key], value)
+	}
+	return targetObject
+}
+
+// Apply runs the operations of a JSON Patch in order on a decoded
+// document. Either all operations succeed or an *Error is returned,
+// the document may be partially changed in that case.
+func Apply(document interface{}, operations []Operation) (interface{}, error) {
+	var err error
+	for index, operation := range operations {
+		if document, err = apply(document, operation); nil != err {
+			return nil, &Error{Index: index, Message: err.Error()}
+		}
+	}
+	return document, nil
+}
+
+func apply(document interface{}, operation Operation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if nil != err {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add", "replace", "test":
+		if nil == operation.Value {
+			return nil, &patchError{"missing value"}
+		}
+		var value interface{}
+		if err := json.Unmarshal(operation.Value, &value); nil != err {
+			return nil, err
+		}
+		switch operation.Op {
+		case "add":
+			return add(document, path, value)
+		case "replace":
+			return replace(document, path, value)
+		}
+		current, err := get(document, path)
+		if nil != err {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, &patchError{"test of '" + operation.Path + "' failed"}
+		}
+		return document, nil
+	case "remove":
+		return remove(document, path)
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if nil != err {
+			return nil, err
+		}
+		value, err := get(document, from)
+		if nil != err {
+			return nil, err
+		}
+		if "copy" == operation.Op {
+			return add(document, path, deepCopy(value))
+		}
+		// a value can't be moved into itself
+		if strings.HasPrefix(operation.Path+"/", operation.From+"/") && operation.Path != operation.From {
+			return nil, &patchError{"can't move '" + operation.From + "' into itself"}
+		}
+		if document, err = remove(document, from); nil != err {
+			return nil, err
+		}
+		return add(document, path, value)
+	}
+	return nil, &patchError{"unknown op '" + operation.Op + "'"}
+}
+
+type patchError struct {
+	message string
+}
+
+func (e *patchError) Error() string {
+	return e.message
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its tokens
+func parsePointer(pointer string) ([]string, error) {
+	if "" == pointer {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, &patchError{"invalid path '" + pointer + "'"}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func get(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch typed := node.(type) {
+		case map[string]interface{}:
+			value, ok := typed[token]
+			if !ok {
+				return nil, &patchError{"'" + token + "' does not exist"}
+			}
+			node = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(typed)-1)
+			if nil != err {
+				return nil, err
+			}
+			node = typed[index]
+		default:
+			return nil, &patchError{"'" + token + "' does not exist"}
+		}
+	}
+	return node, nil
+}
+
+// change walks to the container of the last token and lets fn change
+// it. Arrays may get replaced, so the changed containers are written
+// back on the way up.
+func change(node interface{}, path []string, fn func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if 1 == len(path) {
+		return fn(node, path[0])
+	}
+	switch typed := node.(type) {
+	case map[string]interface{}:
+		child, ok := typed[path[0]]
+		if !ok {
+			return nil, &patchError{"'" + path[0] + "' does not exist"}
+		}
+		child, err := change(child, path[1:], fn)
+		if nil != err {
+			return nil, err
+		}
+		typed[path[0]] = child
+		return typed, nil
+	case []interface{}:
+		index, err := arrayIndex(path[0], len(typed)-1)
+		if nil != err {
+			return nil, err
+		}
+		child, err := change(typed[index], path[1:], fn)
+		if nil != err {
+			return nil, err
+		}
+		typed[index] = child
+		return typed, nil
+	}
+	return nil, &patchError{"'" + path[0] + "' does not exist"}
+}
+
+func add(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if 0 == len(path) {
+		return value, nil
+	}
+	return change(document, path, func(container interface{}, token string) (interface{}, error) {
+		switch typed := container.(type) {
+		case map[string]interface{}:
+			typed[token] = value
+			return typed, nil
+		case []interface{}:
+			if "-" == token {
+				return append(typed, value), nil
+			}
+			index, err := arrayIndex(token, len(typed))
+			if nil != err {
+				return nil, err
+			}
+			typed = append(typed, nil)
+			copy(typed[index+1:], typed[index:])
+			typed[index] = value
+			return typed, nil
+		}
+		return nil, &patchError{"can't add '" + token + "' to a value"}
+	})
+}
+
+func remove(document interface{}, path []string) (interface{}, error) {
+	if 0 == len(path) {
+		return nil, &patchError{"can't remove the whole document"}
+	}
+	return change(document, path, func(container interface{}, token string) (interface{}, error) {
+		switch typed := container.(type) {
+		case map[string]interface{}:
+			if _, ok := typed[token]; !ok {
+				return nil, &patchError{"'" + token + "' does not exist"}
+			}
+			delete(typed, token)
+			return typed, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(typed)-1)
+			if nil != err {
+				return nil, err
+			}
+			return append(typed[:index], typed[index+1:]...), nil
+		}
+		return nil, &patchError{"'" + token + "' does not exist"}
+	})
+}
+
+func replace(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if 0 == len(path) {
+		return value, nil
+	}
+	return change(document, path, func(container interface{}, token string) (interface{}, error) {
+		switch typed := container.(type) {
+		case map[string]interface{}:
+			if _, ok := typed[token]; !ok {
+				return nil, &patchError{"'" + token + "' does not exist"}
+			}
+			typed[token] = value
+			return typed, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(typed)-1)
+			if nil != err {
+				return nil, err
+			}
+			typed[index] = value
+			return typed, nil
+		}
+		return nil, &patchError{"'" + token + "' does not exist"}
+	})
+}
+
+// arrayIndex parses an array index token which may be at most max
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if nil != err || 0 > index || max < index || (1 < len(token) && '0' == token[0]) {
+		return 0, &patchError{"invalid array index '" + token + "'"}
+	}
+	return index, nil
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(typed))
+		for key, child := range typed {
+			ret[key] = deepCopy(child)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(typed))
+		for i, child := range typed {
+			ret[i] = deepCopy(child)
+		}
+		return ret
+	}
+	return value
+}
@@ -0,0 +1,111 @@
+package patch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decode(t *testing.T, data string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(data), &value); nil != err {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// the cases are mostly taken from the examples of RFC 6902 appendix A
+func TestApply(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		document   string
+		operations string
+		want       string
+		// index of the failing operation, -1 if the patch succeeds
+		failsAt int
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, -1},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, -1},
+		{"add to array end", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, -1},
+		{"add replaces member", `{"foo":"bar"}`, `[{"op":"add","path":"/foo","value":1}]`, `{"foo":1}`, -1},
+		{"add null value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"baz":null,"foo":"bar"}`, -1},
+		{"add whole document", `{"foo":"bar"}`, `[{"op":"add","path":"","value":{"baz":1}}]`, `{"baz":1}`, -1},
+		{"add to missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ``, 0},
+		{"add beyond array end", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`, ``, 0},
+		{"add without value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, ``, 0},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, -1},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, -1},
+		{"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ``, 0},
+		{"remove whole document", `{"foo":"bar"}`, `[{"op":"remove","path":""}]`, ``, 0},
+		{"replace member", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, -1},
+		{"replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, ``, 0},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, -1},
+		{"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, -1},
+		{"move into itself", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, ``, 0},
+		{"copy member", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"add","path":"/baz/bar","value":2}]`, `{"baz":{"bar":2},"foo":{"bar":1}}`, -1},
+		{"test succeeds", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, -1},
+		{"test fails", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ``, 0},
+		{"test compares numbers by value", `{"foo":1}`, `[{"op":"test","path":"/foo","value":1.0}]`, `{"foo":1}`, -1},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`, -1},
+		{"invalid pointer", `{"foo":"bar"}`, `[{"op":"remove","path":"foo"}]`, ``, 0},
+		{"leading zero index", `{"foo":["a","b"]}`, `[{"op":"remove","path":"/foo/01"}]`, ``, 0},
+		{"unknown op", `{"foo":"bar"}`, `[{"op":"test","path":"/foo","value":"bar"},{"op":"merge","path":"/foo"}]`, ``, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var operations []Operation
+			if err := json.Unmarshal([]byte(tc.operations), &operations); nil != err {
+				t.Fatal(err)
+			}
+			got, err := Apply(decode(t, tc.document), operations)
+			if -1 != tc.failsAt {
+				patchErr, ok := err.(*Error)
+				if !ok {
+					t.Fatalf("expected an *Error, got %v", err)
+				}
+				if tc.failsAt != patchErr.Index {
+					t.Errorf("expected operation %d to fail, got %d: %s", tc.failsAt, patchErr.Index, patchErr.Message)
+				}
+				return
+			}
+			if nil != err {
+				t.Fatal(err)
+			}
+			if want := decode(t, tc.want); !reflect.DeepEqual(want, got) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
+
+// the examples of RFC 7396 appendix A
+func TestMerge(t *testing.T) {
+	for _, tc := range []struct {
+		target string
+		patch  string
+		want   string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		t.Run(tc.target+" "+tc.patch, func(t *testing.T) {
+			got := Merge(decode(t, tc.target), decode(t, tc.patch))
+			if want := decode(t, tc.want); !reflect.DeepEqual(want, got) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
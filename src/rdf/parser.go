@@ -0,0 +1,549 @@
+package rdf
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// term kinds
+const (
+	KindIRI = iota
+	KindBlank
+	KindLiteral
+)
+
+// RDFType is the predicate of type statements
+const RDFType = "http://www.w3.org/1999/02/22-rdf-syntax-ns#type"
+
+// Term is a subject, predicate or object of a triple. Literals keep
+// their lexical form, language and datatype are kept but not needed
+// by gits.
+type Term struct {
+	Kind     int
+	Value    string
+	Language string
+	Datatype string
+}
+
+// Triple is a single statement and the line its object is in
+type Triple struct {
+	Subject   Term
+	Predicate Term
+	Object    Term
+	Line      int
+}
+
+// ParseError reports the line the parser stopped in
+type ParseError struct {
+	Line    int
+	Message string
+}
+
+func (e *ParseError) Error() string {
+	return "line " + strconv.Itoa(e.Line) + ": " + e.Message
+}
+
+// Parse reads Turtle, N-Triples is a subset of it. Supported are
+// directives, prefixed names, blank node labels, predicate and object
+// lists and all literal forms. Anonymous blank nodes and collections
+// are rejected.
+func Parse(reader io.Reader, base string) ([]Triple, error) {
+	p := &parser{
+		reader:   bufio.NewReader(reader),
+		line:     1,
+		base:     base,
+		prefixes: make(map[string]string),
+	}
+	triples := []Triple{}
+	for {
+		p.skipSpace()
+		r, ok := p.peek()
+		if !ok {
+			return triples, p.err
+		}
+		if '@' == r || 'P' == r || 'p' == r || 'B' == r || 'b' == r {
+			handled, err := p.directive()
+			if nil != err {
+				return nil, err
+			}
+			if handled {
+				continue
+			}
+		}
+		statement, err := p.triples()
+		if nil != err {
+			return nil, err
+		}
+		triples = append(triples, statement...)
+	}
+}
+
+type parser struct {
+	reader   *bufio.Reader
+	line     int
+	base     string
+	prefixes map[string]string
+	// a rune pushed back by unread
+	pending []rune
+	err     error
+}
+
+func (p *parser) fail(message string) error {
+	return &ParseError{Line: p.line, Message: message}
+}
+
+func (p *parser) next() (rune, bool) {
+	if 0 < len(p.pending) {
+		r := p.pending[len(p.pending)-1]
+		p.pending = p.pending[:len(p.pending)-1]
+		if '\n' == r {
+			p.line++
+		}
+		return r, true
+	}
+	r, _, err := p.reader.ReadRune()
+	if nil != err {
+		if io.EOF != err {
+			p.err = err
+		}
+		return 0, false
+	}
+	if '\n' == r {
+		p.line++
+	}
+	return r, true
+}
+
+func (p *parser) unread(r rune) {
+	if '\n' == r {
+		p.line--
+	}
+	p.pending = append(p.pending, r)
+}
+
+func (p *parser) peek() (rune, bool) {
+	r, ok := p.next()
+	if ok {
+		p.unread(r)
+	}
+	return r, ok
+}
+
+// skipSpace skips whitespace and comments
+func (p *parser) skipSpace() {
+	for {
+		r, ok := p.next()
+		if !ok {
+			return
+		}
+		if '#' == r {
+			for ok && '\n' != r {
+				r, ok = p.next()
+			}
+			continue
+		}
+		if !unicode.IsSpace(r) {
+			p.unread(r)
+			return
+		}
+	}
+}
+
+func (p *parser) expect(expected rune) error {
+	p.skipSpace()
+	r, ok := p.next()
+	if !ok || expected != r {
+		return p.fail("expected '" + string(expected) + "'")
+	}
+	return nil
+}
+
+// directive handles @prefix, @base and their SPARQL style variants. It
+// returns false if the word turns out to be a prefixed name instead.
+func (p *parser) directive() (bool, error) {
+	word := p.word()
+	sparql := false
+	switch {
+	case "@prefix" == word || "@base" == word:
+	case strings.EqualFold("prefix", word) || strings.EqualFold("base", word):
+		r, _ := p.peek()
+		if !unicode.IsSpace(r) {
+			p.unreadWord(word)
+			return false, nil
+		}
+		sparql = true
+	default:
+		p.unreadWord(word)
+		return false, nil
+	}
+
+	p.skipSpace()
+	if strings.HasSuffix(strings.ToLower(word), "prefix") {
+		prefix := p.word()
+		if !strings.HasSuffix(prefix, ":") {
+			return true, p.fail("expected a prefix ending with ':'")
+		}
+		p.skipSpace()
+		iri, err := p.iriRef()
+		if nil != err {
+			return true, err
+		}
+		p.prefixes[strings.TrimSuffix(prefix, ":")] = iri
+	} else {
+		iri, err := p.iriRef()
+		if nil != err {
+			return true, err
+		}
+		p.base = iri
+	}
+	if !sparql {
+		return true, p.expect('.')
+	}
+	return true, nil
+}
+
+// word reads name characters, a trailing dot ends the statement and
+// is left for the caller
+func (p *parser) word() string {
+	var ret []rune
+	for {
+		r, ok := p.next()
+		if !ok {
+			break
+		}
+		if unicode.IsSpace(r) || strings.ContainsRune(";,<>\"'()[]#^", r) {
+			p.unread(r)
+			break
+		}
+		if '\\' == r {
+			// escaped characters of local names are taken as they are
+			if escaped, ok := p.next(); ok {
+				ret = append(ret, escaped)
+			}
+			continue
+		}
+		ret = append(ret, r)
+	}
+	for 0 < len(ret) && '.' == ret[len(ret)-1] {
+		p.unread('.')
+		ret = ret[:len(ret)-1]
+	}
+	return string(ret)
+}
+
+func (p *parser) unreadWord(word string) {
+	runes := []rune(word)
+	for i := len(runes) - 1; i >= 0; i-- {
+		p.unread(runes[i])
+	}
+}
+
+func (p *parser) triples() ([]Triple, error) {
+	subject, err := p.term(false)
+	if nil != err {
+		return nil, err
+	}
+	if KindLiteral == subject.Kind {
+		return nil, p.fail("a literal can't be a subject")
+	}
+
+	ret := []Triple{}
+	for {
+		predicate, err := p.term(true)
+		if nil != err {
+			return nil, err
+		}
+		if KindIRI != predicate.Kind {
+			return nil, p.fail("predicates have to be IRIs")
+		}
+		for {
+			// triples report the line their object is in
+			p.skipSpace()
+			line := p.line
+			object, err := p.term(false)
+			if nil != err {
+				return nil, err
+			}
+			ret = append(ret, Triple{Subject: subject, Predicate: predicate, Object: object, Line: line})
+			p.skipSpace()
+			r, ok := p.next()
+			if !ok {
+				return nil, p.fail("unexpected end of document, expected '.'")
+			}
+			if ',' == r {
+				continue
+			}
+			p.unread(r)
+			break
+		}
+
+		p.skipSpace()
+		r, ok := p.next()
+		if !ok {
+			return nil, p.fail("unexpected end of document, expected '.'")
+		}
+		if '.' == r {
+			return ret, nil
+		}
+		if ';' != r {
+			return nil, p.fail("expected ';', ',' or '.'")
+		}
+		// repeated and trailing semicolons are allowed
+		for {
+			p.skipSpace()
+			if r, ok = p.next(); !ok {
+				return nil, p.fail("unexpected end of document, expected '.'")
+			}
+			if ';' != r {
+				break
+			}
+		}
+		if '.' == r {
+			return ret, nil
+		}
+		p.unread(r)
+	}
+}
+
+// term reads an IRI, blank node or literal
+func (p *parser) term(isPredicate bool) (Term, error) {
+	p.skipSpace()
+	r, ok := p.peek()
+	if !ok {
+		return Term{}, p.fail("unexpected end of document")
+	}
+	switch {
+	case '<' == r:
+		iri, err := p.iriRef()
+		return Term{Kind: KindIRI, Value: iri}, err
+	case '"' == r || '\'' == r:
+		return p.literal()
+	case '[' == r || '(' == r:
+		return Term{}, p.fail("anonymous blank nodes and collections are not supported")
+	}
+
+	word := p.word()
+	switch {
+	case "" == word:
+		return Term{}, p.fail("unexpected character '" + string(r) + "'")
+	case "a" == word && isPredicate:
+		return Term{Kind: KindIRI, Value: RDFType}, nil
+	case strings.HasPrefix(word, "_:"):
+		return Term{Kind: KindBlank, Value: word}, nil
+	case "true" == word || "false" == word:
+		return Term{Kind: KindLiteral, Value: word, Datatype: "http://www.w3.org/2001/XMLSchema#boolean"}, nil
+	case numberPattern.MatchString(word):
+		return Term{Kind: KindLiteral, Value: word, Datatype: numberDatatype(word)}, nil
+	}
+
+	prefix, local, found := strings.Cut(word, ":")
+	if !found {
+		return Term{}, p.fail("unknown token '" + word + "'")
+	}
+	namespace, ok := p.prefixes[prefix]
+	if !ok {
+		return Term{}, p.fail("undefined prefix '" + prefix + ":'")
+	}
+	return Term{Kind: KindIRI, Value: namespace + local}, nil
+}
+
+var numberPattern = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+func numberDatatype(number string) string {
+	switch {
+	case strings.ContainsAny(number, "eE"):
+		return "http://www.w3.org/2001/XMLSchema#double"
+	case strings.Contains(number, "."):
+		return "http://www.w3.org/2001/XMLSchema#decimal"
+	}
+	return "http://www.w3.org/2001/XMLSchema#integer"
+}
+
+// iriRef reads <...> and resolves it against the base
+func (p *parser) iriRef() (string, error) {
+	if r, ok := p.next(); !ok || '<' != r {
+		return "", p.fail("expected '<'")
+	}
+	var ret strings.Builder
+	for {
+		r, ok := p.next()
+		if !ok {
+			return "", p.fail("unterminated IRI")
+		}
+		if '>' == r {
+			break
+		}
+		if '\\' == r {
+			decoded, err := p.unicodeEscape()
+			if nil != err {
+				return "", err
+			}
+			r = decoded
+		} else if unicode.IsSpace(r) {
+			return "", p.fail("whitespace in IRI")
+		}
+		ret.WriteRune(r)
+	}
+	return resolveIRI(p.base, ret.String()), nil
+}
+
+var schemePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*:`)
+
+func resolveIRI(base string, ref string) string {
+	if "" == base || schemePattern.MatchString(ref) {
+		return ref
+	}
+	baseURL, err := url.Parse(base)
+	if nil == err && "" == baseURL.Opaque && "" != baseURL.Scheme {
+		if refURL, err := url.Parse(ref); nil == err {
+			return baseURL.ResolveReference(refURL).String()
+		}
+	}
+	return base + ref
+}
+
+func (p *parser) unicodeEscape() (rune, error) {
+	r, ok := p.next()
+	if !ok || ('u' != r && 'U' != r) {
+		return 0, p.fail("invalid escape sequence")
+	}
+	length := 4
+	if 'U' == r {
+		length = 8
+	}
+	hex := make([]rune, 0, length)
+	for i := 0; i < length; i++ {
+		h, ok := p.next()
+		if !ok {
+			return 0, p.fail("invalid unicode escape")
+		}
+		hex = append(hex, h)
+	}
+	code, err := strconv.ParseUint(string(hex), 16, 32)
+	if nil != err {
+		return 0, p.fail("invalid unicode escape")
+	}
+	return rune(code), nil
+}
+
+// literal reads a short or long string with optional language tag or
+// datatype
+func (p *parser) literal() (Term, error) {
+	quote, _ := p.next()
+	long := false
+	if second, ok := p.next(); ok && quote == second {
+		if third, ok := p.next(); ok && quote == third {
+			long = true
+		} else {
+			// empty string
+			if ok {
+				p.unread(third)
+			}
+			return p.literalSuffix(Term{Kind: KindLiteral})
+		}
+	} else if ok {
+		p.unread(second)
+	}
+
+	var value strings.Builder
+	for {
+		r, ok := p.next()
+		if !ok {
+			return Term{}, p.fail("unterminated string")
+		}
+		if '\\' == r {
+			escaped, err := p.stringEscape()
+			if nil != err {
+				return Term{}, err
+			}
+			value.WriteRune(escaped)
+			continue
+		}
+		if quote == r {
+			if !long {
+				break
+			}
+			// a long string ends with three quotes
+			second, ok2 := p.next()
+			if ok2 && quote == second {
+				third, ok3 := p.next()
+				if ok3 && quote == third {
+					break
+				}
+				if ok3 {
+					p.unread(third)
+				}
+			} else if ok2 {
+				p.unread(second)
+				value.WriteRune(r)
+				continue
+			}
+			if ok2 {
+				value.WriteRune(r)
+				value.WriteRune(second)
+				continue
+			}
+		}
+		if !long && ('\n' == r || '\r' == r) {
+			return Term{}, p.fail("line break in string, use a long string")
+		}
+		value.WriteRune(r)
+	}
+	return p.literalSuffix(Term{Kind: KindLiteral, Value: value.String()})
+}
+
+func (p *parser) stringEscape() (rune, error) {
+	r, ok := p.next()
+	if !ok {
+		return 0, p.fail("invalid escape sequence")
+	}
+	switch r {
+	case 't':
+		return '\t', nil
+	case 'b':
+		return '\b', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 'f':
+		return '\f', nil
+	case '"', '\'', '\\':
+		return r, nil
+	case 'u', 'U':
+		p.unread(r)
+		return p.unicodeEscape()
+	}
+	return 0, p.fail("invalid escape sequence '\\" + string(r) + "'")
+}
+
+func (p *parser) literalSuffix(term Term) (Term, error) {
+	r, ok := p.next()
+	if !ok {
+		return term, nil
+	}
+	switch r {
+	case '@':
+		term.Language = p.word()
+	case '^':
+		if second, ok := p.next(); !ok || '^' != second {
+			return Term{}, p.fail("expected '^^'")
+		}
+		datatype, err := p.term(false)
+		if nil != err {
+			return Term{}, err
+		}
+		if KindIRI != datatype.Kind {
+			return Term{}, p.fail("datatypes have to be IRIs")
+		}
+		term.Datatype = datatype.Value
+	default:
+		p.unread(r)
+	}
+	return term, nil
+}
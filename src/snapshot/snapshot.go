@@ -0,0 +1,278 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/voodooEntity/gits/src/storage"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// Format identifies snapshot files, FormatVersion gets raised on every
+// incompatible change of the Snapshot struct
+const (
+	Format        = "gitsapi-snapshot"
+	FormatVersion = 1
+	FileExtension = ".snapshot.json"
+)
+
+// Snapshot is the complete content of a gits storage. IDs are kept as
+// they are, so references held by clients stay valid after a restore.
+type Snapshot struct {
+	Format        string
+	FormatVersion int
+	Storage       string
+	CreatedAt     time.Time
+	// the last journal record contained in the snapshot
+	JournalSeq      uint64 `json:",omitempty"`
+	EntityTypeIDMax int
+	EntityTypes     map[int]string
+	EntityIDMax     map[int]int
+	Entities        []types.StorageEntity
+	Relations       []types.StorageRelation
+}
+
+// Capture copies the content of the storage, all storage mutexes are
+// held at once so entities and relations are consistent to each other
+func Capture(name string, store *storage.Storage) Snapshot {
+	store.EntityTypeMutex.RLock()
+	store.EntityStorageMutex.RLock()
+	store.EntityIDMaxMutex.RLock()
+	store.RelationStorageMutex.RLock()
+	defer store.RelationStorageMutex.RUnlock()
+	defer store.EntityIDMaxMutex.RUnlock()
+	defer store.EntityStorageMutex.RUnlock()
+	defer store.EntityTypeMutex.RUnlock()
+
+	snap := Snapshot{
+		Format:          Format,
+		FormatVersion:   FormatVersion,
+		Storage:         name,
+		CreatedAt:       time.Now().UTC(),
+		EntityTypeIDMax: store.EntityTypeIDMax,
+		EntityTypes:     make(map[int]string),
+		EntityIDMax:     make(map[int]int),
+		Entities:        []types.StorageEntity{},
+		Relations:       []types.StorageRelation{},
+	}
+	for typeID, typeName := range store.EntityTypes {
+		snap.EntityTypes[typeID] = typeName
+	}
+	for typeID, idMax := range store.EntityIDMax {
+		snap.EntityIDMax[typeID] = idMax
+	}
+	for _, entities := range store.EntityStorage {
+		for _, entity := range entities {
+			entity.Properties = copyProperties(entity.Properties)
+			snap.Entities = append(snap.Entities, entity)
+		}
+	}
+	for _, sourceIDs := range store.RelationStorage {
+		for _, targetTypes := range sourceIDs {
+			for _, targetIDs := range targetTypes {
+				for _, relation := range targetIDs {
+					relation.Properties = copyProperties(relation.Properties)
+					snap.Relations = append(snap.Relations, relation)
+				}
+			}
+		}
+	}
+	return snap
+}
+
+// Restore replaces the whole content of the storage by the snapshot.
+// The new maps are built first, so the storage is only locked while
+// they get swapped in.
+func (snap Snapshot) Restore(store *storage.Storage) error {
+	entityTypes := make(map[int]string)
+	entityRTypes := make(map[string]int)
+	entityIDMax := make(map[int]int)
+	entityStorage := make(map[int]map[int]types.StorageEntity)
+	relationStorage := make(map[int]map[int]map[int]map[int]types.StorageRelation)
+	relationRStorage := make(map[int]map[int]map[int]map[int]bool)
+
+	for typeID, typeName := range snap.EntityTypes {
+		if typeID > snap.EntityTypeIDMax {
+			return errors.New("Entity type id " + strconv.Itoa(typeID) + " exceeds the stored type id max")
+		}
+		entityTypes[typeID] = typeName
+		entityRTypes[typeName] = typeID
+		entityIDMax[typeID] = snap.EntityIDMax[typeID]
+		entityStorage[typeID] = make(map[int]types.StorageEntity)
+		relationStorage[typeID] = make(map[int]map[int]map[int]types.StorageRelation)
+		relationRStorage[typeID] = make(map[int]map[int]map[int]bool)
+	}
+
+	for _, entity := range snap.Entities {
+		if _, ok := entityTypes[entity.Type]; !ok {
+			return errors.New("Entity " + strconv.Itoa(entity.ID) + " has the unknown type id " + strconv.Itoa(entity.Type))
+		}
+		if entity.ID > entityIDMax[entity.Type] {
+			return errors.New("Entity " + strconv.Itoa(entity.ID) + " exceeds the stored id max of its type")
+		}
+		if nil == entity.Properties {
+			entity.Properties = make(map[string]string)
+		}
+		entityStorage[entity.Type][entity.ID] = entity
+		relationStorage[entity.Type][entity.ID] = make(map[int]map[int]types.StorageRelation)
+		relationRStorage[entity.Type][entity.ID] = make(map[int]map[int]bool)
+	}
+
+	for _, relation := range snap.Relations {
+		if _, ok := entityStorage[relation.SourceType][relation.SourceID]; !ok {
+			return errors.New("Relation source " + strconv.Itoa(relation.SourceType) + ":" + strconv.Itoa(relation.SourceID) + " does not exist")
+		}
+		if _, ok := entityStorage[relation.TargetType][relation.TargetID]; !ok {
+			return errors.New("Relation target " + strconv.Itoa(relation.TargetType) + ":" + strconv.Itoa(relation.TargetID) + " does not exist")
+		}
+		if _, ok := relationStorage[relation.SourceType][relation.SourceID][relation.TargetType]; !ok {
+			relationStorage[relation.SourceType][relation.SourceID][relation.TargetType] = make(map[int]types.StorageRelation)
+		}
+		if _, ok := relationRStorage[relation.TargetType][relation.TargetID][relation.SourceType]; !ok {
+			relationRStorage[relation.TargetType][relation.TargetID][relation.SourceType] = make(map[int]bool)
+		}
+		relationStorage[relation.SourceType][relation.SourceID][relation.TargetType][relation.TargetID] = relation
+		relationRStorage[relation.TargetType][relation.TargetID][relation.SourceType][relation.SourceID] = true
+	}
+
+	store.EntityTypeMutex.Lock()
+	store.EntityStorageMutex.Lock()
+	store.EntityIDMaxMutex.Lock()
+	store.RelationStorageMutex.Lock()
+	store.EntityTypeIDMax = snap.EntityTypeIDMax
+	store.EntityTypes = entityTypes
+	store.EntityRTypes = entityRTypes
+	store.EntityIDMax = entityIDMax
+	store.EntityStorage = entityStorage
+	store.RelationStorage = relationStorage
+	store.RelationRStorage = relationRStorage
+	store.RelationStorageMutex.Unlock()
+	store.EntityIDMaxMutex.Unlock()
+	store.EntityStorageMutex.Unlock()
+	store.EntityTypeMutex.Unlock()
+	return nil
+}
+
+// Path returns the file of the named storage inside dir
+func Path(dir string, name string) string {
+	return filepath.Join(dir, url.PathEscape(name)+FileExtension)
+}
+
+// Write stores the snapshot into dir. The data is written to a temp
+// file first and renamed afterwards, so a crash never leaves a half
+// written snapshot behind.
+func Write(dir string, snap Snapshot) (string, error) {
+	if err := os.MkdirAll(dir, 0750); nil != err {
+		return "", err
+	}
+	path := Path(dir, snap.Storage)
+	tmpFile, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp-*")
+	if nil != err {
+		return "", err
+	}
+	// removing fails silently after a successful rename
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	if err = json.NewEncoder(writer).Encode(snap); nil != err {
+		tmpFile.Close()
+		return "", err
+	}
+	if err = writer.Flush(); nil != err {
+		tmpFile.Close()
+		return "", err
+	}
+	if err = tmpFile.Sync(); nil != err {
+		tmpFile.Close()
+		return "", err
+	}
+	if err = tmpFile.Close(); nil != err {
+		return "", err
+	}
+	if err = os.Rename(tmpFile.Name(), path); nil != err {
+		return "", err
+	}
+	return path, syncDir(dir)
+}
+
+// Read loads and validates a snapshot file
+func Read(path string) (Snapshot, error) {
+	file, err := os.Open(path)
+	if nil != err {
+		return Snapshot{}, err
+	}
+	defer file.Close()
+
+	var snap Snapshot
+	if err = json.NewDecoder(bufio.NewReader(file)).Decode(&snap); nil != err {
+		return Snapshot{}, errors.New("Snapshot file " + path + " is not readable: " + err.Error())
+	}
+	if Format != snap.Format {
+		return Snapshot{}, errors.New("File " + path + " is no gitsapi snapshot")
+	}
+	if FormatVersion != snap.FormatVersion {
+		return Snapshot{}, errors.New("Snapshot file " + path + " has the unsupported format version " + strconv.Itoa(snap.FormatVersion))
+	}
+	return snap, nil
+}
+
+// List returns the storage names of all snapshots inside dir
+func List(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if nil != err {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), FileExtension) {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(file.Name(), FileExtension))
+		if nil != err {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// Remove deletes the snapshot of the named storage if there is one
+func Remove(dir string, name string) error {
+	err := os.Remove(Path(dir, name))
+	if nil != err && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir makes the rename itself durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if nil != err {
+		return err
+	}
+	defer d.Close()
+	// not every platform supports syncing directories
+	d.Sync()
+	return nil
+}
+
+func copyProperties(properties map[string]string) map[string]string {
+	ret := make(map[string]string, len(properties))
+	for key, value := range properties {
+		ret[key] = value
+	}
+	return ret
+}
@@ -0,0 +1,193 @@
+package gitsapi
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/voodooEntity/gits"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// Statistics describes the shape of the graphs, one entry per storage
+type Statistics struct {
+	Storages []StorageStatistics
+	Amount   int
+}
+
+// StorageStatistics extends the StorageInfo of a storage. Only the entity
+// types the caller may read are counted, relations only if both of their
+// ends may be read.
+type StorageStatistics struct {
+	StorageInfo
+	Relations []RelationStatistics
+	Contexts  []ContextStatistics
+	// the amount of entities having a property key, per entity type
+	PropertyKeys map[string]map[string]int
+	// relations per entity, every relation counts for both of its ends
+	AverageDegree float64
+}
+
+// RelationStatistics counts the relations between two entity types in
+// a context
+type RelationStatistics struct {
+	SourceType string
+	TargetType string
+	Context    string
+	Amount     int
+}
+
+// ContextStatistics counts the entities and relations of a context,
+// the empty context is left out
+type ContextStatistics struct {
+	Context   string
+	Entities  int
+	Relations int
+}
+
+func (s *Server) registerStatisticsRoutes() {
+	// Route: /v1/statistics
+	s.handleFunc("/v1/statistics", s.requireScopeAllStorages(auth.ScopeRead, s.handleStatistics))
+}
+
+// handleStatistics reports all storages the caller may access, or only
+// the one given by the Storage header
+func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "GET" != r.Method {
+		respondError(methodNotAllowedError("GET"), w)
+		return
+	}
+
+	identity := identityFromRequest(r)
+	result := Statistics{Storages: []StorageStatistics{}}
+	if name := r.Header.Get("Storage"); "" != name {
+		grant, ok := s.storageGrant(identity, name)
+		if !ok {
+			respondError(storageForbiddenError(name), w)
+			return
+		}
+		g, apiErr := s.resolveStorage(name)
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		result.Storages = append(result.Storages, storageStatistics(g, grant))
+	} else {
+		// storages the caller can't access are left out silently
+		for _, name := range s.storages.names() {
+			grant, ok := s.storageGrant(identity, name)
+			if !ok {
+				continue
+			}
+			if g := s.storages.get(name); nil != g {
+				result.Storages = append(result.Storages, storageStatistics(g, grant))
+			}
+		}
+	}
+	result.Amount = len(result.Storages)
+	respondJson(result, 200, w)
+}
+
+// storageStatistics collects the statistics in one pass over the entities
+// and one over the relations, without copying them
+func storageStatistics(g *instance, grant *auth.Grant) StorageStatistics {
+	stats := StorageStatistics{
+		StorageInfo: StorageInfo{
+			Name:        g.Name,
+			EntityTypes: make(map[string]int),
+		},
+		Relations:    []RelationStatistics{},
+		Contexts:     []ContextStatistics{},
+		PropertyKeys: make(map[string]map[string]int),
+	}
+	if defaultInstance := gits.GetDefault(); nil != defaultInstance {
+		stats.Default = defaultInstance.Name == g.Name
+	}
+	contexts := make(map[string]*ContextStatistics)
+	contextOf := func(name string) *ContextStatistics {
+		if _, ok := contexts[name]; !ok {
+			contexts[name] = &ContextStatistics{Context: name}
+		}
+		return contexts[name]
+	}
+
+	store := g.Storage()
+	entityTypes := filterEntityTypes(grant, store.GetEntityTypes())
+
+	store.EntityStorageMutex.RLock()
+	for typeID, typeName := range entityTypes {
+		entities := store.EntityStorage[typeID]
+		stats.EntityTypes[typeName] = len(entities)
+		stats.EntityAmount += len(entities)
+		propertyKeys := make(map[string]int)
+		for _, entity := range entities {
+			for key := range entity.Properties {
+				propertyKeys[key]++
+			}
+			if "" != entity.Context {
+				contextOf(entity.Context).Entities++
+			}
+		}
+		stats.PropertyKeys[typeName] = propertyKeys
+	}
+	store.EntityStorageMutex.RUnlock()
+
+	type relationKey struct {
+		sourceType int
+		targetType int
+		context    string
+	}
+	relations := make(map[relationKey]int)
+	store.RelationStorageMutex.RLock()
+	for sourceType, sourceIDs := range store.RelationStorage {
+		if _, ok := entityTypes[sourceType]; !ok {
+			continue
+		}
+		for _, targetTypes := range sourceIDs {
+			for targetType, targetIDs := range targetTypes {
+				if _, ok := entityTypes[targetType]; !ok {
+					continue
+				}
+				for _, relation := range targetIDs {
+					relations[relationKey{sourceType: sourceType, targetType: targetType, context: relation.Context}]++
+					if "" != relation.Context {
+						contextOf(relation.Context).Relations++
+					}
+				}
+			}
+		}
+	}
+	store.RelationStorageMutex.RUnlock()
+
+	for key, amount := range relations {
+		stats.Relations = append(stats.Relations, RelationStatistics{
+			SourceType: entityTypes[key.sourceType],
+			TargetType: entityTypes[key.targetType],
+			Context:    key.context,
+			Amount:     amount,
+		})
+		stats.RelationAmount += amount
+	}
+	sort.Slice(stats.Relations, func(i, j int) bool {
+		a, b := stats.Relations[i], stats.Relations[j]
+		if a.SourceType != b.SourceType {
+			return a.SourceType < b.SourceType
+		}
+		if a.TargetType != b.TargetType {
+			return a.TargetType < b.TargetType
+		}
+		return a.Context < b.Context
+	})
+	for _, context := range contexts {
+		stats.Contexts = append(stats.Contexts, *context)
+	}
+	sort.Slice(stats.Contexts, func(i, j int) bool { return stats.Contexts[i].Context < stats.Contexts[j].Context })
+	if 0 < stats.EntityAmount {
+		stats.AverageDegree = 2 * float64(stats.RelationAmount) / float64(stats.EntityAmount)
+	}
+	return stats
+}
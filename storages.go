@@ -0,0 +1,281 @@
+package gitsapi
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/voodooEntity/archivist"
+	"github.com/voodooEntity/gits"
+	"github.com/voodooEntity/gits/src/types"
+)
+
+// storageSet keeps track of the gits instances a server knows about,
+// gits can neither list nor remove its instances. Dropped instances stay
+// in the gits index, they are emptied and hidden from all servers instead.
+// The instances themselves are shared by all servers of the process, so
+// a storage should only be written through one server since the write
+// locks and journals belong to the server.
+type storageSet struct {
+	index map[string]bool
+	mutex *sync.RWMutex
+	// the write locks of all storages, created on first use
+	writeLocks      map[string]*sync.Mutex
+	writeLocksMutex *sync.Mutex
+	// nil unless JOURNAL_DIR is configured
+	journals *journalSet
+}
+
+// droppedStorages are the names dropped by any server of the process.
+// Like the instances it is process global, otherwise the other servers
+// would keep listing and writing the emptied instance. A name is reused
+// once any server creates it again.
+var droppedStorages = struct {
+	names map[string]bool
+	mutex *sync.Mutex
+}{
+	names: make(map[string]bool),
+	mutex: &sync.Mutex{},
+}
+
+func isDropped(name string) bool {
+	droppedStorages.mutex.Lock()
+	defer droppedStorages.mutex.Unlock()
+	return droppedStorages.names[name]
+}
+
+// instance is a gits instance as seen by the server it got resolved by
+type instance struct {
+	*gits.Gits
+	storages *storageSet
+}
+
+func newStorageSet() *storageSet {
+	return &storageSet{
+		index:           make(map[string]bool),
+		mutex:           &sync.RWMutex{},
+		writeLocks:      make(map[string]*sync.Mutex),
+		writeLocksMutex: &sync.Mutex{},
+	}
+}
+
+// StorageInfo describes a gits instance and its content
+type StorageInfo struct {
+	Name           string
+	Default        bool
+	EntityAmount   int
+	RelationAmount int
+	EntityTypes    map[string]int
+}
+
+// RegisterStorage makes a gits instance created by the host program
+// known to the storage management routes of the server. The default
+// instance is registered automatically when a server is created.
+func (s *Server) RegisterStorage(name string) {
+	s.storages.register(name)
+}
+
+func (set *storageSet) register(name string) {
+	set.mutex.Lock()
+	set.index[name] = true
+	set.mutex.Unlock()
+}
+
+// requestedStorageName returns the name of the gits instance a request
+// is going to be dispatched to, the Storage header or the default one
+func requestedStorageName(r *http.Request) string {
+	if storageValue := r.Header.Get("Storage"); "" != storageValue {
+		return storageValue
+	}
+	if defaultInstance := gits.GetDefault(); nil != defaultInstance {
+		return defaultInstance.Name
+	}
+	return ""
+}
+
+// resolveStorage looks up the named instance once per request. Unknown
+// names are created if STORAGE_AUTO_CREATE is enabled.
+func (s *Server) resolveStorage(name string) (*instance, *apiError) {
+	if "" == name {
+		return nil, newApiError(http.StatusNotFound, CodeStorageNotFound, "No Storage header given and no default storage set", nil)
+	}
+
+	g := s.storages.get(name)
+	if nil == g && s.autoCreateStorages {
+		var apiErr *apiError
+		g, apiErr = s.storages.create(name)
+		if nil != apiErr && CodeStorageExists != apiErr.Code {
+			return nil, apiErr
+		}
+		// a concurrent request might have been faster
+		if nil == g {
+			g = s.storages.get(name)
+		} else {
+			archivist.Info("> Auto created storage", name)
+		}
+	}
+	if nil == g {
+		return nil, storageNotFoundError(name)
+	}
+
+	// instances created by the host program become listable on first use
+	s.storages.mutex.RLock()
+	_, known := s.storages.index[name]
+	s.storages.mutex.RUnlock()
+	if !known {
+		s.storages.register(name)
+	}
+	return g, nil
+}
+
+// storageFromRequest returns the storage resolved by the middleware
+func storageFromRequest(r *http.Request) *instance {
+	g, _ := r.Context().Value(storageContextKey).(*instance)
+	return g
+}
+
+// get returns the named instance if it is usable by the server
+func (set *storageSet) get(name string) *instance {
+	if isDropped(name) {
+		return nil
+	}
+	return set.wrap(gits.GetByName(name))
+}
+
+func (set *storageSet) wrap(g *gits.Gits) *instance {
+	if nil == g {
+		return nil
+	}
+	return &instance{Gits: g, storages: set}
+}
+
+// names returns the sorted names of all not dropped storages
+func (set *storageSet) names() []string {
+	set.mutex.RLock()
+	names := []string{}
+	for name, active := range set.index {
+		if active && !isDropped(name) {
+			names = append(names, name)
+		}
+	}
+	set.mutex.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+func (set *storageSet) list() []StorageInfo {
+	ret := []StorageInfo{}
+	for _, name := range set.names() {
+		if g := set.get(name); nil != g {
+			ret = append(ret, storageInfo(g))
+		}
+	}
+	return ret
+}
+
+func storageInfo(g *instance) StorageInfo {
+	info := StorageInfo{
+		Name:        g.Name,
+		EntityTypes: make(map[string]int),
+	}
+	if defaultInstance := gits.GetDefault(); nil != defaultInstance {
+		info.Default = defaultInstance.Name == g.Name
+	}
+
+	store := g.Storage()
+	store.EntityTypeMutex.RLock()
+	store.EntityStorageMutex.RLock()
+	for typeID, typeName := range store.EntityTypes {
+		amount := len(store.EntityStorage[typeID])
+		info.EntityTypes[typeName] = amount
+		info.EntityAmount += amount
+	}
+	store.EntityStorageMutex.RUnlock()
+	store.EntityTypeMutex.RUnlock()
+
+	store.RelationStorageMutex.RLock()
+	for _, sourceIDs := range store.RelationStorage {
+		for _, targetTypes := range sourceIDs {
+			for _, targetIDs := range targetTypes {
+				info.RelationAmount += len(targetIDs)
+			}
+		}
+	}
+	store.RelationStorageMutex.RUnlock()
+	return info
+}
+
+func (set *storageSet) create(name string) (*instance, *apiError) {
+	if "" == name {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Storage name can't be empty", map[string]string{"param": "name"})
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+	existing := gits.GetByName(name)
+	if nil != existing {
+		// a dropped instance can't be removed from gits, so it gets reused
+		droppedStorages.mutex.Lock()
+		dropped := droppedStorages.names[name]
+		delete(droppedStorages.names, name)
+		droppedStorages.mutex.Unlock()
+		if !dropped {
+			return nil, newApiError(http.StatusConflict, CodeStorageExists, "Storage already exists", map[string]string{"storage": name})
+		}
+		set.index[name] = true
+		return set.wrap(existing), nil
+	}
+
+	set.index[name] = true
+	return set.wrap(gits.NewInstance(name)), nil
+}
+
+func (set *storageSet) setDefault(name string) *apiError {
+	if nil == set.get(name) {
+		return storageNotFoundError(name)
+	}
+	gits.SetDefault(name)
+	set.register(name)
+	return nil
+}
+
+// drop deletes all data of an instance and hides it from all servers
+func (set *storageSet) drop(name string) *apiError {
+	g := set.get(name)
+	if nil == g {
+		return storageNotFoundError(name)
+	}
+	if defaultInstance := gits.GetDefault(); nil != defaultInstance && defaultInstance.Name == name {
+		return newApiError(http.StatusConflict, CodeStorageIsDefault, "The default storage can't be dropped, set another default first", map[string]string{"storage": name})
+	}
+
+	set.mutex.Lock()
+	delete(set.index, name)
+	set.mutex.Unlock()
+	droppedStorages.mutex.Lock()
+	droppedStorages.names[name] = true
+	droppedStorages.mutex.Unlock()
+
+	clearStorage(g)
+	return nil
+}
+
+// clearStorage resets an instance to the state of a fresh one
+func clearStorage(g *instance) {
+	store := g.Storage()
+	store.EntityTypeMutex.Lock()
+	store.EntityStorageMutex.Lock()
+	store.EntityIDMaxMutex.Lock()
+	store.RelationStorageMutex.Lock()
+	store.EntityStorage = make(map[int]map[int]types.StorageEntity)
+	store.EntityIDMax = make(map[int]int)
+	store.EntityTypes = make(map[int]string)
+	store.EntityRTypes = make(map[string]int)
+	store.EntityTypeIDMax = 0
+	store.RelationStorage = make(map[int]map[int]map[int]map[int]types.StorageRelation)
+	store.RelationRStorage = make(map[int]map[int]map[int]map[int]bool)
+	store.RelationStorageMutex.Unlock()
+	store.EntityIDMaxMutex.Unlock()
+	store.EntityStorageMutex.Unlock()
+	store.EntityTypeMutex.Unlock()
+}
@@ -0,0 +1,259 @@
+package gitsapi
+
+import (
+	"sync"
+
+	"github.com/voodooEntity/gits/src/storage"
+	"github.com/voodooEntity/gits/src/types"
+	"github.com/voodooEntity/gitsapi/src/journal"
+)
+
+// storageWrite is held while a storage gets changed. Writes of a
+// storage are serialized, so the journal order equals the order they
+// got applied in. The operation functions taking a *instance begin
+// and end a write around the method of the same name.
+type storageWrite struct {
+	g       *instance
+	lock    *sync.Mutex
+	journal *journal.Journal
+	// set while a transaction runs, it gets journaled on commit
+	undo *undoLog
+}
+
+func (set *storageSet) writeLock(name string) *sync.Mutex {
+	set.writeLocksMutex.Lock()
+	defer set.writeLocksMutex.Unlock()
+	lock, ok := set.writeLocks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		set.writeLocks[name] = lock
+	}
+	return lock
+}
+
+func beginWrite(g *instance) (*storageWrite, *apiError) {
+	lock := g.storages.writeLock(g.Name)
+	lock.Lock()
+	j, apiErr := lockJournal(g)
+	if nil != apiErr {
+		lock.Unlock()
+		return nil, apiErr
+	}
+	return &storageWrite{g: g, lock: lock, journal: j}, nil
+}
+
+func (w *storageWrite) end() {
+	w.journal.Unlock()
+	w.lock.Unlock()
+}
+
+// record journals a successful change, inside a transaction the whole
+// transaction is journaled on commit instead
+func (w *storageWrite) record(op string, data interface{}) *apiError {
+	if w.undo.active() {
+		return nil
+	}
+	return appendJournal(w.journal, op, data)
+}
+
+// beginTransaction begins a write whose changes can be rolled back.
+// Other writes of the storage wait until it ends, reads keep going and
+// can see the changes before they are committed.
+func beginTransaction(g *instance) (*storageWrite, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return nil, apiErr
+	}
+	w.undo = &undoLog{store: g.Storage()}
+	return w, nil
+}
+
+// commit journals the operations of the transaction as one record, if
+// that fails the transaction is rolled back
+func (w *storageWrite) commit(operations []BatchOperation) *apiError {
+	undo := w.undo
+	w.undo = nil
+	if err := w.journal.Append(journalOpTransaction, operations); nil != err {
+		undo.rollback()
+		return internalError("The transaction could not be written to the journal and got rolled back")
+	}
+	return nil
+}
+
+func (w *storageWrite) rollback() {
+	w.undo.rollback()
+	w.undo = nil
+}
+
+// undoLog records the state a transaction is about to change, rollback
+// restores it in reverse order. Entity and type ID counters are
+// restored as well, so a rolled back transaction leaves no trace and
+// journal replays lead to the same IDs.
+type undoLog struct {
+	store *storage.Storage
+	steps []func()
+}
+
+func (u *undoLog) active() bool {
+	return nil != u
+}
+
+func (u *undoLog) rollback() {
+	if nil == u {
+		return
+	}
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		u.steps[i]()
+	}
+	u.steps = nil
+}
+
+// entityType records a type which is going to be created
+func (u *undoLog) entityType(typeStr string) {
+	if nil == u {
+		return
+	}
+	store := u.store
+	store.EntityTypeMutex.RLock()
+	_, exists := store.EntityRTypes[typeStr]
+	typeIDMax := store.EntityTypeIDMax
+	store.EntityTypeMutex.RUnlock()
+	if exists {
+		return
+	}
+
+	u.steps = append(u.steps, func() {
+		store.EntityTypeMutex.Lock()
+		store.EntityStorageMutex.Lock()
+		store.RelationStorageMutex.Lock()
+		if typeID, ok := store.EntityRTypes[typeStr]; ok {
+			delete(store.EntityTypes, typeID)
+			delete(store.EntityRTypes, typeStr)
+			delete(store.EntityStorage, typeID)
+			delete(store.EntityIDMax, typeID)
+			delete(store.RelationStorage, typeID)
+			delete(store.RelationRStorage, typeID)
+		}
+		store.EntityTypeIDMax = typeIDMax
+		store.RelationStorageMutex.Unlock()
+		store.EntityStorageMutex.Unlock()
+		store.EntityTypeMutex.Unlock()
+	})
+}
+
+// newEntity records an entity which is going to be created, it gets the
+// next ID of its type
+func (u *undoLog) newEntity(typeID int) {
+	if nil == u {
+		return
+	}
+	store := u.store
+	store.EntityStorageMutex.RLock()
+	idMax := store.EntityIDMax[typeID]
+	store.EntityStorageMutex.RUnlock()
+
+	u.steps = append(u.steps, func() {
+		store.EntityStorageMutex.Lock()
+		delete(store.EntityStorage[typeID], idMax+1)
+		if _, ok := store.EntityIDMax[typeID]; ok {
+			store.EntityIDMax[typeID] = idMax
+		}
+		store.EntityStorageMutex.Unlock()
+
+		store.RelationStorageMutex.Lock()
+		delete(store.RelationStorage[typeID], idMax+1)
+		delete(store.RelationRStorage[typeID], idMax+1)
+		store.RelationStorageMutex.Unlock()
+	})
+}
+
+// entity records an entity which is going to be updated or deleted
+func (u *undoLog) entity(typeID int, id int) {
+	if nil == u {
+		return
+	}
+	store := u.store
+	store.EntityStorageMutex.RLock()
+	entity, exists := store.EntityStorage[typeID][id]
+	store.EntityStorageMutex.RUnlock()
+	if nil != entity.Properties {
+		entity.Properties = copyStringMap(entity.Properties)
+	}
+
+	u.steps = append(u.steps, func() {
+		store.EntityStorageMutex.Lock()
+		if exists {
+			store.EntityStorage[typeID][id] = entity
+		} else {
+			delete(store.EntityStorage[typeID], id)
+		}
+		store.EntityStorageMutex.Unlock()
+	})
+}
+
+// entityRelations records all relations from and to an entity which is
+// going to be deleted
+func (u *undoLog) entityRelations(typeID int, id int) {
+	if nil == u {
+		return
+	}
+	store := u.store
+	addresses := [][4]int{}
+	store.RelationStorageMutex.RLock()
+	for targetType, targets := range store.RelationStorage[typeID][id] {
+		for targetID := range targets {
+			addresses = append(addresses, [4]int{typeID, id, targetType, targetID})
+		}
+	}
+	for sourceType, sources := range store.RelationRStorage[typeID][id] {
+		for sourceID := range sources {
+			addresses = append(addresses, [4]int{sourceType, sourceID, typeID, id})
+		}
+	}
+	store.RelationStorageMutex.RUnlock()
+
+	for _, address := range addresses {
+		u.relation(address[0], address[1], address[2], address[3])
+	}
+}
+
+// relation records a relation which is going to be created, updated
+// or deleted
+func (u *undoLog) relation(srcType int, srcID int, targetType int, targetID int) {
+	if nil == u {
+		return
+	}
+	store := u.store
+	store.RelationStorageMutex.RLock()
+	relation, exists := store.RelationStorage[srcType][srcID][targetType][targetID]
+	store.RelationStorageMutex.RUnlock()
+	if nil != relation.Properties {
+		relation.Properties = copyStringMap(relation.Properties)
+	}
+
+	u.steps = append(u.steps, func() {
+		store.RelationStorageMutex.Lock()
+		defer store.RelationStorageMutex.Unlock()
+		if !exists {
+			delete(store.RelationStorage[srcType][srcID][targetType], targetID)
+			delete(store.RelationRStorage[targetType][targetID][srcType], srcID)
+			return
+		}
+
+		// the maps of deleted entities might be gone
+		if nil == store.RelationStorage[srcType][srcID] {
+			store.RelationStorage[srcType][srcID] = make(map[int]map[int]types.StorageRelation)
+		}
+		if nil == store.RelationStorage[srcType][srcID][targetType] {
+			store.RelationStorage[srcType][srcID][targetType] = make(map[int]types.StorageRelation)
+		}
+		if nil == store.RelationRStorage[targetType][targetID] {
+			store.RelationRStorage[targetType][targetID] = make(map[int]map[int]bool)
+		}
+		if nil == store.RelationRStorage[targetType][targetID][srcType] {
+			store.RelationRStorage[targetType][targetID][srcType] = make(map[int]bool)
+		}
+		store.RelationStorage[srcType][srcID][targetType][targetID] = relation
+		store.RelationRStorage[targetType][targetID][srcType][srcID] = true
+	})
+}
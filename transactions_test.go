@@ -0,0 +1,241 @@
+package gitsapi
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/snapshot"
+)
+
+// storageState is the complete content of a storage including the
+// reverse relation index and the ID counters
+type storageState struct {
+	snap    snapshot.Snapshot
+	reverse [][4]int
+}
+
+func captureState(g *instance) storageState {
+	snap := snapshot.Capture(g.Name, g.Storage())
+	snap.CreatedAt = time.Time{}
+	sort.Slice(snap.Entities, func(i, j int) bool {
+		a, b := snap.Entities[i], snap.Entities[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(snap.Relations, func(i, j int) bool {
+		a, b := snap.Relations[i], snap.Relations[j]
+		return less4([4]int{a.SourceType, a.SourceID, a.TargetType, a.TargetID}, [4]int{b.SourceType, b.SourceID, b.TargetType, b.TargetID})
+	})
+
+	state := storageState{snap: snap, reverse: [][4]int{}}
+	store := g.Storage()
+	store.RelationStorageMutex.RLock()
+	for targetType, targetIDs := range store.RelationRStorage {
+		for targetID, sourceTypes := range targetIDs {
+			for sourceType, sourceIDs := range sourceTypes {
+				for sourceID := range sourceIDs {
+					state.reverse = append(state.reverse, [4]int{sourceType, sourceID, targetType, targetID})
+				}
+			}
+		}
+	}
+	store.RelationStorageMutex.RUnlock()
+	sort.Slice(state.reverse, func(i, j int) bool { return less4(state.reverse[i], state.reverse[j]) })
+	return state
+}
+
+func less4(a [4]int, b [4]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// newTransactionFixture creates two persons knowing each other and a
+// company one of them works for
+func newTransactionFixture(t *testing.T) *instance {
+	t.Helper()
+	g, apiErr := newStorageSet().create("transactions-" + t.Name())
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	for _, entity := range []transport.TransportEntity{
+		{Type: "Person", Value: "alice", Properties: map[string]string{"age": "30"}},
+		{Type: "Person", Value: "bob"},
+		{Type: "Company", Value: "acme"},
+	} {
+		if _, apiErr := createEntity(g, entity); nil != apiErr {
+			t.Fatal(apiErr)
+		}
+	}
+	for _, relation := range []transport.TransportRelation{
+		{SourceType: "Person", SourceID: 1, TargetType: "Person", TargetID: 2, Context: "knows", Properties: map[string]string{"since": "2020"}},
+		{SourceType: "Person", SourceID: 2, TargetType: "Company", TargetID: 1, Context: "worksFor"},
+	} {
+		if _, apiErr := createRelation(g, relation); nil != apiErr {
+			t.Fatal(apiErr)
+		}
+	}
+	return g
+}
+
+func TestTransactionRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(w *storageWrite) *apiError
+	}{
+		{"create entity type", func(w *storageWrite) *apiError {
+			_, apiErr := w.createEntity(transport.TransportEntity{Type: "Project", Value: "gits"})
+			return apiErr
+		}},
+		{"create entity", func(w *storageWrite) *apiError {
+			_, apiErr := w.createEntity(transport.TransportEntity{Type: "Person", Value: "carol"})
+			return apiErr
+		}},
+		{"update entity", func(w *storageWrite) *apiError {
+			_, apiErr := w.updateEntity(transport.TransportEntity{Type: "Person", ID: 1, Value: "alicia", Properties: map[string]string{"age": "31"}, Version: 1})
+			return apiErr
+		}},
+		{"delete entity with relations", func(w *storageWrite) *apiError {
+			return w.deleteEntity("Person", 2)
+		}},
+		{"create relation", func(w *storageWrite) *apiError {
+			_, apiErr := w.createRelation(transport.TransportRelation{SourceType: "Person", SourceID: 1, TargetType: "Company", TargetID: 1, Context: "worksFor"})
+			return apiErr
+		}},
+		{"update relation", func(w *storageWrite) *apiError {
+			_, apiErr := w.updateRelation(transport.TransportRelation{SourceType: "Person", SourceID: 1, TargetType: "Person", TargetID: 2, Context: "married", Properties: map[string]string{"since": "2024"}, Version: 1})
+			return apiErr
+		}},
+		{"delete relation", func(w *storageWrite) *apiError {
+			return w.deleteRelation("Person", 1, "Person", 2)
+		}},
+		{"several changes", func(w *storageWrite) *apiError {
+			project, apiErr := w.createEntity(transport.TransportEntity{Type: "Project", Value: "gits"})
+			if nil != apiErr {
+				return apiErr
+			}
+			if _, apiErr = w.createRelation(transport.TransportRelation{SourceType: "Person", SourceID: 1, TargetType: "Project", TargetID: project.ID}); nil != apiErr {
+				return apiErr
+			}
+			if apiErr = w.deleteEntity("Person", 1); nil != apiErr {
+				return apiErr
+			}
+			_, apiErr = w.updateEntity(transport.TransportEntity{Type: "Company", ID: 1, Value: "acme inc", Version: 1})
+			return apiErr
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTransactionFixture(t)
+			before := captureState(g)
+
+			w, apiErr := beginTransaction(g)
+			if nil != apiErr {
+				t.Fatal(apiErr)
+			}
+			if apiErr := tc.change(w); nil != apiErr {
+				w.end()
+				t.Fatal(apiErr)
+			}
+			if reflect.DeepEqual(before, captureState(g)) {
+				w.end()
+				t.Fatal("the change didn't change the storage")
+			}
+			w.rollback()
+			w.end()
+
+			if after := captureState(g); !reflect.DeepEqual(before, after) {
+				t.Errorf("storage differs after rollback\nbefore: %+v\nafter:  %+v", before, after)
+			}
+		})
+	}
+}
+
+// a rolled back transaction leaves no trace, so the next entity gets
+// the same ID the rolled back one had
+func TestTransactionRollbackRestoresIDs(t *testing.T) {
+	g := newTransactionFixture(t)
+
+	w, apiErr := beginTransaction(g)
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	rolledBack, apiErr := w.createEntity(transport.TransportEntity{Type: "Person", Value: "carol"})
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	w.rollback()
+	w.end()
+
+	created, apiErr := createEntity(g, transport.TransportEntity{Type: "Person", Value: "dave"})
+	if nil != apiErr {
+		t.Fatal(apiErr)
+	}
+	if rolledBack.ID != created.ID {
+		t.Errorf("expected ID %d after rollback, got %d", rolledBack.ID, created.ID)
+	}
+}
+
+func TestAtomicBatchRollback(t *testing.T) {
+	_, ts := newTestServer(t, map[string]string{"STORAGE_AUTO_CREATE": "true"})
+	headers := map[string]string{"Storage": "atomic-batch"}
+	for _, entity := range []string{`{"Type":"Person","Value":"alice"}`, `{"Type":"Person","Value":"bob"}`} {
+		if resp := call(t, ts, "POST", "/v1/createEntity", headers, entity, nil); 200 != resp.StatusCode {
+			t.Fatalf("createEntity answered %d", resp.StatusCode)
+		}
+	}
+
+	// the third operation fails, the earlier ones have to be undone
+	batch := `{"Atomic":true,"Operations":[
+		{"Op":"createEntity","Data":{"Type":"Person","Value":"carol"}},
+		{"Op":"updateEntity","Data":{"Type":"Person","ID":1,"Value":"alicia","Version":1}},
+		{"Op":"updateEntity","Data":{"Type":"Person","ID":99,"Value":"nobody","Version":1}},
+		{"Op":"createEntity","Data":{"Type":"Person","Value":"dave"}}
+	]}`
+	var results []struct {
+		Status int
+		Error  *apiError
+	}
+	resp := call(t, ts, "POST", "/v1/batch", headers, batch, &results)
+	if 404 != resp.StatusCode {
+		t.Fatalf("expected the status of the failed operation, got %d", resp.StatusCode)
+	}
+	expected := []int{424, 424, 404, 424}
+	if len(expected) != len(results) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, status := range expected {
+		if status != results[i].Status {
+			t.Errorf("operation %d: expected status %d, got %d", i, status, results[i].Status)
+		}
+	}
+
+	var entities struct {
+		Entities []transport.TransportEntity
+	}
+	call(t, ts, "GET", "/v1/getEntitiesByType?type=Person", headers, "", &entities)
+	if 2 != len(entities.Entities) {
+		t.Fatalf("expected 2 entities after rollback, got %d", len(entities.Entities))
+	}
+	for _, entity := range entities.Entities {
+		if 1 == entity.ID && ("alice" != entity.Value || 1 != entity.Version) {
+			t.Errorf("update was not rolled back: %+v", entity)
+		}
+	}
+
+	// the ID taken by the rolled back create is free again
+	var created struct {
+		Entities []transport.TransportEntity
+	}
+	call(t, ts, "POST", "/v1/createEntity", headers, `{"Type":"Person","Value":"erin"}`, &created)
+	if 1 != len(created.Entities) || 3 != created.Entities[0].ID {
+		t.Errorf("expected the next entity to get ID 3, got %+v", created.Entities)
+	}
+}
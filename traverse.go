@@ -0,0 +1,297 @@
+package gitsapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gits/src/types"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// the directions relations are followed in, out leads from source to
+// target like getChildEntities does
+const (
+	DirectionOut  = "out"
+	DirectionIn   = "in"
+	DirectionBoth = "both"
+)
+
+// TraversalEntity is an entity reached by a traversal, Depth is the
+// least amount of hops it is away from the start
+type TraversalEntity struct {
+	transport.TransportEntity
+	Depth int
+}
+
+// TraversalResult is the subgraph reached by /v1/traverse, it has the
+// form of a transport.Transport with depth annotated entities
+type TraversalResult struct {
+	Entities  []TraversalEntity
+	Relations []transport.TransportRelation
+	Amount    int
+}
+
+func (s *Server) registerTraverseRoutes() {
+	// Route: /v1/traverse
+	s.handleFunc("/v1/traverse", s.requireScope(auth.ScopeRead, handleTraverse))
+}
+
+func handleTraverse(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "GET" != r.Method {
+		respondError(methodNotAllowedError("GET"), w)
+		return
+	}
+
+	urlParams, apiErr := getRequiredUrlParams(map[string]string{"type": "", "id": ""}, r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	id, apiErr := getIntUrlParam(urlParams, "id")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	urlParams = getOptionalUrlParams(map[string]string{"maxDepth": ""}, urlParams, r)
+	maxDepth := 1
+	if "" != urlParams["maxDepth"] {
+		if maxDepth, apiErr = getIntUrlParam(urlParams, "maxDepth"); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		if 1 > maxDepth {
+			respondError(newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param 'maxDepth' given, it has to be at least 1", map[string]string{"param": "maxDepth"}), w)
+			return
+		}
+	}
+
+	if apiErr := authorize(r, auth.ActionRead, urlParams["type"]); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	walk, apiErr := newGraphWalk(storageFromRequest(r), grantFromRequest(r), r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	start, apiErr := walk.node(urlParams["type"], id)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	result, apiErr := walk.traverse(start, maxDepth)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respondJson(result, 200, w)
+}
+
+// traverse collects the entities within maxDepth hops of the start by
+// breadth first search, so every entity is reached on its shortest way.
+// Relations are collected while leaving the entities before maxDepth.
+func (walk *graphWalk) traverse(start graphNode, maxDepth int) (TraversalResult, *apiError) {
+	result := TraversalResult{
+		Entities:  []TraversalEntity{},
+		Relations: []transport.TransportRelation{},
+	}
+	depths := map[graphNode]int{start: 0}
+	seenRelations := make(map[[2]graphNode]bool)
+	queue := []graphNode{start}
+	for 0 < len(queue) {
+		node := queue[0]
+		queue = queue[1:]
+
+		entity, err := walk.entity(node)
+		if nil != err {
+			// the start has to exist, others may just got deleted meanwhile
+			if node == start {
+				return result, storageError(err)
+			}
+			continue
+		}
+		result.Entities = append(result.Entities, TraversalEntity{TransportEntity: entity, Depth: depths[node]})
+		if maxDepth == depths[node] {
+			continue
+		}
+
+		steps, apiErr := walk.steps(node)
+		if nil != apiErr {
+			return result, apiErr
+		}
+		for _, step := range steps {
+			// with direction both a relation is seen from both of its ends
+			if !seenRelations[step.key] {
+				seenRelations[step.key] = true
+				result.Relations = append(result.Relations, step.relation)
+			}
+			if _, ok := depths[step.node]; !ok {
+				depths[step.node] = depths[node] + 1
+				queue = append(queue, step.node)
+			}
+		}
+	}
+	result.Amount = len(result.Entities)
+	return result, nil
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// graph walking, shared by the routes following
+// relations across several hops
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// graphNode addresses an entity by its type id
+type graphNode struct {
+	typeID int
+	id     int
+}
+
+// graphStep is a relation leading from an entity to a neighbour, key
+// identifies the relation by its source and target
+type graphStep struct {
+	relation transport.TransportRelation
+	node     graphNode
+	key      [2]graphNode
+}
+
+// graphWalk holds the options of a walk. Entities of types the caller may
+// not read or which are not in types are neither returned nor passed.
+type graphWalk struct {
+	g           *instance
+	grant       *auth.Grant
+	direction   string
+	context     string
+	types       map[string]bool
+	entityTypes map[int]string
+	// the relation property paths are weighted by
+	weight string
+	// entities which may be reached whatever types allows
+	ends []graphNode
+}
+
+// newGraphWalk reads the optional url params direction, context and types
+func newGraphWalk(g *instance, grant *auth.Grant, r *http.Request) (*graphWalk, *apiError) {
+	urlParams := getOptionalUrlParams(map[string]string{"direction": "", "context": "", "types": ""}, make(map[string]string), r)
+	walk := &graphWalk{
+		g:           g,
+		grant:       grant,
+		direction:   DirectionBoth,
+		context:     urlParams["context"],
+		entityTypes: g.Storage().GetEntityTypes(),
+	}
+	if "" != urlParams["direction"] {
+		walk.direction = urlParams["direction"]
+	}
+	if DirectionOut != walk.direction && DirectionIn != walk.direction && DirectionBoth != walk.direction {
+		return nil, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid param 'direction' given, expected out, in or both", map[string]string{"param": "direction"})
+	}
+	if "" != urlParams["types"] {
+		walk.types = make(map[string]bool)
+		for _, typeStr := range strings.Split(urlParams["types"], ",") {
+			if typeStr = strings.TrimSpace(typeStr); "" != typeStr {
+				walk.types[typeStr] = true
+			}
+		}
+	}
+	return walk, nil
+}
+
+// node resolves the address of an entity given by url params
+func (walk *graphWalk) node(typeStr string, id int) (graphNode, *apiError) {
+	typeID, err := walk.g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		return graphNode{}, storageError(err)
+	}
+	return graphNode{typeID: typeID, id: id}, nil
+}
+
+func (walk *graphWalk) passes(node graphNode) bool {
+	for _, end := range walk.ends {
+		if end == node {
+			return true
+		}
+	}
+	typeStr := walk.entityTypes[node.typeID]
+	if nil != walk.types && !walk.types[typeStr] {
+		return false
+	}
+	return walk.grant.Allows(auth.ActionRead, typeStr)
+}
+
+func (walk *graphWalk) entity(node graphNode) (transport.TransportEntity, error) {
+	entity, err := walk.g.Storage().GetEntityByPath(node.typeID, node.id, "")
+	if nil != err {
+		return transport.TransportEntity{}, err
+	}
+	return transport.TransportEntity{
+		ID:         entity.ID,
+		Type:       walk.entityTypes[node.typeID],
+		Value:      entity.Value,
+		Context:    entity.Context,
+		Properties: entity.Properties,
+		Version:    entity.Version,
+	}, nil
+}
+
+// steps lists the relations of the entity in the direction of the walk,
+// ordered by the neighbours so walks are repeatable
+func (walk *graphWalk) steps(node graphNode) ([]graphStep, *apiError) {
+	ret := []graphStep{}
+	if DirectionIn != walk.direction {
+		relations, err := walk.g.Storage().GetChildRelationsBySourceTypeAndSourceId(node.typeID, node.id, walk.context)
+		if nil != err {
+			return nil, storageError(err)
+		}
+		for _, relation := range relations {
+			target := graphNode{typeID: relation.TargetType, id: relation.TargetID}
+			if walk.passes(target) {
+				ret = append(ret, walk.step(relation, target))
+			}
+		}
+	}
+	if DirectionOut != walk.direction {
+		relations, err := walk.g.Storage().GetParentRelationsByTargetTypeAndTargetId(node.typeID, node.id, walk.context)
+		if nil != err {
+			return nil, storageError(err)
+		}
+		for _, relation := range relations {
+			source := graphNode{typeID: relation.SourceType, id: relation.SourceID}
+			if walk.passes(source) {
+				ret = append(ret, walk.step(relation, source))
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].node != ret[j].node {
+			return ret[i].node.typeID < ret[j].node.typeID || (ret[i].node.typeID == ret[j].node.typeID && ret[i].node.id < ret[j].node.id)
+		}
+		return ret[i].key[0].typeID < ret[j].key[0].typeID || (ret[i].key[0].typeID == ret[j].key[0].typeID && ret[i].key[0].id < ret[j].key[0].id)
+	})
+	return ret, nil
+}
+
+func (walk *graphWalk) step(relation types.StorageRelation, next graphNode) graphStep {
+	return graphStep{
+		relation: transport.TransportRelation{
+			SourceType: walk.entityTypes[relation.SourceType],
+			SourceID:   relation.SourceID,
+			TargetType: walk.entityTypes[relation.TargetType],
+			TargetID:   relation.TargetID,
+			Context:    relation.Context,
+			Properties: relation.Properties,
+			Version:    relation.Version,
+		},
+		node: next,
+		key: [2]graphNode{
+			{typeID: relation.SourceType, id: relation.SourceID},
+			{typeID: relation.TargetType, id: relation.TargetID},
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package gitsapi
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+// upsert strategies, they decide how the properties of an existing
+// entity are combined with the given ones
+const (
+	UpsertReplace = "replace"
+	UpsertMerge   = "merge"
+	UpsertKeep    = "keep"
+)
+
+// UpsertRequest is the body of /v1/upsertEntity. The entity is looked
+// up by Type and Value, and by Context if one is given.
+type UpsertRequest struct {
+	Type       string
+	Value      string
+	Context    string
+	Properties map[string]string
+	// one of replace, merge (default) or keep
+	Strategy string
+}
+
+// UpsertResult tells if the entity got created or an existing one
+// has been used
+type UpsertResult struct {
+	Created bool
+	Entity  transport.TransportEntity
+}
+
+func (s *Server) registerUpsertRoutes() {
+	// Route: /v1/upsertEntity
+	s.handleFunc("/v1/upsertEntity", s.requireScope(auth.ScopeWrite, handleUpsertEntity))
+}
+
+func handleUpsertEntity(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	// check http method
+	if "PUT" != r.Method {
+		respondError(methodNotAllowedError("PUT"), w)
+		return
+	}
+
+	var upsert UpsertRequest
+	if apiErr := decodeJsonBody(r, &upsert); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	// whether it creates or updates depends on the storage, both have to be granted
+	if apiErr := authorize(r, auth.ActionCreate, upsert.Type); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if apiErr := authorize(r, auth.ActionUpdate, upsert.Type); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	result, apiErr := upsertEntity(storageFromRequest(r), upsert)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	setEntityTag(w, result.Entity.Version)
+	if result.Created {
+		respondJson(result, http.StatusCreated, w)
+		return
+	}
+	respondJson(result, 200, w)
+}
+
+func upsertEntity(g *instance, upsert UpsertRequest) (UpsertResult, *apiError) {
+	w, apiErr := beginWrite(g)
+	if nil != apiErr {
+		return UpsertResult{}, apiErr
+	}
+	defer w.end()
+	return w.upsertEntity(upsert)
+}
+
+// upsertEntity creates the entity or updates the properties of the
+// existing one. Lookup and change happen within the write, so parallel
+// upserts of the same entity can't create it twice. An existing entity
+// whose properties wouldn't change is left as it is.
+func (w *storageWrite) upsertEntity(upsert UpsertRequest) (UpsertResult, *apiError) {
+	if "" == upsert.Type {
+		return UpsertResult{}, newApiError(http.StatusBadRequest, CodeMissingParameter, "Missing entity type", map[string]string{"field": "Type"})
+	}
+	if "" == upsert.Strategy {
+		upsert.Strategy = UpsertMerge
+	}
+	if UpsertReplace != upsert.Strategy && UpsertMerge != upsert.Strategy && UpsertKeep != upsert.Strategy {
+		return UpsertResult{}, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Unknown strategy '"+upsert.Strategy+"', expected replace, merge or keep", map[string]string{"field": "Strategy"})
+	}
+
+	matches := []transport.TransportEntity{}
+	if _, err := w.g.Storage().GetTypeIdByString(upsert.Type); nil == err {
+		entities, err := w.g.Storage().GetEntitiesByTypeAndValue(upsert.Type, upsert.Value, "match", upsert.Context)
+		if nil != err {
+			return UpsertResult{}, storageError(err)
+		}
+		for _, entity := range entities {
+			matches = append(matches, transport.TransportEntity{
+				Type:       upsert.Type,
+				ID:         entity.ID,
+				Value:      entity.Value,
+				Context:    entity.Context,
+				Properties: entity.Properties,
+				Version:    entity.Version,
+			})
+		}
+	}
+
+	if 0 == len(matches) {
+		entity, apiErr := w.createEntity(transport.TransportEntity{
+			Type:       upsert.Type,
+			Value:      upsert.Value,
+			Context:    upsert.Context,
+			Properties: upsert.Properties,
+		})
+		return UpsertResult{Created: true, Entity: entity}, apiErr
+	}
+
+	// duplicates created before resolve to the oldest entity
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].ID < matches[j].ID
+	})
+	entity := matches[0]
+	properties := upsertProperties(entity.Properties, upsert.Properties, upsert.Strategy)
+	if equalProperties(entity.Properties, properties) {
+		return UpsertResult{Entity: entity}, nil
+	}
+	entity.Properties = properties
+	entity, apiErr := w.updateEntity(entity)
+	return UpsertResult{Entity: entity}, apiErr
+}
+
+// upsertProperties combines the stored and the given properties
+func upsertProperties(stored map[string]string, given map[string]string, strategy string) map[string]string {
+	if UpsertReplace == strategy {
+		return copyStringMap(given)
+	}
+	ret := copyStringMap(stored)
+	for key, value := range given {
+		if _, exists := ret[key]; exists && UpsertKeep == strategy {
+			continue
+		}
+		ret[key] = value
+	}
+	return ret
+}
+
+func equalProperties(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,417 @@
+package gitsapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/voodooEntity/gits/src/transport"
+	"github.com/voodooEntity/gitsapi/src/auth"
+)
+
+func (s *Server) registerV2Routes() {
+	// Route: /v2/entities/{type}[/{id}[/children]]
+	s.handleFunc("/v2/entities/", s.requireScopeFunc(scopeByMethod, handleV2Entities))
+
+	// Route: /v2/relations/{srcType}/{srcID}/{targetType}/{targetID}
+	s.handleFunc("/v2/relations/", s.requireScopeFunc(scopeByMethod, handleV2Relations))
+
+	// Route: /v2/ catches every unknown path below /v2
+	s.handleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		respondError(routeNotFoundError(r), w)
+	})
+}
+
+func handleV2Entities(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	segments, apiErr := getPathParams(r, "/v2/entities/")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if 0 < len(segments) {
+		if apiErr := authorize(r, actionByMethod(r), segments[0]); nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+	}
+
+	switch {
+	case 1 == len(segments):
+		// collection of an entity type
+		switch r.Method {
+		case "GET":
+			v2ListEntities(w, r, segments[0])
+		case "POST":
+			v2CreateEntity(w, r, segments[0])
+		default:
+			respondError(methodNotAllowedError("GET, POST"), w)
+		}
+	case 2 == len(segments):
+		// a single entity
+		id, apiErr := getIntPathParam(segments[1], "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			v2GetEntity(w, r, segments[0], id)
+		case "PUT":
+			v2ReplaceEntity(w, r, segments[0], id)
+		case "PATCH":
+			v2PatchEntity(w, r, segments[0], id)
+		case "DELETE":
+			v2DeleteEntity(w, r, segments[0], id)
+		default:
+			respondError(methodNotAllowedError("GET, PUT, PATCH, DELETE"), w)
+		}
+	case 3 == len(segments) && "children" == segments[2]:
+		id, apiErr := getIntPathParam(segments[1], "id")
+		if nil != apiErr {
+			respondError(apiErr, w)
+			return
+		}
+		if "GET" != r.Method {
+			respondError(methodNotAllowedError("GET"), w)
+			return
+		}
+		v2GetChildEntities(w, r, segments[0], id)
+	default:
+		respondError(routeNotFoundError(r), w)
+	}
+}
+
+func handleV2Relations(w http.ResponseWriter, r *http.Request) {
+	if handlePreflight(w, r) {
+		return
+	}
+
+	segments, apiErr := getPathParams(r, "/v2/relations/")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if 4 != len(segments) {
+		respondError(routeNotFoundError(r), w)
+		return
+	}
+
+	srcID, apiErr := getIntPathParam(segments[1], "srcID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	targetID, apiErr := getIntPathParam(segments[3], "targetID")
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	address := transport.TransportRelation{
+		SourceType: segments[0],
+		SourceID:   srcID,
+		TargetType: segments[2],
+		TargetID:   targetID,
+	}
+
+	// changing a relation is an update of both of its ends
+	action := auth.ActionUpdate
+	if "GET" == r.Method {
+		action = auth.ActionRead
+	}
+	if apiErr := authorize(r, action, address.SourceType, address.TargetType); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		v2GetRelation(w, r, address)
+	case "POST":
+		v2CreateRelation(w, r, address)
+	case "PUT":
+		v2ReplaceRelation(w, r, address)
+	case "PATCH":
+		v2PatchRelation(w, r, address)
+	case "DELETE":
+		v2DeleteRelation(w, r, address)
+	default:
+		respondError(methodNotAllowedError("GET, POST, PUT, PATCH, DELETE"), w)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// entities
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func v2ListEntities(w http.ResponseWriter, r *http.Request, typeStr string) {
+	listParams, apiErr := getListParams(r)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	entities, apiErr := readEntitiesByType(storageFromRequest(r), typeStr, r.URL.Query().Get("context"))
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respondEntities(entities, listParams, w)
+}
+
+func v2CreateEntity(w http.ResponseWriter, r *http.Request, typeStr string) {
+	var newEntity transport.TransportEntity
+	if apiErr := decodeJsonBody(r, &newEntity); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	newEntity.Type = typeStr
+
+	entity, apiErr := createEntity(storageFromRequest(r), newEntity)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	w.Header().Set("Location", entityPath(entity.Type, entity.ID))
+	respondTransport(transport.Transport{
+		Entities: []transport.TransportEntity{entity},
+	}, http.StatusCreated, w)
+}
+
+func v2GetEntity(w http.ResponseWriter, r *http.Request, typeStr string, id int) {
+	entity, apiErr := readEntity(storageFromRequest(r), typeStr, id)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if respondNotModified(w, r, entity.Version) {
+		return
+	}
+	respondOk(transport.Transport{
+		Entities: []transport.TransportEntity{entity},
+	}, w)
+}
+
+func v2ReplaceEntity(w http.ResponseWriter, r *http.Request, typeStr string, id int) {
+	var newEntity transport.TransportEntity
+	if apiErr := decodeJsonBody(r, &newEntity); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	newEntity.Type = typeStr
+	newEntity.ID = id
+
+	// without a given version or If-Match we overwrite whatever is stored
+	var entity transport.TransportEntity
+	var apiErr *apiError
+	ifMatch := r.Header.Get("If-Match")
+	if 0 == newEntity.Version && "" == ifMatch {
+		entity, apiErr = replaceEntity(storageFromRequest(r), newEntity)
+	} else {
+		entity, apiErr = updateEntityIfMatch(storageFromRequest(r), ifMatch, newEntity)
+	}
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, entity.Version)
+	respondOk(transport.Transport{
+		Entities: []transport.TransportEntity{entity},
+	}, w)
+}
+
+func v2PatchEntity(w http.ResponseWriter, r *http.Request, typeStr string, id int) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	body, err := getRequestBody(r)
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+	entity, apiErr := patchEntity(storageFromRequest(r), r.Header.Get("If-Match"), r.Header.Get("Content-Type"), typeStr, id, body)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, entity.Version)
+	respondOk(transport.Transport{
+		Entities: []transport.TransportEntity{entity},
+	}, w)
+}
+
+func v2DeleteEntity(w http.ResponseWriter, r *http.Request, typeStr string, id int) {
+	if apiErr := deleteEntityIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), typeStr, id); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respond("", http.StatusNoContent, w)
+}
+
+func v2GetChildEntities(w http.ResponseWriter, r *http.Request, typeStr string, id int) {
+	g := storageFromRequest(r)
+	typeID, err := g.Storage().GetTypeIdByString(typeStr)
+	if nil != err {
+		respondError(storageError(err), w)
+		return
+	}
+	// unlike v1 an unknown parent is reported instead of an empty list
+	if !g.Storage().EntityExists(typeID, id) {
+		respondError(entityNotFoundError(), w)
+		return
+	}
+
+	entities, apiErr := readChildEntities(g, typeStr, id, r.URL.Query().Get("context"))
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respondOk(transport.Transport{
+		Entities: filterEntities(grantFromRequest(r), entities),
+	}, w)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// relations
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+func v2GetRelation(w http.ResponseWriter, r *http.Request, address transport.TransportRelation) {
+	relation, apiErr := readRelation(storageFromRequest(r), address.SourceType, address.SourceID, address.TargetType, address.TargetID)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	if respondNotModified(w, r, relation.Version) {
+		return
+	}
+	respondOk(transport.Transport{
+		Relations: []transport.TransportRelation{relation},
+	}, w)
+}
+
+func v2CreateRelation(w http.ResponseWriter, r *http.Request, address transport.TransportRelation) {
+	var newRelation transport.TransportRelation
+	if apiErr := decodeJsonBody(r, &newRelation); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	newRelation = withRelationAddress(newRelation, address)
+
+	relation, apiErr := createRelation(storageFromRequest(r), newRelation)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+
+	w.Header().Set("Location", r.URL.Path)
+	respondTransport(transport.Transport{
+		Relations: []transport.TransportRelation{relation},
+	}, http.StatusCreated, w)
+}
+
+func v2ReplaceRelation(w http.ResponseWriter, r *http.Request, address transport.TransportRelation) {
+	var newRelation transport.TransportRelation
+	if apiErr := decodeJsonBody(r, &newRelation); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	newRelation = withRelationAddress(newRelation, address)
+
+	// without a given version or If-Match we overwrite whatever is stored
+	var relation transport.TransportRelation
+	var apiErr *apiError
+	ifMatch := r.Header.Get("If-Match")
+	if 0 == newRelation.Version && "" == ifMatch {
+		relation, apiErr = replaceRelation(storageFromRequest(r), newRelation)
+	} else {
+		relation, apiErr = updateRelationIfMatch(storageFromRequest(r), ifMatch, newRelation)
+	}
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, relation.Version)
+	respondOk(transport.Transport{
+		Relations: []transport.TransportRelation{relation},
+	}, w)
+}
+
+func v2PatchRelation(w http.ResponseWriter, r *http.Request, address transport.TransportRelation) {
+	w.Header().Set("Accept-Patch", acceptPatch)
+	body, err := getRequestBody(r)
+	if nil != err {
+		respondError(malformedBodyError(err), w)
+		return
+	}
+	relation, apiErr := patchRelation(storageFromRequest(r), r.Header.Get("If-Match"), r.Header.Get("Content-Type"), address, body)
+	if nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	setEntityTag(w, relation.Version)
+	respondOk(transport.Transport{
+		Relations: []transport.TransportRelation{relation},
+	}, w)
+}
+
+func v2DeleteRelation(w http.ResponseWriter, r *http.Request, address transport.TransportRelation) {
+	if apiErr := deleteRelationIfMatch(storageFromRequest(r), r.Header.Get("If-Match"), address.SourceType, address.SourceID, address.TargetType, address.TargetID); nil != apiErr {
+		respondError(apiErr, w)
+		return
+	}
+	respond("", http.StatusNoContent, w)
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+// helpers
+// - - - - - - - - - - - - - - - - - - - - - - - - - -
+
+// getPathParams returns the unescaped path segments following the prefix
+func getPathParams(r *http.Request, prefix string) ([]string, *apiError) {
+	rawPath := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if "" == rawPath {
+		return []string{}, nil
+	}
+
+	segments := strings.Split(rawPath, "/")
+	for i, segment := range segments {
+		unescaped, err := url.PathUnescape(segment)
+		if nil != err || "" == unescaped {
+			return nil, newApiError(http.StatusBadRequest, CodeInvalidParameter, "Invalid path segment given", map[string]string{"segment": segment})
+		}
+		segments[i] = unescaped
+	}
+	return segments, nil
+}
+
+func getIntPathParam(segment string, paramName string) (int, *apiError) {
+	return getIntUrlParam(map[string]string{paramName: segment}, paramName)
+}
+
+func decodeJsonBody(r *http.Request, target interface{}) *apiError {
+	body, err := getRequestBody(r)
+	if nil != err {
+		return malformedBodyError(err)
+	}
+	if err = json.Unmarshal(body, target); nil != err {
+		return malformedBodyError(err)
+	}
+	return nil
+}
+
+func entityPath(typeStr string, id int) string {
+	return "/v2/entities/" + url.PathEscape(typeStr) + "/" + strconv.Itoa(id)
+}
+
+// withRelationAddress takes the relation address from the path and
+// everything else from the given relation
+func withRelationAddress(relation transport.TransportRelation, address transport.TransportRelation) transport.TransportRelation {
+	relation.SourceType = address.SourceType
+	relation.SourceID = address.SourceID
+	relation.TargetType = address.TargetType
+	relation.TargetID = address.TargetID
+	return relation
+}